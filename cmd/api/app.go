@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,19 +14,27 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/micahco/api/internal/data"
 	"github.com/micahco/api/internal/mailer"
 )
 
 type application struct {
-	config config
-	logger *slog.Logger
-	mailer *mailer.Mailer
-	models data.Models
-	wg     sync.WaitGroup
+	config   config
+	logger   *slog.Logger
+	mailer   *mailer.Mailer
+	inbox    *mailer.Inbox
+	models   data.Models
+	webauthn *webauthn.WebAuthn
+	wg       sync.WaitGroup
 }
 
 func (app *application) serve(errLog *log.Logger) error {
+	// Parent of every request context, cancelled once shutdown is
+	// over so queries still running are abandoned
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
@@ -33,10 +42,20 @@ func (app *application) serve(errLog *log.Logger) error {
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return requests
+		},
 	}
 
 	shutdownError := make(chan error)
 
+	// Background workers run until the server shuts down
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	app.startCleanup(workers)
+	app.startJobs(workers)
+
 	go func() {
 		// Intercept signals
 		quit := make(chan os.Signal, 1)
@@ -49,12 +68,17 @@ func (app *application) serve(errLog *log.Logger) error {
 		defer cancel()
 
 		err := srv.Shutdown(ctx)
+		cancelRequests()
 		if err != nil {
 			shutdownError <- err
 		}
 
 		app.logger.Info("completing background tasks", slog.String("addr", srv.Addr))
 
+		// Workers stop claiming jobs, and the WaitGroup drains
+		// the jobs that are in flight
+		stopWorkers()
+
 		// Block until WaitGroup is zero
 		app.wg.Wait()
 		shutdownError <- nil
@@ -78,30 +102,10 @@ func (app *application) serve(errLog *log.Logger) error {
 	return nil
 }
 
-func (app *application) background(fn func() error) {
-	app.wg.Add(1)
-
-	go func() {
-		defer app.wg.Done()
-
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.Error("background process recovered from panic", slog.Any("err", err))
-			}
-		}()
-
-		if err := fn(); err != nil {
-			app.logger.Error("background process returned error", slog.Any("err", err))
-		}
-	}()
-}
-
-func (app *application) sendMail(recepient string, tmpl string, data map[string]any) error {
+func (app *application) sendMail(recepient, locale, tmpl string, data map[string]any) error {
 	if app.config.dev {
-		app.logger.Debug("mail to "+recepient, slog.Any("data", data))
-
-		return nil
+		app.logger.Debug("mail to "+recepient, slog.String("locale", locale), slog.Any("data", data))
 	}
 
-	return app.mailer.Send(recepient, tmpl, data)
+	return app.mailer.Send(recepient, locale, tmpl, data)
 }
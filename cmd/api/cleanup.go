@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"time"
+)
+
+// Expired rows deleted by table, and cleanup runs by outcome
+var (
+	cleanupDeleted = expvar.NewMap("cleanup_deleted")
+	cleanupRuns    = expvar.NewMap("cleanup_runs")
+)
+
+// Periodically delete expired rows until ctx is cancelled. Only one
+// replica cleans up at a time, the others skip their turn.
+func (app *application) startCleanup(ctx context.Context) {
+	interval := app.config.cleanup.interval
+	if interval <= 0 {
+		return
+	}
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			app.cleanup(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (app *application) cleanup(ctx context.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error("cleanup recovered from panic", slog.Any("err", err))
+		}
+	}()
+
+	deleted, ok, err := app.models.Cleanup.DeleteExpired(ctx)
+
+	for table, n := range deleted {
+		cleanupDeleted.Add(table, n)
+	}
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		cleanupRuns.Add("cancelled", 1)
+	case err != nil:
+		cleanupRuns.Add("failed", 1)
+		app.logger.Error("cleanup of expired rows failed", slog.Any("err", err))
+	case !ok:
+		cleanupRuns.Add("skipped", 1)
+	default:
+		cleanupRuns.Add("completed", 1)
+		app.logger.Debug("cleaned up expired rows", slog.Any("deleted", deleted))
+	}
+}
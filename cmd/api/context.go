@@ -9,7 +9,11 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	scopesContextKey = contextKey("scopes")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +28,30 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetToken(r *http.Request, token *data.AuthenticationToken) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetToken(r *http.Request) *data.AuthenticationToken {
+	token, ok := r.Context().Value(tokenContextKey).(*data.AuthenticationToken)
+	if !ok {
+		panic("missing token value in request context")
+	}
+
+	return token
+}
+
+// Set the scopes granted to a personal access token. Requests
+// without scopes in context are not restricted.
+func (app *application) contextSetScopes(r *http.Request, scopes []string) *http.Request {
+	ctx := context.WithValue(r.Context(), scopesContextKey, scopes)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetScopes(r *http.Request) []string {
+	scopes, _ := r.Context().Value(scopesContextKey).([]string)
+
+	return scopes
+}
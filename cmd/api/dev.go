@@ -0,0 +1,55 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Messages kept by the development inbox
+const devInboxSize = 100
+
+var devMailTemplate = template.Must(template.New("mail").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Development inbox</title>
+<style>
+body { font-family: sans-serif; margin: 24px; }
+article { border-bottom: 1px solid #ddd; padding: 12px 0; }
+pre { white-space: pre-wrap; background: #f4f4f5; padding: 12px; }
+</style>
+</head>
+<body>
+<h1>Development inbox</h1>
+<form><input type="email" name="to" value="{{.To}}" placeholder="Recipient"> <button>Filter</button></form>
+{{range .Messages}}
+<article>
+<h2>{{.Subject}}</h2>
+<p>To {{.To}} ({{.Locale}}) at {{.SentAt.Format "2006-01-02 15:04:05"}}, {{.Template}}</p>
+<pre>{{.Text}}</pre>
+</article>
+{{else}}
+<p>No mail.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// List mail captured by the development inbox, newest first, as JSON
+// or as HTML for browsers. Filter by recipient with the to parameter.
+func (app *application) devMailGet(w http.ResponseWriter, r *http.Request) error {
+	to := r.URL.Query().Get("to")
+	messages := app.inbox.Messages(to)
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return app.writeJSON(w, http.StatusOK, envelope{"messages": messages}, nil)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	return devMailTemplate.Execute(w, map[string]any{
+		"To":       to,
+		"Messages": messages,
+	})
+}
@@ -6,16 +6,32 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/micahco/api/internal/data"
+	"github.com/tomasen/realip"
 )
 
 const (
 	InvalidCredentailsMessage         = "invalid credentials"
 	InvalidAuthenticationTokenMessage = "invalid or expired authentication token"
+	InvalidRefreshTokenMessage        = "invalid or expired refresh token"
 	AuthenticationRequiredMessage     = "you must be authenticated to access this resource"
 	RateLimitExceededMessage          = "rate limit exceeded"
+	LoginThrottledMessage             = "too many failed login attempts, try again later"
+	TooManyAttemptsMessage            = "too many failed attempts, please request a new token"
+	InsufficientScopeMessage          = "your token does not grant access to this resource"
+	InvalidMFAChallengeMessage        = "invalid or expired mfa challenge token"
+	InvalidMFACodeMessage             = "invalid two-factor authentication code"
+	MFAEnabledMessage                 = "two-factor authentication is already enabled"
+	InvalidWebAuthnSessionMessage     = "invalid or expired webauthn session token"
+	InvalidWebAuthnMessage            = "invalid webauthn credential"
+	DuplicateCredentialMessage        = "credential is already registered"
+	RecentAuthRequiredMessage         = "you must reauthenticate to perform this action"
 )
 
 type envelope map[string]any
@@ -29,13 +45,30 @@ func (app *application) handle(h withError) http.HandlerFunc {
 			var validationError validation.Errors
 			switch {
 			case errors.As(err, &validationError):
-				app.errorResponse(w, http.StatusUnprocessableEntity, validationError)
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, app.translateErrors(r, validationError))
 			default:
-				app.serverErrorResponse(w, "handled unexpected error", err)
+				app.serverErrorResponse(w, r, "handled unexpected error", err)
 			}
 		}
 	}
 }
+
+// Translate the messages of validation errors, and of the errors
+// nested in them, for the response to r.
+func (app *application) translateErrors(r *http.Request, errs validation.Errors) validation.Errors {
+	translated := validation.Errors{}
+	for field, err := range errs {
+		switch e := err.(type) {
+		case validation.Errors:
+			translated[field] = app.translateErrors(r, e)
+		case error:
+			translated[field] = errors.New(app.translate(r, e.Error()))
+		}
+	}
+
+	return translated
+}
+
 func (app *application) readJSON(r *http.Request, dst any) error {
 	err := json.NewDecoder(r.Body).Decode(dst)
 	if err != nil {
@@ -69,6 +102,16 @@ func (app *application) readJSON(r *http.Request, dst any) error {
 	return nil
 }
 
+// Describe the client making the request, with an optional
+// client supplied device name.
+func (app *application) requestDevice(r *http.Request, name string) data.Device {
+	return data.Device{
+		IP:        realip.FromRequest(r),
+		UserAgent: r.UserAgent(),
+		Name:      name,
+	}
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, statusCode int, data envelope, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -88,20 +131,26 @@ func (app *application) writeJSON(w http.ResponseWriter, statusCode int, data en
 	return nil
 }
 
-func (app *application) writeError(w http.ResponseWriter, statusCode int, message any) error {
+func (app *application) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message any) error {
 	if message == nil {
 		message = http.StatusText(statusCode)
 	}
+	if s, ok := message.(string); ok {
+		message = app.translate(r, s)
+	}
 
 	data := envelope{"error": message}
 
 	return app.writeJSON(w, statusCode, data, nil)
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, statusCode int, message any) {
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, statusCode int, message any) {
 	if message == nil {
 		message = http.StatusText(statusCode)
 	}
+	if s, ok := message.(string); ok {
+		message = app.translate(r, s)
+	}
 
 	data := envelope{"error": message}
 
@@ -112,14 +161,21 @@ func (app *application) errorResponse(w http.ResponseWriter, statusCode int, mes
 	}
 }
 
-func (app *application) serverErrorResponse(w http.ResponseWriter, logMsg string, err error) {
+func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, logMsg string, err error) {
 	app.logger.Error(logMsg, slog.Any("err", err), slog.String("type", fmt.Sprintf("%T", err)))
 
-	app.errorResponse(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	app.errorResponse(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	return app.writeError(w, r, http.StatusTooManyRequests, LoginThrottledMessage)
 }
 
-func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter) {
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
-	app.errorResponse(w, http.StatusUnauthorized, InvalidAuthenticationTokenMessage)
+	app.errorResponse(w, r, http.StatusUnauthorized, InvalidAuthenticationTokenMessage)
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/micahco/api/internal/data"
+)
+
+// How long an idle worker waits before looking for due jobs again
+const jobPollInterval = time.Second
+
+// Job runs by outcome
+var jobRuns = expvar.NewMap("job_runs")
+
+// Start workers that claim and run queued jobs until ctx is cancelled.
+// Workers finish the job they are running before they stop.
+func (app *application) startJobs(ctx context.Context) {
+	for range max(app.config.jobs.workers, 1) {
+		app.wg.Add(1)
+
+		go func() {
+			defer app.wg.Done()
+
+			app.work(ctx)
+		}()
+	}
+}
+
+func (app *application) work(ctx context.Context) {
+	for {
+		job, err := app.models.Job.Claim(ctx)
+		switch {
+		case err == nil:
+			app.runJob(job)
+			continue
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, data.ErrRecordNotFound):
+		default:
+			app.logger.Error("unable to claim job", slog.Any("err", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// Run job and record the outcome. Failed jobs are retried later, or
+// moved to the dead letter state when out of attempts.
+func (app *application) runJob(job *data.Job) {
+	jobErr := app.handleJob(job)
+	if jobErr == nil {
+		jobRuns.Add("completed", 1)
+
+		err := app.models.Job.Complete(context.Background(), job)
+		if err != nil {
+			app.logger.Error("unable to complete job", slog.Any("id", job.ID), slog.Any("err", err))
+		}
+
+		return
+	}
+
+	attrs := []any{
+		slog.Any("id", job.ID),
+		slog.String("kind", job.Kind),
+		slog.Int("attempts", job.Attempts),
+		slog.Any("err", jobErr),
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		jobRuns.Add("dead", 1)
+		app.logger.Error("job failed for the last time", attrs...)
+	} else {
+		jobRuns.Add("retried", 1)
+		app.logger.Warn("job failed, will retry", attrs...)
+	}
+
+	err := app.models.Job.Fail(context.Background(), job, jobErr)
+	if err != nil {
+		app.logger.Error("unable to record failed job", slog.Any("id", job.ID), slog.Any("err", err))
+	}
+}
+
+func (app *application) handleJob(job *data.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	switch job.Kind {
+	case data.JobKindMail:
+		var mail data.Mail
+
+		err := json.Unmarshal(job.Payload, &mail)
+		if err != nil {
+			return err
+		}
+
+		return app.sendMail(mail.Recipient, mail.Locale, mail.Template, mail.Data)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
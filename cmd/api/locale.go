@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/i18n"
+)
+
+// Locales a user can choose, for validation.In
+var supportedLocales = func() []any {
+	locales := make([]any, len(i18n.Locales))
+	for i, l := range i18n.Locales {
+		locales[i] = l
+	}
+
+	return locales
+}()
+
+// Locale for the response to r. An authenticated user's stored
+// preference wins over the Accept-Language header.
+func (app *application) requestLocale(r *http.Request) string {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if ok && user.Locale != "" {
+		return user.Locale
+	}
+
+	return i18n.Match(r.Header.Get("Accept-Language"))
+}
+
+// Locale for mail to user, who may be nil when mail is sent to an
+// address without an account.
+func (app *application) mailLocale(r *http.Request, user *data.User) string {
+	if user != nil && user.Locale != "" {
+		return user.Locale
+	}
+
+	return app.requestLocale(r)
+}
+
+// Translate an English message for the response to r.
+func (app *application) translate(r *http.Request, message string) string {
+	return i18n.Translate(app.requestLocale(r), message)
+}
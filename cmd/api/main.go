@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -14,12 +15,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lmittmann/tint"
 	"github.com/micahco/api/internal/data"
 	"github.com/micahco/api/internal/mailer"
+	"github.com/micahco/api/internal/paseto"
 	"github.com/micahco/api/ui"
 )
 
@@ -47,9 +50,40 @@ type config struct {
 		password string
 		sender   string
 	}
+	mail struct {
+		transport string
+		dir       string
+		product   string
+	}
+	dkim struct {
+		domain       string
+		selector     string
+		key          string
+		nextSelector string
+		nextKey      string
+	}
 	cors struct {
 		trustedOrigins []string
 	}
+	tokens struct {
+		mode string
+		keys []string
+	}
+	webauthn struct {
+		rpID          string
+		rpDisplayName string
+		rpOrigins     []string
+	}
+	verification struct {
+		ttls      []string
+		cooldowns []string
+	}
+	cleanup struct {
+		interval time.Duration
+	}
+	jobs struct {
+		workers int
+	}
 }
 
 func main() {
@@ -58,7 +92,7 @@ func main() {
 	flag.BoolVar(&cfg.dev, "dev", false, "Development mode")
 	flag.IntVar(&cfg.port, "port", getEnvInt("API_PORT"), "API server port")
 
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DATABASE_URL"), "PostgreSQL DSN")
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DATABASE_URL"), "PostgreSQL DSN, sqlite:path for an embedded SQLite database, or memory: to keep everything in memory")
 
 	flag.IntVar(&cfg.smtp.port, "smtp-port", getEnvInt("SMTP_PORT"), "SMTP port")
 	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host")
@@ -66,6 +100,15 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", os.Getenv("API_SMTP_SENDER"), "SMTP sender")
 
+	flag.StringVar(&cfg.mail.transport, "mail-transport", os.Getenv("API_MAIL_TRANSPORT"), "Mail transport (smtp|file|memory), memory in development mode and smtp otherwise when empty")
+	flag.StringVar(&cfg.mail.product, "mail-product", getEnvString("API_MAIL_PRODUCT", "API"), "Product name shown in mail")
+	flag.StringVar(&cfg.dkim.domain, "dkim-domain", os.Getenv("API_DKIM_DOMAIN"), "DKIM signing domain, mail is unsigned when empty")
+	flag.StringVar(&cfg.dkim.selector, "dkim-selector", os.Getenv("API_DKIM_SELECTOR"), "DKIM selector")
+	flag.StringVar(&cfg.dkim.key, "dkim-key", os.Getenv("API_DKIM_KEY"), "Path to DKIM private key PEM file")
+	flag.StringVar(&cfg.dkim.nextSelector, "dkim-next-selector", os.Getenv("API_DKIM_NEXT_SELECTOR"), "DKIM selector of a second key, during key rotation")
+	flag.StringVar(&cfg.dkim.nextKey, "dkim-next-key", os.Getenv("API_DKIM_NEXT_KEY"), "Path to the second DKIM private key PEM file, during key rotation")
+	flag.StringVar(&cfg.mail.dir, "mail-dir", getEnvString("API_MAIL_DIR", "tmp/mail"), "Directory for .eml files written by the file mail transport")
+
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", getEnvBool("API_LIMITER_ENABLED"), "Enable rate limiter")
 	flag.IntVar(&cfg.limiter.rps, "limiter-rps", getEnvInt("API_LIMITER_RPS"), "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", getEnvInt("API_LIMITER_BURST"), "Rate limiter maximum burst")
@@ -79,6 +122,39 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&cfg.tokens.mode, "token-mode", getEnvString("API_TOKEN_MODE", "opaque"), "Authentication token mode (opaque|signed)")
+
+	cfg.tokens.keys = strings.Fields(os.Getenv("API_TOKEN_KEYS"))
+	flag.Func("token-keys", "Signing keys as id=path to Ed25519 PEM file (space separated, first one signs)", func(val string) error {
+		cfg.tokens.keys = strings.Fields(val)
+		return nil
+	})
+
+	flag.StringVar(&cfg.webauthn.rpID, "webauthn-rp-id", os.Getenv("API_WEBAUTHN_RP_ID"), "WebAuthn relying party ID, passkeys are disabled when empty")
+	flag.StringVar(&cfg.webauthn.rpDisplayName, "webauthn-rp-name", getEnvString("API_WEBAUTHN_RP_NAME", "API"), "WebAuthn relying party display name")
+
+	cfg.webauthn.rpOrigins = strings.Fields(os.Getenv("API_WEBAUTHN_RP_ORIGINS"))
+	flag.Func("webauthn-rp-origins", "WebAuthn relying party origins (space separated)", func(val string) error {
+		cfg.webauthn.rpOrigins = strings.Fields(val)
+		return nil
+	})
+
+	cfg.verification.ttls = strings.Fields(os.Getenv("API_VERIFICATION_TTL"))
+	flag.Func("verification-ttl", "Verification token lifetimes as scope=duration (space separated)", func(val string) error {
+		cfg.verification.ttls = strings.Fields(val)
+		return nil
+	})
+
+	cfg.verification.cooldowns = strings.Fields(os.Getenv("API_VERIFICATION_COOLDOWN"))
+	flag.Func("verification-cooldown", "Verification email resend cooldowns as scope=duration (space separated)", func(val string) error {
+		cfg.verification.cooldowns = strings.Fields(val)
+		return nil
+	})
+
+	flag.DurationVar(&cfg.cleanup.interval, "cleanup-interval", getEnvDuration("API_CLEANUP_INTERVAL", time.Hour), "Interval between deleting expired rows, disabled when 0")
+
+	flag.IntVar(&cfg.jobs.workers, "job-workers", getEnvInt("API_JOB_WORKERS"), "Number of job queue workers (at least 1)")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -98,45 +174,76 @@ func main() {
 		log.Fatal("ded")
 	}
 
-	// PostgreSQL
-	pool, err := openPool(cfg.db.dsn)
+	// Token signing keys
+	keys, err := openKeyRing(cfg.tokens.mode, cfg.tokens.keys)
 	if err != nil {
 		fatal(err)
 	}
-	defer pool.Close()
+
+	// Verification token lifetimes and resend cooldowns
+	policies, err := parseVerificationPolicies(cfg.verification.ttls, cfg.verification.cooldowns)
+	if err != nil {
+		fatal(err)
+	}
+
+	// PostgreSQL, or SQLite for a sqlite: DSN
+	models, stats, closeDB, err := openDatabase(cfg.db.dsn, keys, policies)
+	if err != nil {
+		fatal(err)
+	}
+	defer closeDB()
 
 	// Mailer
 	sender := &mail.Address{
 		Name:    "Do Not Reply",
 		Address: cfg.smtp.sender,
 	}
-	logger.Info("dialing SMTP server...")
-	m, err := mailer.New(
-		cfg.smtp.host,
-		cfg.smtp.port,
-		cfg.smtp.username,
-		cfg.smtp.password,
-		sender,
-		ui.Files,
-		"mail/*.tmpl",
-	)
+	transport, err := openMailTransport(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	dkimKeys, err := openDKIMKeys(cfg)
 	if err != nil {
 		fatal(err)
 	}
+	m, err := mailer.New(transport, sender, cfg.mail.product, ui.Files, "mail", dkimKeys...)
+	if err != nil {
+		fatal(err)
+	}
+
+	// Keep sent mail readable at /dev/mail in development
+	var inbox *mailer.Inbox
+	if cfg.dev {
+		inbox = mailer.NewInbox(devInboxSize)
+		m.CaptureTo(inbox)
+	}
+
+	// WebAuthn
+	var wa *webauthn.WebAuthn
+	if cfg.webauthn.rpID != "" {
+		wa, err = webauthn.New(&webauthn.Config{
+			RPID:          cfg.webauthn.rpID,
+			RPDisplayName: cfg.webauthn.rpDisplayName,
+			RPOrigins:     cfg.webauthn.rpOrigins,
+		})
+		if err != nil {
+			fatal(err)
+		}
+	}
 
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() interface{} {
 		return runtime.NumGoroutine()
 	}))
-	expvar.Publish("database", expvar.Func(func() interface{} {
-		return dbStats(pool.Stat())
-	}))
+	expvar.Publish("database", expvar.Func(stats))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		mailer: m,
-		models: data.New(pool),
+		config:   cfg,
+		logger:   logger,
+		mailer:   m,
+		inbox:    inbox,
+		models:   models,
+		webauthn: wa,
 	}
 
 	err = app.serve(errLog)
@@ -145,6 +252,44 @@ func main() {
 	}
 }
 
+// Open the database named by dsn and create models backed by it. DSNs
+// with the sqlite: scheme open an embedded SQLite database, memory:
+// keeps everything in memory until exit, and anything else is a
+// PostgreSQL DSN. stats describes the connection pool.
+func openDatabase(dsn string, keys *paseto.KeyRing, policies data.VerificationPolicies) (models data.Models, stats func() any, closeDB func(), err error) {
+	if dsn == data.MemoryDSN {
+		stats = func() any {
+			return nil
+		}
+
+		return data.NewMemory(keys, policies), stats, func() {}, nil
+	}
+
+	if data.IsSQLiteDSN(dsn) {
+		db, err := data.OpenSQLite(dsn)
+		if err != nil {
+			return data.Models{}, nil, nil, err
+		}
+
+		stats = func() any {
+			return db.Stats()
+		}
+
+		return data.NewSQLite(db, keys, policies), stats, func() { db.Close() }, nil
+	}
+
+	pool, err := openPool(dsn)
+	if err != nil {
+		return data.Models{}, nil, nil, err
+	}
+
+	stats = func() any {
+		return dbStats(pool.Stat())
+	}
+
+	return data.New(pool, keys, policies), stats, pool.Close, nil
+}
+
 func openPool(dsn string) (*pgxpool.Pool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -171,6 +316,123 @@ func openPool(dsn string) (*pgxpool.Pool, error) {
 	return dbpool, err
 }
 
+// Messages kept by the memory mail transport
+const memoryTransportSize = 100
+
+// Create the configured mail transport. An unreachable SMTP server is
+// logged but not fatal, since queued mail is retried until it's back.
+// Development mode keeps recent mail in memory unless a transport is
+// chosen, so it is only read in the development inbox.
+func openMailTransport(cfg config) (mailer.Transport, error) {
+	transport := cfg.mail.transport
+	if transport == "" {
+		transport = "smtp"
+		if cfg.dev {
+			transport = "memory"
+		}
+	}
+
+	switch transport {
+	case "smtp":
+		t := mailer.NewSMTPTransport(
+			cfg.smtp.host,
+			cfg.smtp.port,
+			cfg.smtp.username,
+			cfg.smtp.password,
+		)
+
+		logger.Info("dialing SMTP server...")
+		err := t.Ping()
+		if err != nil {
+			logger.Warn("SMTP server is unavailable", slog.Any("err", err))
+		}
+
+		return t, nil
+	case "file":
+		return mailer.NewFileTransport(cfg.mail.dir)
+	case "memory":
+		return mailer.NewMemoryTransport(memoryTransportSize), nil
+	default:
+		return nil, fmt.Errorf("invalid mail transport %q", transport)
+	}
+}
+
+// Load the DKIM keys mail is signed with. Returns nil when no signing
+// domain is configured.
+func openDKIMKeys(cfg config) ([]*mailer.DKIMKey, error) {
+	if cfg.dkim.domain == "" {
+		return nil, nil
+	}
+
+	selectors := []struct{ selector, path string }{
+		{cfg.dkim.selector, cfg.dkim.key},
+		{cfg.dkim.nextSelector, cfg.dkim.nextKey},
+	}
+
+	var keys []*mailer.DKIMKey
+	for i, s := range selectors {
+		if s.selector == "" && s.path == "" && i > 0 {
+			continue
+		}
+		if s.selector == "" || s.path == "" {
+			return nil, errors.New("dkim signing requires both a selector and a key file")
+		}
+
+		b, err := os.ReadFile(s.path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := mailer.ParseDKIMKey(cfg.dkim.domain, s.selector, b)
+		if err != nil {
+			return nil, fmt.Errorf("dkim selector %s: %w", s.selector, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Load the signing key ring for signed token mode. Returns nil
+// in opaque mode.
+func openKeyRing(mode string, keys []string) (*paseto.KeyRing, error) {
+	switch mode {
+	case "opaque":
+		return nil, nil
+	case "signed":
+	default:
+		return nil, fmt.Errorf("invalid token mode %q", mode)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("signed token mode requires at least one key")
+	}
+
+	kr := paseto.NewKeyRing()
+
+	for _, k := range keys {
+		id, path, ok := strings.Cut(k, "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid token key %q", k)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := paseto.ParsePrivateKeyPEM(b)
+		if err != nil {
+			return nil, fmt.Errorf("token key %s: %w", id, err)
+		}
+
+		kr.Add(id, key)
+	}
+
+	return kr, nil
+}
+
 func newSlogHandler(dev bool) slog.Handler {
 	if dev {
 		// Development text hanlder
@@ -240,6 +502,29 @@ func getEnvInt(key string) int {
 	return v
 }
 
+func getEnvString(key, fallback string) string {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	return val
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("getEnvDuration(%v): %v", key, err)
+	}
+
+	return d
+}
+
 func getEnvBool(key string) bool {
 	val, ok := os.LookupEnv(key)
 	if !ok {
@@ -248,3 +533,54 @@ func getEnvBool(key string) bool {
 
 	return strings.ToLower(val) == "true"
 }
+
+// Build verification policies from scope=duration overrides of
+// the default token lifetimes and resend cooldowns.
+func parseVerificationPolicies(ttls, cooldowns []string) (data.VerificationPolicies, error) {
+	policies := make(data.VerificationPolicies)
+	for scope, policy := range data.DefaultVerificationPolicies {
+		policies[scope] = policy
+	}
+
+	parse := func(vals []string, set func(p *data.VerificationPolicy, d time.Duration)) error {
+		for _, v := range vals {
+			scope, val, ok := strings.Cut(v, "=")
+			if !ok {
+				return fmt.Errorf("invalid verification setting %q", v)
+			}
+
+			policy, ok := policies[scope]
+			if !ok {
+				return fmt.Errorf("unknown verification scope %q", scope)
+			}
+
+			d, err := time.ParseDuration(val)
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid duration for verification scope %s: %q", scope, val)
+			}
+
+			set(&policy, d)
+			policies[scope] = policy
+		}
+
+		return nil
+	}
+
+	err := parse(ttls, func(p *data.VerificationPolicy, d time.Duration) { p.TTL = d })
+	if err != nil {
+		return nil, err
+	}
+
+	err = parse(cooldowns, func(p *data.VerificationPolicy, d time.Duration) { p.Cooldown = d })
+	if err != nil {
+		return nil, err
+	}
+
+	for scope, policy := range policies {
+		if policy.TTL <= policy.Cooldown {
+			return nil, fmt.Errorf("verification scope %s: ttl must be longer than cooldown", scope)
+		}
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micahco/api/internal/data"
+)
+
+func TestOpenDatabaseMemory(t *testing.T) {
+	models, stats, closeDB, err := openDatabase(data.MemoryDSN, nil, data.DefaultVerificationPolicies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB()
+
+	if stats() != nil {
+		t.Errorf("got stats %v; want none", stats())
+	}
+
+	_, err = models.User.New(context.Background(), "alice@example.com", "pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := models.User.ExistsWithEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("user created in the memory database does not exist")
+	}
+}
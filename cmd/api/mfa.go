@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/totp"
+)
+
+// Issuer shown in authenticator apps
+const mfaIssuer = "API"
+
+// Code submitted to prove possession of a second factor. Either a
+// TOTP code or a recovery code must be provided.
+type mfaCodeInput struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (input mfaCodeInput) Validate() error {
+	if input.Code == "" && input.RecoveryCode == "" {
+		return validation.Errors{
+			"code": errors.New("cannot be blank"),
+		}
+	}
+
+	return validation.ValidateStruct(&input,
+		validation.Field(&input.Code, validation.Length(totp.Digits, totp.Digits)),
+	)
+}
+
+// Verify the user's TOTP code or consume one of their recovery codes.
+// Returns data.ErrInvalidCode if neither matches.
+func (app *application) verifyMFACode(ctx context.Context, user *data.User, input mfaCodeInput) error {
+	if input.Code == "" {
+		return app.models.RecoveryCode.Use(ctx, user.ID, input.RecoveryCode)
+	}
+
+	t, err := app.models.TOTP.Get(ctx, user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return data.ErrInvalidCode
+		default:
+			return err
+		}
+	}
+
+	if !t.Confirmed {
+		return data.ErrInvalidCode
+	}
+
+	return app.models.TOTP.Verify(ctx, t, input.Code)
+}
+
+// Verify the user's code as in verifyMFACode, subject to the same
+// delays and lockout as logging in, since codes are short enough to
+// guess. A wrong code is also counted against challenge if not nil.
+// Without a challenge the code is a step-up check, counted apart from
+// logins. Returns false, having written a response, if the code is
+// wrong or checks for the user's email are throttled.
+func (app *application) verifyMFAAttempt(w http.ResponseWriter, r *http.Request, user *data.User, input mfaCodeInput, challenge *data.MFAChallenge) (bool, error) {
+	scope := data.LoginScopeStepUp
+	if challenge != nil {
+		scope = data.LoginScopeLogin
+	}
+
+	wait, err := app.reserveLoginAttempt(r, scope, user.Email)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		return false, app.loginThrottledResponse(w, r, wait)
+	}
+
+	err = app.verifyMFACode(r.Context(), user, input)
+	if err != nil {
+		if err != data.ErrInvalidCode {
+			return false, err
+		}
+
+		if challenge != nil {
+			err = app.models.MFAChallenge.Fail(r.Context(), challenge)
+			if err != nil && err != data.ErrRecordNotFound {
+				return false, err
+			}
+		}
+
+		return false, app.writeError(w, r, http.StatusUnauthorized, InvalidMFACodeMessage)
+	}
+
+	return true, app.models.LoginAttempt.Reset(r.Context(), scope, user.Email)
+}
+
+// Begin TOTP enrollment for the authenticated user. Returns a new
+// secret that must be confirmed with a code before it is enabled.
+func (app *application) usersMeMFATOTPPost(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	t, err := app.models.TOTP.New(r.Context(), user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrMFAEnabled:
+			return app.writeError(w, r, http.StatusConflict, MFAEnabledMessage)
+		default:
+			return err
+		}
+	}
+
+	env := envelope{
+		"totp": map[string]string{
+			"secret": totp.EncodeSecret(t.Secret),
+			"uri":    totp.URI(mfaIssuer, user.Email, t.Secret),
+		},
+	}
+
+	return app.writeJSON(w, http.StatusCreated, env, nil)
+}
+
+// Confirm TOTP enrollment with a code from the authenticator. Enables
+// MFA and returns a set of recovery codes.
+func (app *application) usersMeMFATOTPConfirmPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Code, validation.Required, validation.Length(totp.Digits, totp.Digits)),
+	)
+	if err != nil {
+		return err
+	}
+
+	user := app.contextGetUser(r)
+
+	t, err := app.models.TOTP.Get(r.Context(), user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusNotFound, nil)
+		default:
+			return err
+		}
+	}
+
+	if t.Confirmed {
+		return app.writeError(w, r, http.StatusConflict, MFAEnabledMessage)
+	}
+
+	err = app.models.TOTP.Verify(r.Context(), t, input.Code)
+	if err != nil {
+		switch err {
+		case data.ErrInvalidCode:
+			return app.writeError(w, r, http.StatusUnauthorized, InvalidMFACodeMessage)
+		default:
+			return err
+		}
+	}
+
+	err = app.models.TOTP.Confirm(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	codes, err := app.models.RecoveryCode.New(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+}
+
+// Disable TOTP for the authenticated user, given a valid code.
+func (app *application) usersMeMFATOTPDelete(w http.ResponseWriter, r *http.Request) error {
+	var input mfaCodeInput
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = input.Validate()
+	if err != nil {
+		return err
+	}
+
+	user := app.contextGetUser(r)
+
+	ok, err := app.verifyMFAAttempt(w, r, user, input, nil)
+	if !ok || err != nil {
+		return err
+	}
+
+	err = app.models.TOTP.Delete(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.RecoveryCode.PurgeWithUserID(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	msg := envelope{"message": app.translate(r, "two-factor authentication was disabled")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+// Replace the authenticated user's recovery codes, given a valid code.
+func (app *application) usersMeMFARecoveryCodesPost(w http.ResponseWriter, r *http.Request) error {
+	var input mfaCodeInput
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = input.Validate()
+	if err != nil {
+		return err
+	}
+
+	user := app.contextGetUser(r)
+
+	ok, err := app.verifyMFAAttempt(w, r, user, input, nil)
+	if !ok || err != nil {
+		return err
+	}
+
+	codes, err := app.models.RecoveryCode.New(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+}
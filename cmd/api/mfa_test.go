@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/totp"
+)
+
+// Enable TOTP for the user, returning the secret and recovery codes.
+func enableTOTP(t *testing.T, app *application, user *data.User) ([]byte, []string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	otp, err := app.models.TOTP.New(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.TOTP.Confirm(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := app.models.RecoveryCode.New(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return otp.Secret, codes
+}
+
+// Log in with the password, returning the MFA challenge token.
+func mfaChallenge(t *testing.T, ts *testServer, email string) string {
+	t.Helper()
+
+	code, env := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{
+		"email":    email,
+		"password": "pa55word1234",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("login: got status %d; want %d", code, http.StatusOK)
+	}
+
+	return env["mfa_challenge_token"].(map[string]any)["token"].(string)
+}
+
+// A code that is not the given one.
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+
+	return "000000"
+}
+
+func TestMFALogin(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, _ := newTestUser(t, app, "alice@example.com")
+	secret, recovery := enableTOTP(t, app, user)
+
+	challenge := mfaChallenge(t, ts, "alice@example.com")
+	code := totp.Code(secret, totp.Counter(time.Now()))
+
+	status, env := ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+		"token": challenge,
+		"code":  code,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", status, http.StatusCreated)
+	}
+
+	token := env["authentication_token"].(map[string]any)["token"].(string)
+	status, _ = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if status != http.StatusOK {
+		t.Errorf("authenticated: got status %d; want %d", status, http.StatusOK)
+	}
+
+	// The challenge is single use
+	status, _ = ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+		"token": challenge,
+		"code":  code,
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("reused challenge: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	// So is the code, even with a new challenge
+	status, _ = ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+		"token": mfaChallenge(t, ts, "alice@example.com"),
+		"code":  code,
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("replayed code: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	// Forget the failed login, which would throttle the next ones
+	err := app.models.LoginAttempt.Reset(context.Background(), data.LoginScopeLogin, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A recovery code works once
+	for _, want := range []int{http.StatusCreated, http.StatusUnauthorized} {
+		status, _ = ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+			"token":         mfaChallenge(t, ts, "alice@example.com"),
+			"recovery_code": recovery[0],
+		})
+		if status != want {
+			t.Errorf("recovery code: got status %d; want %d", status, want)
+		}
+	}
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, _ := newTestUser(t, app, "bob@example.com")
+	secret, _ := enableTOTP(t, app, user)
+
+	challenge := mfaChallenge(t, ts, "bob@example.com")
+	code := totp.Code(secret, totp.Counter(time.Now()))
+
+	for i := 1; i <= data.MFAChallengeMaxAttempts; i++ {
+		// Keep login throttling out of the way of the challenge's limit
+		err := app.models.LoginAttempt.Reset(context.Background(), data.LoginScopeLogin, "bob@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, _ := ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+			"token": challenge,
+			"code":  wrongCode(code),
+		})
+		if status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got status %d; want %d", i, status, http.StatusUnauthorized)
+		}
+	}
+
+	// The challenge was discarded, so the right code no longer helps
+	status, env := ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+		"token": challenge,
+		"code":  code,
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("discarded challenge: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+	if msg := env["error"]; msg != InvalidMFAChallengeMessage {
+		t.Errorf("got error %v; want %q", msg, InvalidMFAChallengeMessage)
+	}
+}
+
+func TestMFALoginThrottled(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, _ := newTestUser(t, app, "carol@example.com")
+	secret, _ := enableTOTP(t, app, user)
+
+	challenge := mfaChallenge(t, ts, "carol@example.com")
+	code := totp.Code(secret, totp.Counter(time.Now()))
+
+	// Wrong codes count as failed logins for the email
+	var status int
+	for range data.LoginFreeAttempts {
+		status, _ = ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+			"token": challenge,
+			"code":  wrongCode(code),
+		})
+	}
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("got status %d; want %d", status, http.StatusTooManyRequests)
+	}
+
+	// A wrong code reaching the threshold locks the email and mails an
+	// unlock token
+	insertNearLockout(t, app, data.LoginScopeLogin, "carol@example.com")
+
+	status, _ = ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+		"token": challenge,
+		"code":  wrongCode(code),
+	})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("locking attempt: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	mail := claimMail(t, app)
+	if mail.Template != "account-unlock.tmpl" {
+		t.Errorf("got %s; want account-unlock.tmpl", mail.Template)
+	}
+
+	status, _ = ts.do(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{
+		"token": challenge,
+		"code":  code,
+	})
+	if status != http.StatusTooManyRequests {
+		t.Errorf("locked: got status %d; want %d", status, http.StatusTooManyRequests)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -14,11 +16,13 @@ import (
 	"golang.org/x/time/rate"
 )
 
-func (app *application) metrics(next http.Handler) http.Handler {
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
+var (
+	totalRequestsReceived           = expvar.NewInt("total_requests_received")
+	totalResponsesSent              = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+)
 
+func (app *application) metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
@@ -37,7 +41,7 @@ func (app *application) recovery(next http.Handler) http.Handler {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
 
-				app.serverErrorResponse(w, "middleware: recoverer", fmt.Errorf("%s", err))
+				app.serverErrorResponse(w, r, "middleware: recoverer", fmt.Errorf("%s", err))
 			}
 		}()
 
@@ -123,7 +127,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
-				app.errorResponse(w, http.StatusTooManyRequests, RateLimitExceededMessage)
+				app.errorResponse(w, r, http.StatusTooManyRequests, RateLimitExceededMessage)
 
 				return
 			}
@@ -141,6 +145,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// caches that the response may vary based on the value of the Authorization
 		// header in the request.
 		w.Header().Add("Vary", "Authorization")
+		// Responses are translated for the user's locale or the
+		// request's Accept-Language header.
+		w.Header().Add("Vary", "Accept-Language")
 
 		authorizationHeader := r.Header.Get("Authorization")
 		if authorizationHeader == "" {
@@ -151,36 +158,95 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w)
+			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
 		token := headerParts[1]
 
-		user, err := app.models.User.GetForAuthenticationToken(token)
+		var user *data.User
+		var at *data.AuthenticationToken
+		var scopes []string
+		var err error
+		switch {
+		case data.IsPersonalAccessToken(token):
+			user, scopes, err = app.models.PersonalAccessToken.GetForToken(r.Context(), token)
+			if err == nil {
+				// Personal access tokens never count as a recent login
+				at = &data.AuthenticationToken{
+					UserID: user.ID,
+					Token:  &data.Token{Plaintext: token},
+				}
+			}
+		case app.models.AuthenticationToken.Signed():
+			user, at, err = app.authenticateSigned(r.Context(), token)
+		default:
+			user, at, err = app.authenticateOpaque(r.Context(), token)
+		}
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound),
-				errors.Is(err, data.ErrExpiredToken):
-				app.invalidAuthenticationTokenResponse(w)
+				errors.Is(err, data.ErrExpiredToken),
+				errors.Is(err, data.ErrInvalidToken):
+				app.invalidAuthenticationTokenResponse(w, r)
 			default:
-				app.serverErrorResponse(w, "middleware: authenticate: GetForAuthenticationToken", err)
+				app.serverErrorResponse(w, r, "middleware: authenticate", err)
 			}
 			return
 		}
 
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, at)
+		if scopes != nil {
+			r = app.contextSetScopes(r, scopes)
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Look up the user for an opaque token and record its use.
+func (app *application) authenticateOpaque(ctx context.Context, token string) (*data.User, *data.AuthenticationToken, error) {
+	user, at, err := app.models.User.GetForAuthenticationToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = app.models.AuthenticationToken.UpdateLastUsed(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, at, nil
+}
+
+// Verify a signed token locally. The database is only consulted when
+// the cached user's version does not match the token, and a token for
+// an older version is only accepted while its session is recorded.
+func (app *application) authenticateSigned(ctx context.Context, token string) (*data.User, *data.AuthenticationToken, error) {
+	claims, err := app.models.AuthenticationToken.ParseSigned(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := app.models.User.GetForAuthenticationClaims(ctx, claims)
+	if err != nil {
+		if errors.Is(err, data.ErrStaleToken) {
+			return app.models.User.GetForAuthenticationToken(ctx, token)
+		}
+
+		return nil, nil, err
+	}
+
+	return user, claims.AuthenticationToken(token), nil
+}
+
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
 		if user.IsAnonymous() {
-			app.errorResponse(w, http.StatusUnauthorized, AuthenticationRequiredMessage)
+			app.errorResponse(w, r, http.StatusUnauthorized, AuthenticationRequiredMessage)
 
 			return
 		}
@@ -188,3 +254,39 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Require a personal access token to have been granted scope.
+// Authentication tokens are not restricted by scope.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes := app.contextGetScopes(r)
+
+			if scopes != nil && !slices.Contains(scopes, scope) {
+				app.errorResponse(w, r, http.StatusForbidden, InsufficientScopeMessage)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Require the user to have logged in or reauthenticated within maxAge.
+// Personal access tokens never satisfy this requirement.
+func (app *application) requireRecentAuth(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			at := app.contextGetToken(r)
+
+			if !at.Recent(maxAge) {
+				app.errorResponse(w, r, http.StatusForbidden, RecentAuthRequiredMessage)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micahco/api/internal/data"
+)
+
+func TestSignedTokenUserChanges(t *testing.T) {
+	app, _ := newTestApplication(t)
+	useSignedTokens(t, app)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "alice@example.com")
+	_, other := newTestUser(t, app, "bob@example.com")
+
+	code, _ := ts.do(t, http.MethodPut, "/v1/users/me", token, map[string]any{"locale": "fr"})
+	if code != http.StatusCreated {
+		t.Fatalf("locale: got status %d; want %d", code, http.StatusCreated)
+	}
+
+	code, env := ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("after locale change: got status %d; want %d", code, http.StatusOK)
+	}
+	if user, _ := env["user"].(map[string]any); user["locale"] != "fr" {
+		t.Errorf("got user %v; want locale fr", env["user"])
+	}
+
+	// A new password bumps the version, and the token is accepted
+	// while its session is recorded
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/me", token, map[string]any{"password": "n3w pa55word"})
+	if code != http.StatusCreated {
+		t.Fatalf("password: got status %d; want %d", code, http.StatusCreated)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("after password change: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodDelete, "/v1/tokens/authentication/all", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("logout all: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("after logout all: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", other, nil)
+	if code != http.StatusOK {
+		t.Errorf("other user: got status %d; want %d", code, http.StatusOK)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := app.requireScope(data.TokenScopeUserRead)(next)
+
+	tests := []struct {
+		name   string
+		scopes []string
+		want   int
+	}{
+		{"unrestricted", nil, http.StatusNoContent},
+		{"in scope", []string{data.TokenScopeTokensRead, data.TokenScopeUserRead}, http.StatusNoContent},
+		{"out of scope", []string{data.TokenScopeUserWrite}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.scopes != nil {
+				r = app.contextSetScopes(r, tt.scopes)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d; want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireScopeRoutes(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, token := newTestUser(t, app, "carol@example.com")
+	pat := newTestPAT(t, app, user, data.TokenScopeUserRead)
+
+	code, env := ts.do(t, http.MethodGet, "/v1/users/me/sessions", pat, nil)
+	if code != http.StatusForbidden {
+		t.Errorf("out of scope: got status %d; want %d", code, http.StatusForbidden)
+	}
+	if msg := env["error"]; msg != InsufficientScopeMessage {
+		t.Errorf("got error %v; want %q", msg, InsufficientScopeMessage)
+	}
+
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/me", pat, map[string]any{"locale": "fr"})
+	if code != http.StatusForbidden {
+		t.Errorf("read only: got status %d; want %d", code, http.StatusForbidden)
+	}
+
+	// Authentication tokens carry no scopes and are not restricted
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me/sessions", token, nil)
+	if code != http.StatusOK {
+		t.Errorf("authentication token: got status %d; want %d", code, http.StatusOK)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/micahco/api/internal/data"
 )
 
 // App router
@@ -24,12 +25,32 @@ func (app *application) routes() http.Handler {
 	// Metrics
 	r.Mount("/debug", middleware.Profiler())
 
+	// Development inbox, only captured in dev mode
+	if app.config.dev && app.inbox != nil {
+		r.Get("/dev/mail", app.handle(app.devMailGet))
+	}
+
 	// API
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/healthcheck", app.handle(app.healthcheck))
 
 		r.Route("/tokens", func(r chi.Router) {
-			r.Post("/authentication", app.handle(app.tokensAuthenticationPost))
+			r.Route("/authentication", func(r chi.Router) {
+				r.Post("/", app.handle(app.tokensAuthenticationPost))
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.requireAuthentication)
+
+					r.Delete("/", app.handle(app.tokensAuthenticationDelete))
+					r.With(app.requireScope(data.TokenScopeSessionsWrite)).
+						Delete("/all", app.handle(app.tokensAuthenticationAllDelete))
+				})
+			})
+
+			r.Post("/refresh", app.handle(app.tokensRefreshPost))
+			r.Post("/mfa", app.handle(app.tokensMFAPost))
+			r.With(app.requireAuthentication).
+				Post("/reauthentication", app.handle(app.tokensReauthenticationPost))
 
 			r.Route("/verification", func(r chi.Router) {
 				r.Post("/registration", app.handle(app.tokensVerificaitonRegistrationPost))
@@ -37,21 +58,101 @@ func (app *application) routes() http.Handler {
 
 				r.Route("/email-change", func(r chi.Router) {
 					r.Use(app.requireAuthentication)
+					r.Use(app.requireScope(data.TokenScopeUserWrite))
 
 					r.Post("/", app.handle(app.tokensVerificaitonEmailChangePost))
 				})
+
+				r.Route("/account-deletion", func(r chi.Router) {
+					r.Use(app.requireAuthentication)
+					r.Use(app.requireScope(data.TokenScopeUserWrite))
+
+					r.Post("/", app.handle(app.tokensVerificaitonAccountDeletionPost))
+				})
 			})
 		})
 
+		if app.webauthn != nil {
+			r.Route("/webauthn", func(r chi.Router) {
+				r.Post("/login/begin", app.handle(app.webauthnLoginBeginPost))
+				r.Post("/login/finish", app.handle(app.webauthnLoginFinishPost))
+
+				r.Route("/registration", func(r chi.Router) {
+					r.Use(app.requireAuthentication)
+					r.Use(app.requireScope(data.TokenScopeUserWrite))
+					r.Use(app.requireRecentAuth(recentAuthMaxAge))
+
+					r.Post("/begin", app.handle(app.webauthnRegistrationBeginPost))
+					r.Post("/finish", app.handle(app.webauthnRegistrationFinishPost))
+				})
+			})
+		}
+
 		r.Route("/users", func(r chi.Router) {
 			r.Post("/", app.handle(app.usersPost))
 			r.Put("/password", app.handle(app.usersPasswordPut))
+			r.Put("/unlock", app.handle(app.usersUnlockPut))
+			r.Put("/email-revert", app.handle(app.usersEmailRevertPut))
 
 			r.Route("/me", func(r chi.Router) {
 				r.Use(app.requireAuthentication)
 
-				r.Get("/", app.handle(app.usersMeGet))
-				r.Put("/", app.handle(app.usersMePut))
+				r.With(app.requireScope(data.TokenScopeUserRead)).
+					Get("/", app.handle(app.usersMeGet))
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.requireScope(data.TokenScopeUserWrite))
+
+					r.Put("/", app.handle(app.usersMePut))
+					r.Delete("/", app.handle(app.usersMeDelete))
+				})
+
+				r.Route("/sessions", func(r chi.Router) {
+					r.With(app.requireScope(data.TokenScopeSessionsRead)).
+						Get("/", app.handle(app.usersMeSessionsGet))
+					r.With(app.requireScope(data.TokenScopeSessionsWrite)).
+						Delete("/{id}", app.handle(app.usersMeSessionsDelete))
+				})
+
+				r.Route("/mfa", func(r chi.Router) {
+					r.Use(app.requireScope(data.TokenScopeUserWrite))
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.requireRecentAuth(recentAuthMaxAge))
+
+						r.Post("/totp", app.handle(app.usersMeMFATOTPPost))
+						r.Post("/totp/confirm", app.handle(app.usersMeMFATOTPConfirmPost))
+						r.Delete("/totp", app.handle(app.usersMeMFATOTPDelete))
+					})
+					r.Post("/recovery-codes", app.handle(app.usersMeMFARecoveryCodesPost))
+				})
+
+				if app.webauthn != nil {
+					r.Route("/webauthn", func(r chi.Router) {
+						r.With(app.requireScope(data.TokenScopeUserRead)).
+							Get("/", app.handle(app.usersMeWebAuthnGet))
+						r.With(
+							app.requireScope(data.TokenScopeUserWrite),
+							app.requireRecentAuth(recentAuthMaxAge),
+						).Delete("/{id}", app.handle(app.usersMeWebAuthnDelete))
+					})
+				}
+
+				r.Route("/tokens", func(r chi.Router) {
+					r.With(app.requireScope(data.TokenScopeTokensRead)).
+						Get("/", app.handle(app.usersMeTokensGet))
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.requireScope(data.TokenScopeTokensWrite))
+
+						r.Group(func(r chi.Router) {
+							r.Use(app.requireRecentAuth(recentAuthMaxAge))
+
+							r.Post("/", app.handle(app.usersMeTokensPost))
+							r.Delete("/{id}", app.handle(app.usersMeTokensDelete))
+						})
+					})
+				})
 			})
 		})
 	})
@@ -60,11 +161,11 @@ func (app *application) routes() http.Handler {
 }
 
 func (app *application) notFound(w http.ResponseWriter, r *http.Request) error {
-	return app.writeError(w, http.StatusNotFound, nil)
+	return app.writeError(w, r, http.StatusNotFound, nil)
 }
 
 func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request) error {
-	return app.writeError(w, http.StatusMethodNotAllowed, nil)
+	return app.writeError(w, r, http.StatusMethodNotAllowed, nil)
 }
 
 func (app *application) healthcheck(w http.ResponseWriter, r *http.Request) error {
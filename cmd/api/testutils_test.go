@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/micahco/api/internal/data"
+	"github.com/micahco/api/internal/mailer"
+	"github.com/micahco/api/internal/paseto"
+	"github.com/micahco/api/ui"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// Application backed by the memory models, with passkeys enabled and
+// mail recorded by the returned transport.
+func newTestApplication(t *testing.T) (*application, *mailer.MemoryTransport) {
+	t.Helper()
+
+	transport := mailer.NewMemoryTransport(10)
+	sender := &mail.Address{Address: "noreply@" + testRPID}
+
+	m, err := mailer.New(transport, sender, "API", ui.Files, "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "API",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		mailer:   m,
+		models:   data.NewMemory(nil, data.DefaultVerificationPolicies),
+		webauthn: wa,
+	}
+
+	return app, transport
+}
+
+// Switch app to empty models issuing signed authentication tokens.
+func useSignedTokens(t *testing.T, app *application) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := paseto.NewKeyRing()
+	keys.Add("test", key)
+
+	app.models = data.NewMemory(keys, data.DefaultVerificationPolicies)
+}
+
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts}
+}
+
+// Send body as JSON with the bearer token, when not empty, and decode
+// the JSON response.
+func (ts *testServer) do(t *testing.T, method, urlPath, token string, body any) (int, map[string]any) {
+	t.Helper()
+
+	var b io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var env map[string]any
+	err = json.NewDecoder(res.Body).Decode(&env)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, env
+}
+
+// Create a user and log them in, returning their authentication token.
+func newTestUser(t *testing.T, app *application, email string) (*data.User, string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	user, err := app.models.User.New(ctx, email, "pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := app.newLoginTokens(ctx, user, data.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, env["authentication_token"].(*data.Token).Plaintext
+}
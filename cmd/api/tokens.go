@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/gofrs/uuid/v5"
 	"github.com/micahco/api/internal/data"
 )
 
@@ -13,6 +16,10 @@ const (
 	passwordResetMsg = "If that email address is in our database, a token to reset your password will be sent to that address."
 )
 
+// How long after logging in a session may make sensitive changes
+// without reauthenticating.
+const recentAuthMaxAge = 5 * time.Minute
+
 // Create a verification token with registration scope and
 // mail it to the provided email address.
 func (app *application) tokensVerificaitonRegistrationPost(w http.ResponseWriter, r *http.Request) error {
@@ -34,10 +41,10 @@ func (app *application) tokensVerificaitonRegistrationPost(w http.ResponseWriter
 
 	// This will be the consistent message. Even if a user
 	// already exists with this email, send this message.
-	msg := envelope{"message": verificationMsg}
+	msg := envelope{"message": app.translate(r, verificationMsg)}
 
 	// Check if user with email already exists
-	exists, err := app.models.User.ExistsWithEmail(input.Email)
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), input.Email)
 	if err != nil {
 		return err
 	}
@@ -47,30 +54,22 @@ func (app *application) tokensVerificaitonRegistrationPost(w http.ResponseWriter
 		return app.writeJSON(w, http.StatusOK, msg, nil)
 	}
 
-	// Check if a verification token has already been created recently
-	exists, err = app.models.VerificationToken.Exists(data.ScopeRegistration, input.Email, nil)
-	if err != nil {
-		return err
-	}
-	if exists {
-		// Recent verification sent, don't mail another.
-		// Send the same message.
-		return app.writeJSON(w, http.StatusOK, msg, nil)
+	mail := &data.Mail{
+		Recipient: input.Email,
+		Locale:    app.mailLocale(r, nil),
+		Template:  "registration.tmpl",
 	}
-
-	t, err := app.models.VerificationToken.New(data.ScopeRegistration, input.Email, nil)
+	_, err = app.models.VerificationToken.Resend(r.Context(), data.ScopeRegistration, input.Email, nil, mail)
 	if err != nil {
-		return err
-	}
-
-	// Mail the plaintext token to the user's email address.
-	app.background(func() error {
-		data := map[string]any{
-			"token": t.Plaintext,
+		switch err {
+		case data.ErrCooldown:
+			// Recent verification sent, don't mail another.
+			// Send the same message.
+			return app.writeJSON(w, http.StatusOK, msg, nil)
+		default:
+			return err
 		}
-
-		return app.sendMail(input.Email, "registration.tmpl", data)
-	})
+	}
 
 	return app.writeJSON(w, http.StatusOK, msg, nil)
 }
@@ -94,10 +93,10 @@ func (app *application) tokensVerificaitonEmailChangePost(w http.ResponseWriter,
 
 	// This will be the consistent message. Even if a user
 	// already exists with this email, send this message.
-	msg := envelope{"message": verificationMsg}
+	msg := envelope{"message": app.translate(r, verificationMsg)}
 
 	// Check if user with email already exists
-	exists, err := app.models.User.ExistsWithEmail(input.Email)
+	exists, err := app.models.User.ExistsWithEmail(r.Context(), input.Email)
 	if err != nil {
 		return err
 	}
@@ -110,38 +109,111 @@ func (app *application) tokensVerificaitonEmailChangePost(w http.ResponseWriter,
 	// Get authenticated user's ID from context
 	user := app.contextGetUser(r)
 
-	// Check if a verification token has already been created recently
-	exists, err = app.models.VerificationToken.Exists(data.ScopeEmailChange, input.Email, &user.ID)
+	// Create verification token for user with new email address
+	mail := &data.Mail{
+		Recipient: input.Email,
+		Locale:    app.mailLocale(r, user),
+		Template:  "email-change.tmpl",
+	}
+	_, err = app.models.VerificationToken.Resend(r.Context(), data.ScopeEmailChange, input.Email, &user.ID, mail)
 	if err != nil {
-		return err
+		switch err {
+		case data.ErrCooldown:
+			// Recent verification sent, don't mail another, just
+			// send the same message
+			return app.writeJSON(w, http.StatusOK, msg, nil)
+		default:
+			return err
+		}
 	}
-	if exists {
-		// Recent verification sent, don't mail another, just
-		// send the same message
-		return app.writeJSON(w, http.StatusOK, msg, nil)
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+func (app *application) tokensVerificaitonPasswordResetPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Email string `json:"email"`
 	}
 
-	// Create verification token for user with new email address
-	t, err := app.models.VerificationToken.New(data.ScopeEmailChange, input.Email, &user.ID)
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Email, validation.Required, is.Email),
+	)
 	if err != nil {
 		return err
 	}
 
-	// Mail the plaintext token to the new email address
-	app.background(func() error {
-		data := map[string]any{
-			"token": t.Plaintext,
+	// This will be the consistent message. Even if a user
+	// already exists with this email, send this message.
+	msg := envelope{"message": app.translate(r, verificationMsg)}
+
+	// Check if user with email exists
+	user, err := app.models.User.GetForEmail(r.Context(), input.Email)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			// User with email does not exist. Still send the same
+			// message.
+			return app.writeJSON(w, http.StatusOK, msg, nil)
+		default:
+			return err
 		}
+	}
 
-		return app.sendMail(input.Email, "email-change.tmpl", data)
-	})
+	mail := &data.Mail{
+		Recipient: input.Email,
+		Locale:    app.mailLocale(r, user),
+		Template:  "password-reset.tmpl",
+	}
+	_, err = app.models.VerificationToken.Resend(r.Context(), data.ScopePasswordReset, input.Email, &user.ID, mail)
+	if err != nil {
+		switch err {
+		case data.ErrCooldown:
+			// Recent verification sent, don't mail another
+			return app.writeJSON(w, http.StatusOK, msg, nil)
+		default:
+			return err
+		}
+	}
 
 	return app.writeJSON(w, http.StatusOK, msg, nil)
 }
 
-func (app *application) tokensVerificaitonPasswordResetPost(w http.ResponseWriter, r *http.Request) error {
+// Create a verification token with account deletion scope and
+// mail it to the authenticated user's email address.
+func (app *application) tokensVerificaitonAccountDeletionPost(w http.ResponseWriter, r *http.Request) error {
+	msg := envelope{"message": app.translate(r, verificationMsg)}
+
+	user := app.contextGetUser(r)
+
+	mail := &data.Mail{
+		Recipient: user.Email,
+		Locale:    app.mailLocale(r, user),
+		Template:  "account-deletion.tmpl",
+	}
+	_, err := app.models.VerificationToken.Resend(r.Context(), data.ScopeAccountDeletion, user.Email, &user.ID, mail)
+	if err != nil {
+		switch err {
+		case data.ErrCooldown:
+			// Recent verification sent, don't mail another
+			return app.writeJSON(w, http.StatusOK, msg, nil)
+		default:
+			return err
+		}
+	}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+func (app *application) tokensAuthenticationPost(w http.ResponseWriter, r *http.Request) error {
 	var input struct {
-		Email string `json:"email"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
 	}
 
 	err := app.readJSON(r, &input)
@@ -151,57 +223,239 @@ func (app *application) tokensVerificaitonPasswordResetPost(w http.ResponseWrite
 
 	err = validation.ValidateStruct(&input,
 		validation.Field(&input.Email, validation.Required, is.Email),
+		validation.Field(&input.Password, validation.Required, data.PasswordLength),
+		validation.Field(&input.DeviceName, data.DeviceNameLength),
 	)
 	if err != nil {
 		return err
 	}
 
-	// This will be the consistent message. Even if a user
-	// already exists with this email, send this message.
-	msg := envelope{"message": verificationMsg}
+	// Enforce delays between failed logins before checking the password.
+	// The login counts as failed until the password matches, so
+	// parallel requests cannot get past the delays.
+	wait, err := app.reserveLoginAttempt(r, data.LoginScopeLogin, input.Email)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return app.loginThrottledResponse(w, r, wait)
+	}
 
-	// Check if user with email exists
-	exists, err := app.models.User.ExistsWithEmail(input.Email)
+	user, err := app.models.User.GetForCredentials(r.Context(), input.Email, input.Password)
+	if err != nil {
+		if err == data.ErrInvalidCredentials {
+			return app.writeError(w, r, http.StatusUnauthorized, InvalidCredentailsMessage)
+		}
+
+		return err
+	}
+
+	// Users with MFA enabled get a challenge to complete instead. The
+	// reserved attempt is kept until the challenge is completed.
+	enabled, err := app.models.TOTP.Enabled(r.Context(), user.ID)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		// User with email does not exist. Still send the same
-		// message.
-		return app.writeJSON(w, http.StatusOK, msg, nil)
+	if enabled {
+		t, err := app.models.MFAChallenge.New(r.Context(), user.ID, input.DeviceName)
+		if err != nil {
+			return err
+		}
+
+		return app.writeJSON(w, http.StatusOK, envelope{"mfa_challenge_token": t}, nil)
 	}
 
-	// Check if a verification token has already been created recently
-	exists, err = app.models.VerificationToken.Exists(data.ScopePasswordReset, input.Email, nil)
+	err = app.models.LoginAttempt.Reset(r.Context(), data.LoginScopeLogin, input.Email)
 	if err != nil {
 		return err
 	}
-	if exists {
-		// Recent verification sent, don't mail another
-		return app.writeJSON(w, http.StatusOK, msg, nil)
+
+	env, err := app.newLoginTokens(r.Context(), user, app.requestDevice(r, input.DeviceName))
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusCreated, env, nil)
+}
+
+// Reserve a login attempt in scope for the email. When the reservation
+// locks the email out of logging in, an unlock token is mailed in the
+// user's locale if a user with the email exists, however the attempt
+// turns out. Step-up checks only lock themselves until the lock
+// expires, so nothing is mailed for them.
+func (app *application) reserveLoginAttempt(r *http.Request, scope, email string) (time.Duration, error) {
+	attempt, wait, err := app.models.LoginAttempt.Reserve(r.Context(), scope, email)
+	if err != nil {
+		return 0, err
+	}
+
+	if attempt.NewlyLocked && scope == data.LoginScopeLogin {
+		err = app.mailUnlockToken(r, email)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return wait, nil
+}
+
+// Mail an unlock token to the user with the email, if one exists.
+func (app *application) mailUnlockToken(r *http.Request, email string) error {
+	user, err := app.models.User.GetForEmail(r.Context(), email)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	err = app.models.VerificationToken.PurgeWithScope(r.Context(), data.ScopeAccountUnlock, email)
+	if err != nil {
+		return err
+	}
+
+	mail := &data.Mail{
+		Recipient: email,
+		Locale:    app.mailLocale(r, user),
+		Template:  "account-unlock.tmpl",
+	}
+	_, err = app.models.VerificationToken.New(r.Context(), data.ScopeAccountUnlock, email, nil, mail)
+
+	return err
+}
+
+// Exchange an MFA challenge token and a TOTP or recovery code for an
+// authentication token.
+func (app *application) tokensMFAPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Token string `json:"token"`
+		mfaCodeInput
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Token, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = input.mfaCodeInput.Validate()
+	if err != nil {
+		return err
 	}
 
-	t, err := app.models.VerificationToken.New(data.ScopePasswordReset, input.Email, nil)
+	c, err := app.models.MFAChallenge.Get(r.Context(), input.Token)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound,
+			data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, InvalidMFAChallengeMessage)
+		default:
+			return err
+		}
+	}
+
+	user, err := app.models.User.Get(r.Context(), c.UserID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := app.verifyMFAAttempt(w, r, user, input.mfaCodeInput, c)
+	if !ok || err != nil {
+		return err
+	}
+
+	err = app.models.MFAChallenge.Delete(r.Context(), c)
+	if err != nil {
+		return err
+	}
+
+	env, err := app.newLoginTokens(r.Context(), user, app.requestDevice(r, c.DeviceName))
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusCreated, env, nil)
+}
+
+// Exchange a refresh token for a new authentication and refresh
+// token. The refresh token is rotated, and presenting one that was
+// already used revokes every token in its family. The session keeps
+// its record, with the authentication token replaced in place.
+func (app *application) tokensRefreshPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Token      string `json:"token"`
+		DeviceName string `json:"device_name"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Token, validation.Required),
+		validation.Field(&input.DeviceName, data.DeviceNameLength),
+	)
 	if err != nil {
 		return err
 	}
 
-	// Mail the plaintext token to the user's email address
-	app.background(func() error {
-		data := map[string]any{
-			"token": t.Plaintext,
+	var env envelope
+	reused := false
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		rt, err := tx.RefreshToken.Use(r.Context(), input.Token)
+		if err != nil {
+			// The family was revoked, which must be committed
+			if err == data.ErrReusedToken {
+				reused = true
+				return nil
+			}
+
+			return err
+		}
+
+		user, err := tx.User.Get(r.Context(), rt.UserID)
+		if err != nil {
+			return err
 		}
 
-		return app.sendMail(input.Email, "password-reset.tmpl", data)
+		env, err = app.rotateAuthenticationTokens(r.Context(), tx, user, rt.FamilyID, rt.AuthenticatedAt, app.requestDevice(r, input.DeviceName))
+		return err
 	})
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound,
+			data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, InvalidRefreshTokenMessage)
+		default:
+			return err
+		}
+	}
+	if reused {
+		return app.writeError(w, r, http.StatusUnauthorized, InvalidRefreshTokenMessage)
+	}
 
-	return app.writeJSON(w, http.StatusOK, msg, nil)
+	return app.writeJSON(w, http.StatusCreated, env, nil)
 }
 
-func (app *application) tokensAuthenticationPost(w http.ResponseWriter, r *http.Request) error {
+// Reauthenticate with the current password, and a second factor if
+// enabled, to exchange the request's token for tokens that satisfy
+// recent authentication checks. The session's previous refresh
+// tokens are revoked.
+func (app *application) tokensReauthenticationPost(w http.ResponseWriter, r *http.Request) error {
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
+		mfaCodeInput
 	}
 
 	err := app.readJSON(r, &input)
@@ -210,26 +464,207 @@ func (app *application) tokensAuthenticationPost(w http.ResponseWriter, r *http.
 	}
 
 	err = validation.ValidateStruct(&input,
-		validation.Field(&input.Email, validation.Required, is.Email),
 		validation.Field(&input.Password, validation.Required, data.PasswordLength),
+		validation.Field(&input.DeviceName, data.DeviceNameLength),
 	)
 	if err != nil {
 		return err
 	}
 
-	user, err := app.models.User.GetForCredentials(input.Email, input.Password)
+	user := app.contextGetUser(r)
+
+	ok, err := app.verifyCurrentPassword(w, r, user, input.Password)
+	if !ok || err != nil {
+		return err
+	}
+
+	enabled, err := app.models.TOTP.Enabled(r.Context(), user.ID)
 	if err != nil {
-		if err == data.ErrInvalidCredentials {
-			return app.writeError(w, http.StatusUnauthorized, InvalidCredentailsMessage)
+		return err
+	}
+	if enabled {
+		err = input.mfaCodeInput.Validate()
+		if err != nil {
+			return err
 		}
 
+		ok, err = app.verifyMFAAttempt(w, r, user, input.mfaCodeInput, nil)
+		if !ok || err != nil {
+			return err
+		}
+	}
+
+	// Personal access tokens have no session to continue
+	at := app.contextGetToken(r)
+	if at.FamilyID.IsNil() {
+		env, err := app.newLoginTokens(r.Context(), user, app.requestDevice(r, input.DeviceName))
+		if err != nil {
+			return err
+		}
+
+		return app.writeJSON(w, http.StatusCreated, env, nil)
+	}
+
+	var env envelope
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.RefreshToken.PurgeWithFamilyID(r.Context(), at.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		env, err = app.rotateAuthenticationTokens(r.Context(), tx, user, at.FamilyID, time.Now(), app.requestDevice(r, input.DeviceName))
 		return err
+	})
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.invalidAuthenticationTokenResponse(w, r)
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return app.writeJSON(w, http.StatusCreated, env, nil)
+}
+
+// Step-up check before sensitive changes. Unless the request's token
+// comes from a login within recentAuthMaxAge, the current password
+// must be given. Returns false, having written a response, if the
+// check fails.
+func (app *application) checkRecentAuth(w http.ResponseWriter, r *http.Request, password *string) (bool, error) {
+	user := app.contextGetUser(r)
+
+	if password != nil {
+		return app.verifyCurrentPassword(w, r, user, *password)
+	}
+
+	if !app.contextGetToken(r).Recent(recentAuthMaxAge) {
+		return false, app.writeError(w, r, http.StatusForbidden, RecentAuthRequiredMessage)
+	}
+
+	return true, nil
+}
+
+// Check password against the user's current password, subject to
+// the same delays and lockout as logging in. Failures are counted
+// apart from logins, so they cannot lock the user out of logging in.
+// Returns false, having written a response, if the password is wrong
+// or checks for the user's email are throttled.
+func (app *application) verifyCurrentPassword(w http.ResponseWriter, r *http.Request, user *data.User, password string) (bool, error) {
+	wait, err := app.reserveLoginAttempt(r, data.LoginScopeStepUp, user.Email)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		return false, app.loginThrottledResponse(w, r, wait)
 	}
 
-	t, err := app.models.AuthenticationToken.New(user.ID)
+	match, err := user.MatchesPassword(password)
+	if err != nil {
+		return false, err
+	}
+	if !match {
+		return false, app.writeError(w, r, http.StatusUnauthorized, InvalidCredentailsMessage)
+	}
+
+	return true, app.models.LoginAttempt.Reset(r.Context(), data.LoginScopeStepUp, user.Email)
+}
+
+// Create tokens for a new session after the user logged in.
+func (app *application) newLoginTokens(ctx context.Context, user *data.User, device data.Device) (envelope, error) {
+	familyID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	return app.newAuthenticationTokens(ctx, user, familyID, time.Now(), device)
+}
+
+// Create an authentication and refresh token for the user in the
+// token family. authenticatedAt is when the session last logged in.
+func (app *application) newAuthenticationTokens(ctx context.Context, user *data.User, familyID uuid.UUID, authenticatedAt time.Time, device data.Device) (envelope, error) {
+	t, err := app.models.AuthenticationToken.New(ctx, user, familyID, authenticatedAt, device)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := app.models.RefreshToken.New(ctx, user.ID, familyID, authenticatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"authentication_token": t, "refresh_token": rt}, nil
+}
+
+// Replace the authentication token of the session in the token family
+// with models, and add a refresh token. authenticatedAt is when the
+// session last logged in.
+func (app *application) rotateAuthenticationTokens(ctx context.Context, models data.Models, user *data.User, familyID uuid.UUID, authenticatedAt time.Time, device data.Device) (envelope, error) {
+	t, err := models.AuthenticationToken.Rotate(ctx, user, familyID, authenticatedAt, device)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := models.RefreshToken.New(ctx, user.ID, familyID, authenticatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"authentication_token": t, "refresh_token": rt}, nil
+}
+
+// Revoke the authentication token used to make the request. In signed
+// token mode the session and its refresh tokens are revoked, but the
+// token itself stays valid until it expires, at most
+// data.AuthenticationTokenTTL later. Logging out of all sessions
+// revokes signed tokens too.
+func (app *application) tokensAuthenticationDelete(w http.ResponseWriter, r *http.Request) error {
+	token := app.contextGetToken(r)
+
+	err := app.models.AuthenticationToken.Delete(r.Context(), token.Plaintext)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.invalidAuthenticationTokenResponse(w, r)
+			return nil
+		default:
+			return err
+		}
+	}
+
+	msg := envelope{"message": app.translate(r, "you have been logged out")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+// Revoke every authentication token belonging to the authenticated
+// user, logging them out of all sessions. The user's version is bumped
+// so signed tokens are checked against their now deleted sessions.
+// Other processes serving the user from cache reject the tokens once
+// it expires, at most data.UserCacheTTL later.
+func (app *application) tokensAuthenticationAllDelete(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.AuthenticationToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.RefreshToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.User.BumpVersion(r.Context(), user)
+	})
 	if err != nil {
 		return err
 	}
 
-	return app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": t}, nil)
+	msg := envelope{"message": app.translate(r, "you have been logged out of all sessions")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
 }
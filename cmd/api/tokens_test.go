@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micahco/api/internal/data"
+)
+
+// Claim the next queued mail.
+func claimMail(t *testing.T, app *application) data.Mail {
+	t.Helper()
+
+	job, err := app.models.Job.Claim(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mail data.Mail
+	err = json.Unmarshal(job.Payload, &mail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mail
+}
+
+// Create a user with locale.
+func newLocaleUser(t *testing.T, app *application, email, locale string) *data.User {
+	t.Helper()
+
+	user, _ := newTestUser(t, app, email)
+	user.Locale = locale
+
+	err := app.models.User.Update(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestPasswordResetMailLocale(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newLocaleUser(t, app, "frank@example.com", "fr")
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/tokens/verification/password-reset",
+		strings.NewReader(`{"email": "FRANK@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "es")
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusOK)
+	}
+
+	mail := claimMail(t, app)
+	if mail.Template != "password-reset.tmpl" || mail.Locale != "fr" {
+		t.Errorf("got %s in %q; want password-reset.tmpl in %q", mail.Template, mail.Locale, "fr")
+	}
+}
+
+// Record failed logins in scope for the email so that the next attempt
+// locks it.
+func insertNearLockout(t *testing.T, app *application, scope, email string) {
+	t.Helper()
+
+	err := app.models.LoginAttempt.Insert(context.Background(), &data.LoginAttempt{
+		Scope:         scope,
+		Email:         email,
+		Failures:      data.LoginLockoutThreshold - 1,
+		LastFailureAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnlockMailLocale(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newLocaleUser(t, app, "gina@example.com", "es")
+
+	login := func(email string) int {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/tokens/authentication",
+			strings.NewReader(`{"email": "`+email+`", "password": "wrongpassword"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", "fr")
+
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	// Failures below the threshold do not mail
+	if code := login("gina@example.com"); code != http.StatusUnauthorized {
+		t.Fatalf("got status %d; want %d", code, http.StatusUnauthorized)
+	}
+	if _, err := app.models.Job.Claim(context.Background()); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v; want no mail", err)
+	}
+
+	insertNearLockout(t, app, data.LoginScopeLogin, "gina@example.com")
+
+	if code := login("gina@example.com"); code != http.StatusUnauthorized {
+		t.Fatalf("got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	mail := claimMail(t, app)
+	if mail.Template != "account-unlock.tmpl" || mail.Locale != "es" {
+		t.Errorf("got %s in %q; want account-unlock.tmpl in %q", mail.Template, mail.Locale, "es")
+	}
+
+	// Attempts while locked do not mail again
+	if code := login("gina@example.com"); code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d; want %d", code, http.StatusTooManyRequests)
+	}
+	if _, err := app.models.Job.Claim(context.Background()); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v; want a single mail", err)
+	}
+
+	// No mail for an address without an account
+	insertNearLockout(t, app, data.LoginScopeLogin, "nobody@example.com")
+
+	if code := login("nobody@example.com"); code != http.StatusUnauthorized {
+		t.Fatalf("got status %d; want %d", code, http.StatusUnauthorized)
+	}
+	if _, err := app.models.Job.Claim(context.Background()); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v; want no mail", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "iris@example.com")
+
+	credentials := map[string]any{
+		"email":    "iris@example.com",
+		"password": "pa55word1234",
+	}
+
+	insertNearLockout(t, app, data.LoginScopeLogin, "iris@example.com")
+
+	code, _ := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{
+		"email":    "iris@example.com",
+		"password": "wrongpassword",
+	})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("locking attempt: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	mail := claimMail(t, app)
+	if mail.Template != "account-unlock.tmpl" {
+		t.Fatalf("got %s; want account-unlock.tmpl", mail.Template)
+	}
+
+	// The right password is refused while locked
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", credentials)
+	if code != http.StatusTooManyRequests {
+		t.Errorf("locked: got status %d; want %d", code, http.StatusTooManyRequests)
+	}
+
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/unlock", "", map[string]any{
+		"email": "iris@example.com",
+		"token": "wrongtoken",
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("wrong token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/unlock", "", map[string]any{
+		"email": "iris@example.com",
+		"token": mail.Data["token"],
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unlock: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", credentials)
+	if code != http.StatusCreated {
+		t.Errorf("unlocked: got status %d; want %d", code, http.StatusCreated)
+	}
+
+	// The unlock token is single use
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/unlock", "", map[string]any{
+		"email": "iris@example.com",
+		"token": mail.Data["token"],
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("reused token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestLoginLockoutRightPassword(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "jack@example.com")
+
+	insertNearLockout(t, app, data.LoginScopeLogin, "jack@example.com")
+
+	// The attempt reaching the threshold mails even though it succeeds
+	code, _ := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{
+		"email":    "jack@example.com",
+		"password": "pa55word1234",
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", code, http.StatusCreated)
+	}
+
+	mail := claimMail(t, app)
+	if mail.Template != "account-unlock.tmpl" {
+		t.Errorf("got %s; want account-unlock.tmpl", mail.Template)
+	}
+}
+
+func TestValidationErrorsTranslated(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		locale string
+		want   map[string]string
+	}{
+		{"en", map[string]string{"email": "must be a valid email address", "password": "cannot be blank"}},
+		{"fr", map[string]string{"email": "doit être une adresse e-mail valide", "password": "ne peut pas être vide"}},
+		{"es", map[string]string{"email": "debe ser una dirección de correo válida", "password": "no puede estar vacío"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/tokens/authentication",
+				strings.NewReader(`{"email": "not an email"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept-Language", tt.locale)
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusUnprocessableEntity)
+			}
+
+			var env struct {
+				Error map[string]string `json:"error"`
+			}
+			err = json.NewDecoder(res.Body).Decode(&env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for field, want := range tt.want {
+				if got := env.Error[field]; got != want {
+					t.Errorf("%s: got %q; want %q", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStepUpLockoutKeepsLogin(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "hana@example.com")
+
+	// Lock step-up checks, as a stolen token guessing the password would
+	insertNearLockout(t, app, data.LoginScopeStepUp, "hana@example.com")
+
+	code, _ := ts.do(t, http.MethodPost, "/v1/tokens/reauthentication", token, map[string]any{
+		"password": "wrongpassword",
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("locking step-up: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/reauthentication", token, map[string]any{
+		"password": "pa55word1234",
+	})
+	if code != http.StatusTooManyRequests {
+		t.Errorf("step-up: got status %d; want %d", code, http.StatusTooManyRequests)
+	}
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{
+		"email":    "hana@example.com",
+		"password": "pa55word1234",
+	})
+	if code != http.StatusCreated {
+		t.Errorf("login: got status %d; want %d", code, http.StatusCreated)
+	}
+
+	if _, err := app.models.Job.Claim(context.Background()); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v; want no unlock mail", err)
+	}
+}
+
+// Log in with the password, returning the authentication and refresh
+// tokens.
+func login(t *testing.T, ts *testServer, email, deviceName string) (string, string) {
+	t.Helper()
+
+	code, env := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{
+		"email":       email,
+		"password":    "pa55word1234",
+		"device_name": deviceName,
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("login: got status %d; want %d", code, http.StatusCreated)
+	}
+
+	return tokenPair(t, env)
+}
+
+// Authentication and refresh tokens from a login response.
+func tokenPair(t *testing.T, env map[string]any) (string, string) {
+	t.Helper()
+
+	at, _ := env["authentication_token"].(map[string]any)
+	rt, _ := env["refresh_token"].(map[string]any)
+	if at == nil || rt == nil {
+		t.Fatalf("got %v; want authentication and refresh tokens", env)
+	}
+
+	return at["token"].(string), rt["token"].(string)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "kate@example.com")
+	at, rt := login(t, ts, "kate@example.com", "phone")
+
+	code, env := ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": rt})
+	if code != http.StatusCreated {
+		t.Fatalf("refresh: got status %d; want %d", code, http.StatusCreated)
+	}
+	newAT, newRT := tokenPair(t, env)
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", newAT, nil)
+	if code != http.StatusOK {
+		t.Errorf("new token: got status %d; want %d", code, http.StatusOK)
+	}
+
+	// The session's token is replaced
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", at, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("replaced token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	// Reusing a rotated refresh token revokes the family
+	code, env = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": rt})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("reused: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+	if msg := env["error"]; msg != InvalidRefreshTokenMessage {
+		t.Errorf("got error %v; want %q", msg, InvalidRefreshTokenMessage)
+	}
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": newRT})
+	if code != http.StatusUnauthorized {
+		t.Errorf("revoked family: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", newAT, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("revoked family token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestRefreshTokenFamilies(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "liam@example.com")
+	_, phone := login(t, ts, "liam@example.com", "phone")
+	laptopAT, laptop := login(t, ts, "liam@example.com", "laptop")
+
+	code, _ := ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": phone})
+	if code != http.StatusCreated {
+		t.Fatalf("refresh: got status %d; want %d", code, http.StatusCreated)
+	}
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": phone})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("reused: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	// Other sessions are left alone
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", laptopAT, nil)
+	if code != http.StatusOK {
+		t.Errorf("other session: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": laptop})
+	if code != http.StatusCreated {
+		t.Errorf("other family: got status %d; want %d", code, http.StatusCreated)
+	}
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": "unknown"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "mia@example.com")
+	phone, _ := login(t, ts, "mia@example.com", "phone")
+	laptop, laptopRT := login(t, ts, "mia@example.com", "laptop")
+	tablet, tabletRT := login(t, ts, "mia@example.com", "tablet")
+
+	code, _ := ts.do(t, http.MethodDelete, "/v1/tokens/authentication", phone, nil)
+	if code != http.StatusOK {
+		t.Fatalf("logout: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", phone, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("logged out: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", laptop, nil)
+	if code != http.StatusOK {
+		t.Errorf("other session: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodDelete, "/v1/tokens/authentication/all", laptop, nil)
+	if code != http.StatusOK {
+		t.Fatalf("logout all: got status %d; want %d", code, http.StatusOK)
+	}
+
+	for _, token := range []string{laptop, tablet} {
+		code, _ = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+		if code != http.StatusUnauthorized {
+			t.Errorf("after logout all: got status %d; want %d", code, http.StatusUnauthorized)
+		}
+	}
+
+	// Sessions cannot be refreshed back either
+	for _, token := range []string{laptopRT, tabletRT} {
+		code, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"token": token})
+		if code != http.StatusUnauthorized {
+			t.Errorf("refresh after logout all: got status %d; want %d", code, http.StatusUnauthorized)
+		}
+	}
+}
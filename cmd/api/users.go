@@ -2,9 +2,13 @@ package main
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/gofrs/uuid/v5"
 	"github.com/micahco/api/internal/data"
 )
 
@@ -31,24 +35,31 @@ func (app *application) usersPost(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
-	err = app.models.VerificationToken.Verify(input.Token, data.ScopeRegistration, input.Email, nil)
+	err = app.models.VerificationToken.Verify(r.Context(), input.Token, data.ScopeRegistration, input.Email, nil)
 	if err != nil {
 		switch err {
 		case data.ErrRecordNotFound:
-			return app.writeError(w, http.StatusUnauthorized, nil)
+			return app.writeError(w, r, http.StatusUnauthorized, nil)
 		case data.ErrExpiredToken:
-			return app.writeError(w, http.StatusUnauthorized, "Expired token. Please signup again.")
+			return app.writeError(w, r, http.StatusUnauthorized, "Expired token. Please signup again.")
+		case data.ErrTooManyAttempts:
+			return app.writeError(w, r, http.StatusTooManyRequests, TooManyAttemptsMessage)
 		default:
 			return err
 		}
 	}
 
-	err = app.models.VerificationToken.PurgeWithEmail(input.Email)
-	if err != nil {
-		return err
-	}
+	var user *data.User
 
-	user, err := app.models.User.New(input.Email, input.Password)
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.VerificationToken.PurgeWithEmail(r.Context(), input.Email)
+		if err != nil {
+			return err
+		}
+
+		user, err = tx.User.New(r.Context(), input.Email, input.Password)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -75,9 +86,15 @@ func (app *application) usersPasswordPut(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	user, err := app.models.User.GetForVerificationToken(data.ScopePasswordReset, input.Token)
+	user, _, err := app.models.User.GetForVerificationToken(r.Context(), data.ScopePasswordReset, input.Token)
 	if err != nil {
-		switch {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusUnauthorized, nil)
+		case data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, "Expired token")
+		case data.ErrTooManyAttempts:
+			return app.writeError(w, r, http.StatusTooManyRequests, TooManyAttemptsMessage)
 		default:
 			return err
 		}
@@ -88,20 +105,200 @@ func (app *application) usersPasswordPut(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	err = app.models.User.Update(user)
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.User.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.VerificationToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		// A password reset also unlocks the account
+		return tx.LoginAttempt.Reset(r.Context(), data.LoginScopeLogin, user.Email)
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := envelope{"message": app.translate(r, "your password was successfully reset")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+// Unlock an account locked by failed logins if provided token matches
+// the unlock verification.
+func (app *application) usersUnlockPut(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Email string `json:"email"`
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Email, validation.Required, is.Email),
+		validation.Field(&input.Token, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.VerificationToken.Verify(r.Context(), input.Token, data.ScopeAccountUnlock, input.Email, nil)
 	if err != nil {
-		switch {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusUnauthorized, nil)
+		case data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, "Expired token")
+		case data.ErrTooManyAttempts:
+			return app.writeError(w, r, http.StatusTooManyRequests, TooManyAttemptsMessage)
 		default:
 			return err
 		}
 	}
 
-	err = app.models.VerificationToken.PurgeWithUserID(user.ID)
+	err = app.models.VerificationToken.PurgeWithScope(r.Context(), data.ScopeAccountUnlock, input.Email)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.LoginAttempt.Reset(r.Context(), data.LoginScopeLogin, input.Email)
 	if err != nil {
 		return err
 	}
 
-	msg := envelope{"message": "your password was successfully reset"}
+	msg := envelope{"message": app.translate(r, "your account was successfully unlocked")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+// Restore the email address an account had before an email change,
+// given the revert token mailed to that address. Every token for the
+// account is revoked, second factors are removed and the password
+// must be reset, since whoever changed the email may know it.
+func (app *application) usersEmailRevertPut(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Email string `json:"email"`
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Email, validation.Required, is.Email),
+		validation.Field(&input.Token, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	user, vt, err := app.models.User.GetForVerificationToken(r.Context(), data.ScopeEmailRevert, input.Token)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusUnauthorized, nil)
+		case data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, "Expired token")
+		case data.ErrTooManyAttempts:
+			return app.writeError(w, r, http.StatusTooManyRequests, TooManyAttemptsMessage)
+		default:
+			return err
+		}
+	}
+
+	// The token is only good for the address it was mailed to
+	if !strings.EqualFold(vt.Email, input.Email) {
+		return app.writeError(w, r, http.StatusUnauthorized, nil)
+	}
+
+	user.Email = vt.Email
+
+	err = user.SetRandomPassword()
+	if err != nil {
+		return err
+	}
+
+	mail := &data.Mail{
+		Recipient: input.Email,
+		Locale:    app.mailLocale(r, user),
+		Template:  "password-reset.tmpl",
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.User.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.AuthenticationToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.RefreshToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.PersonalAccessToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.VerificationToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.VerificationToken.PurgeWithScopeUserID(r.Context(), data.ScopeEmailRevert, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// Second factors may have been added by whoever took the
+		// account, and would lock the owner out
+		err = tx.TOTP.Delete(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.RecoveryCode.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.MFAChallenge.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.WebAuthnCredential.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.VerificationToken.New(r.Context(), data.ScopePasswordReset, user.Email, &user.ID, mail)
+		return err
+	})
+	if err != nil {
+		switch err {
+		case data.ErrDuplicateEmail:
+			return app.writeError(w, r, http.StatusConflict, "email address is already in use")
+		default:
+			return err
+		}
+	}
+
+	msg := envelope{"message": app.translate(r, "your email was restored. A token to reset your password has been sent to your inbox.")}
 
 	return app.writeJSON(w, http.StatusOK, msg, nil)
 }
@@ -114,9 +311,11 @@ func (app *application) usersMeGet(w http.ResponseWriter, r *http.Request) error
 
 func (app *application) usersMePut(w http.ResponseWriter, r *http.Request) error {
 	var input struct {
-		Email    *string `json:"email"`
-		Password *string `json:"password"`
-		Token    *string `json:"token"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		Token           *string `json:"token"`
+		CurrentPassword *string `json:"current_password"`
+		Locale          *string `json:"locale"`
 	}
 
 	err := app.readJSON(r, &input)
@@ -128,31 +327,38 @@ func (app *application) usersMePut(w http.ResponseWriter, r *http.Request) error
 		validation.Field(&input.Email, is.Email),
 		validation.Field(&input.Password, data.PasswordLength),
 		validation.Field(&input.Token),
+		validation.Field(&input.CurrentPassword, data.PasswordLength),
+		validation.Field(&input.Locale, validation.In(supportedLocales...)),
 	)
 	if err != nil {
 		return err
 	}
 
+	if input.Email != nil || input.Password != nil {
+		ok, err := app.checkRecentAuth(w, r, input.CurrentPassword)
+		if !ok || err != nil {
+			return err
+		}
+	}
+
 	user := app.contextGetUser(r)
+	oldEmail := user.Email
 
 	if input.Email != nil && input.Token != nil {
-		err = app.models.VerificationToken.Verify(*input.Token, data.ScopeEmailChange, *input.Email, &user.ID)
+		err = app.models.VerificationToken.Verify(r.Context(), *input.Token, data.ScopeEmailChange, *input.Email, &user.ID)
 		if err != nil {
 			switch err {
 			case data.ErrRecordNotFound:
-				return app.writeError(w, http.StatusUnauthorized, nil)
+				return app.writeError(w, r, http.StatusUnauthorized, nil)
 			case data.ErrExpiredToken:
-				return app.writeError(w, http.StatusUnauthorized, "Expired token")
+				return app.writeError(w, r, http.StatusUnauthorized, "Expired token")
+			case data.ErrTooManyAttempts:
+				return app.writeError(w, r, http.StatusTooManyRequests, TooManyAttemptsMessage)
 			default:
 				return err
 			}
 		}
 
-		err = app.models.VerificationToken.PurgeWithUserID(user.ID)
-		if err != nil {
-			return err
-		}
-
 		user.Email = *input.Email
 	}
 
@@ -163,10 +369,209 @@ func (app *application) usersMePut(w http.ResponseWriter, r *http.Request) error
 		}
 	}
 
-	err = app.models.User.Update(user)
+	// An empty locale clears the preference
+	if input.Locale != nil {
+		user.Locale = *input.Locale
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		if user.Email == oldEmail {
+			return tx.User.Update(r.Context(), user)
+		}
+
+		err := tx.VerificationToken.PurgeWithUserID(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.User.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		// Let the previous address undo the change, in case the
+		// account was taken over
+		mail := &data.Mail{
+			Recipient: oldEmail,
+			Locale:    app.mailLocale(r, user),
+			Template:  "email-revert.tmpl",
+			Data:      map[string]any{"email": user.Email},
+		}
+
+		_, err = tx.VerificationToken.New(r.Context(), data.ScopeEmailRevert, oldEmail, &user.ID, mail)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
 	return app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
 }
+
+// Delete the authenticated user if provided token matches an
+// account deletion verification. Authentication and verification
+// tokens are removed with the user.
+func (app *application) usersMeDelete(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Token           string  `json:"token"`
+		CurrentPassword *string `json:"current_password"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Token, validation.Required),
+		validation.Field(&input.CurrentPassword, data.PasswordLength),
+	)
+	if err != nil {
+		return err
+	}
+
+	ok, err := app.checkRecentAuth(w, r, input.CurrentPassword)
+	if !ok || err != nil {
+		return err
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.VerificationToken.Verify(r.Context(), input.Token, data.ScopeAccountDeletion, user.Email, &user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusUnauthorized, nil)
+		case data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, "Expired token")
+		case data.ErrTooManyAttempts:
+			return app.writeError(w, r, http.StatusTooManyRequests, TooManyAttemptsMessage)
+		default:
+			return err
+		}
+	}
+
+	err = app.models.User.Delete(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	msg := envelope{"message": app.translate(r, "your account was successfully deleted")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+// List the authenticated user's active sessions.
+func (app *application) usersMeSessionsGet(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+	token := app.contextGetToken(r)
+
+	sessions, err := app.models.AuthenticationToken.GetAllForUser(r.Context(), user.ID, token.Plaintext)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+}
+
+// Revoke one of the authenticated user's sessions. In signed token mode
+// the session can no longer be refreshed, but its current token stays
+// valid until it expires, at most data.AuthenticationTokenTTL later.
+func (app *application) usersMeSessionsDelete(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return app.writeError(w, r, http.StatusNotFound, nil)
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.AuthenticationToken.DeleteForUser(r.Context(), id, user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusNotFound, nil)
+		default:
+			return err
+		}
+	}
+
+	msg := envelope{"message": app.translate(r, "session successfully revoked")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
+
+// List the authenticated user's personal access tokens.
+func (app *application) usersMeTokensGet(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	tokens, err := app.models.PersonalAccessToken.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"personal_access_tokens": tokens}, nil)
+}
+
+// Create a named personal access token with scopes for the
+// authenticated user. The plaintext token is only returned once.
+// Creating one requires recent authentication, which personal access
+// tokens never have, so they cannot create further tokens.
+func (app *application) usersMeTokensPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		Name   string    `json:"name"`
+		Scopes []string  `json:"scopes"`
+		Expiry time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&input.Scopes, validation.Required, validation.Each(validation.In(data.TokenScopes...))),
+		validation.Field(&input.Expiry, validation.Required,
+			validation.Min(now).Error("must be in the future"),
+			validation.Max(now.Add(data.PersonalAccessTokenMaxTTL)).Error("must be within a year"),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	user := app.contextGetUser(r)
+
+	pat, err := app.models.PersonalAccessToken.New(r.Context(), user.ID, input.Name, input.Scopes, input.Expiry)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusCreated, envelope{"personal_access_token": pat}, nil)
+}
+
+// Revoke one of the authenticated user's personal access tokens.
+func (app *application) usersMeTokensDelete(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return app.writeError(w, r, http.StatusNotFound, nil)
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.PersonalAccessToken.DeleteForUser(r.Context(), id, user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusNotFound, nil)
+		default:
+			return err
+		}
+	}
+
+	msg := envelope{"message": app.translate(r, "personal access token successfully revoked")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
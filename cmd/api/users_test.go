@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/micahco/api/internal/data"
+)
+
+func TestPasswordReset(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "alice@example.com")
+
+	code, _ := ts.do(t, http.MethodPost, "/v1/tokens/verification/password-reset", "", map[string]any{
+		"email": "alice@example.com",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("request: got status %d; want %d", code, http.StatusOK)
+	}
+
+	token, _ := claimMail(t, app).Data["token"].(string)
+	if token == "" {
+		t.Fatal("reset mail has no token")
+	}
+
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]any{
+		"password": "n3w pa55word",
+		"token":    token,
+	})
+	if code != http.StatusOK {
+		t.Fatalf("reset: got status %d; want %d", code, http.StatusOK)
+	}
+
+	_, err := app.models.User.GetForCredentials(context.Background(), "alice@example.com", "n3w pa55word")
+	if err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+
+	// The token is used up
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]any{
+		"password": "an0ther pa55word",
+		"token":    token,
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("reused token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	_, err = app.models.User.GetForCredentials(context.Background(), "alice@example.com", "an0ther pa55word")
+	if err != data.ErrInvalidCredentials {
+		t.Errorf("got error %v; want %v", err, data.ErrInvalidCredentials)
+	}
+}
+
+// Create a personal access token for the user with scopes.
+func newTestPAT(t *testing.T, app *application, user *data.User, scopes ...string) string {
+	t.Helper()
+
+	pat, err := app.models.PersonalAccessToken.New(context.Background(), user.ID, "test", scopes, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pat.Plaintext
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, token := newTestUser(t, app, "alice@example.com")
+
+	code, env := ts.do(t, http.MethodPost, "/v1/users/me/tokens", token, map[string]any{
+		"name":   "ci",
+		"scopes": []string{data.TokenScopeUserRead},
+		"expiry": time.Now().Add(time.Hour),
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("create: got status %d; want %d: %v", code, http.StatusCreated, env)
+	}
+	created := env["personal_access_token"].(map[string]any)
+	pat := created["token"].(string)
+
+	code, env = ts.do(t, http.MethodGet, "/v1/users/me/tokens", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("list: got status %d; want %d", code, http.StatusOK)
+	}
+	tokens := env["personal_access_tokens"].([]any)
+	if len(tokens) != 1 {
+		t.Fatalf("got %d tokens; want 1", len(tokens))
+	}
+	listed := tokens[0].(map[string]any)
+	if listed["id"] != created["id"] || listed["name"] != "ci" {
+		t.Errorf("got token %v; want the created token", listed)
+	}
+	if _, ok := listed["token"]; ok {
+		t.Error("listed token includes the plaintext")
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", pat, nil)
+	if code != http.StatusOK {
+		t.Errorf("in scope: got status %d; want %d", code, http.StatusOK)
+	}
+
+	// Tokens cannot create more tokens, lacking recent authentication
+	all := newTestPAT(t, app, user, data.TokenScopeTokensRead, data.TokenScopeTokensWrite)
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/users/me/tokens", all, map[string]any{
+		"name":   "escalated",
+		"scopes": []string{data.TokenScopeUserWrite},
+		"expiry": time.Now().Add(time.Hour),
+	})
+	if code != http.StatusForbidden {
+		t.Errorf("create with a token: got status %d; want %d", code, http.StatusForbidden)
+	}
+
+	path := "/v1/users/me/tokens/" + created["id"].(string)
+
+	code, _ = ts.do(t, http.MethodDelete, path, token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("revoke: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", pat, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("revoked: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodDelete, path, token, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("revoke again: got status %d; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "dave@example.com")
+
+	for range 2 {
+		code, _ := ts.do(t, http.MethodPost, "/v1/tokens/verification/account-deletion", token, nil)
+		if code != http.StatusOK {
+			t.Fatalf("request: got status %d; want %d", code, http.StatusOK)
+		}
+	}
+
+	mail := claimMail(t, app)
+	if mail.Template != "account-deletion.tmpl" || mail.Recipient != "dave@example.com" {
+		t.Fatalf("got %s to %s; want account-deletion.tmpl to dave@example.com", mail.Template, mail.Recipient)
+	}
+
+	// A second request within the cooldown does not mail again
+	if _, err := app.models.Job.Claim(context.Background()); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v; want a single mail", err)
+	}
+
+	// The token only deletes the account it was issued for
+	_, other := newTestUser(t, app, "erin@example.com")
+
+	code, _ := ts.do(t, http.MethodDelete, "/v1/users/me", other, map[string]any{"token": mail.Data["token"]})
+	if code != http.StatusUnauthorized {
+		t.Errorf("other user: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodDelete, "/v1/users/me", token, map[string]any{"token": mail.Data["token"]})
+	if code != http.StatusOK {
+		t.Fatalf("delete: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("deleted user's token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	exists, err := app.models.User.ExistsWithEmail(context.Background(), "dave@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("deleted user exists")
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", other, nil)
+	if code != http.StatusOK {
+		t.Errorf("other user: got status %d; want %d", code, http.StatusOK)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "fay@example.com")
+	phone, _ := login(t, ts, "fay@example.com", "phone")
+	laptop, _ := login(t, ts, "fay@example.com", "laptop")
+
+	// The session created by newTestUser has no device name
+	code, env := ts.do(t, http.MethodGet, "/v1/users/me/sessions", phone, nil)
+	if code != http.StatusOK {
+		t.Fatalf("list: got status %d; want %d", code, http.StatusOK)
+	}
+
+	sessions := map[string]map[string]any{}
+	for _, s := range env["sessions"].([]any) {
+		s := s.(map[string]any)
+		sessions[s["device_name"].(string)] = s
+	}
+	if len(sessions) != 3 || sessions["phone"] == nil || sessions["laptop"] == nil {
+		t.Fatalf("got sessions %v; want phone, laptop and one unnamed", env["sessions"])
+	}
+	if sessions["phone"]["current"] != true || sessions["laptop"]["current"] != false {
+		t.Errorf("got current %v and %v; want the phone session current", sessions["phone"]["current"], sessions["laptop"]["current"])
+	}
+	if sessions["phone"]["ip"] != "127.0.0.1" || sessions["phone"]["user_agent"] == "" {
+		t.Errorf("got ip %v and user agent %v; want the client's", sessions["phone"]["ip"], sessions["phone"]["user_agent"])
+	}
+
+	path := "/v1/users/me/sessions/" + sessions["laptop"]["id"].(string)
+
+	// Sessions of other users cannot be revoked
+	_, other := newTestUser(t, app, "gus@example.com")
+
+	code, _ = ts.do(t, http.MethodDelete, path, other, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("other user: got status %d; want %d", code, http.StatusNotFound)
+	}
+
+	code, _ = ts.do(t, http.MethodDelete, path, phone, nil)
+	if code != http.StatusOK {
+		t.Fatalf("revoke: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", laptop, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("revoked session: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", phone, nil)
+	if code != http.StatusOK {
+		t.Errorf("current session: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodDelete, path, phone, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("revoke again: got status %d; want %d", code, http.StatusNotFound)
+	}
+}
+
+// Change the user's email through the verification flow, returning
+// the revert mail sent to the previous address.
+func changeEmail(t *testing.T, app *application, ts *testServer, token, email string) data.Mail {
+	t.Helper()
+
+	code, _ := ts.do(t, http.MethodPost, "/v1/tokens/verification/email-change", token, map[string]any{"email": email})
+	if code != http.StatusOK {
+		t.Fatalf("request: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/me", token, map[string]any{
+		"email": email,
+		"token": claimMail(t, app).Data["token"],
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("change: got status %d; want %d", code, http.StatusCreated)
+	}
+
+	return claimMail(t, app)
+}
+
+func TestEmailRevert(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, token := newTestUser(t, app, "hugo@example.com")
+	enableTOTP(t, app, user)
+
+	mail := changeEmail(t, app, ts, token, "thief@example.com")
+	if mail.Template != "email-revert.tmpl" || mail.Recipient != "hugo@example.com" {
+		t.Fatalf("got %s to %s; want email-revert.tmpl to hugo@example.com", mail.Template, mail.Recipient)
+	}
+	if mail.Data["email"] != "thief@example.com" {
+		t.Errorf("got new email %v; want thief@example.com", mail.Data["email"])
+	}
+
+	revert := mail.Data["token"]
+
+	// The token is only good for the address it was mailed to
+	code, _ := ts.do(t, http.MethodPut, "/v1/users/email-revert", "", map[string]any{
+		"email": "thief@example.com",
+		"token": revert,
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("other email: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/email-revert", "", map[string]any{
+		"email": "hugo@example.com",
+		"token": revert,
+	})
+	if code != http.StatusOK {
+		t.Fatalf("revert: got status %d; want %d", code, http.StatusOK)
+	}
+
+	ctx := context.Background()
+
+	user, err := app.models.User.Get(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "hugo@example.com" {
+		t.Errorf("got email %s; want hugo@example.com", user.Email)
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("token after revert: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+
+	_, err = app.models.User.GetForCredentials(ctx, "hugo@example.com", "pa55word1234")
+	if err != data.ErrInvalidCredentials {
+		t.Errorf("old password: got error %v; want %v", err, data.ErrInvalidCredentials)
+	}
+
+	enabled, err := app.models.TOTP.Enabled(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled {
+		t.Error("TOTP still enabled after revert")
+	}
+
+	// The owner sets a new password with the mailed reset token
+	mail = claimMail(t, app)
+	if mail.Template != "password-reset.tmpl" || mail.Recipient != "hugo@example.com" {
+		t.Fatalf("got %s to %s; want password-reset.tmpl to hugo@example.com", mail.Template, mail.Recipient)
+	}
+
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/password", "", map[string]any{
+		"password": "n3w pa55word",
+		"token":    mail.Data["token"],
+	})
+	if code != http.StatusOK {
+		t.Errorf("reset: got status %d; want %d", code, http.StatusOK)
+	}
+
+	// The revert token is used up
+	code, _ = ts.do(t, http.MethodPut, "/v1/users/email-revert", "", map[string]any{
+		"email": "hugo@example.com",
+		"token": revert,
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("reused token: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/api/internal/data"
+)
+
+// Adapts a user and their registered credentials to webauthn.User
+type webauthnUser struct {
+	user  *data.User
+	creds []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return u.user.ID.Bytes()
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.creds
+}
+
+func (app *application) loadWebAuthnUser(ctx context.Context, user *data.User) (*webauthnUser, error) {
+	rows, err := app.models.WebAuthnCredential.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	wu := &webauthnUser{user: user}
+	for _, row := range rows {
+		var cred webauthn.Credential
+
+		err = json.Unmarshal(row.Data, &cred)
+		if err != nil {
+			return nil, err
+		}
+
+		wu.creds = append(wu.creds, cred)
+	}
+
+	return wu, nil
+}
+
+// Credential as listed to its owner, with the ID encoded the same way
+// as in WebAuthn ceremonies.
+type webauthnCredentialResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func newWebAuthnCredentialResponse(c *data.WebAuthnCredential) webauthnCredentialResponse {
+	return webauthnCredentialResponse{
+		ID:         base64.RawURLEncoding.EncodeToString(c.ID),
+		Name:       c.Name,
+		CreatedAt:  c.CreatedAt,
+		LastUsedAt: c.LastUsedAt,
+	}
+}
+
+// Begin registering a passkey for the authenticated user. Returns
+// the credential creation options for navigator.credentials.create()
+// and a session token to finish the ceremony with.
+func (app *application) webauthnRegistrationBeginPost(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	wu, err := app.loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(wu.creds))
+	for i, cred := range wu.creds {
+		exclusions[i] = cred.Descriptor()
+	}
+
+	creation, session, err := app.webauthn.BeginRegistration(wu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return err
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	t, err := app.models.WebAuthnSession.New(r.Context(), &user.ID, sessionData)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"options": creation, "session_token": t}, nil)
+}
+
+// Finish registering a passkey with the authenticator's attestation
+// response.
+func (app *application) webauthnRegistrationFinishPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		SessionToken string          `json:"session_token"`
+		Name         string          `json:"name"`
+		Credential   json.RawMessage `json:"credential"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.SessionToken, validation.Required),
+		validation.Field(&input.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&input.Credential, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	user := app.contextGetUser(r)
+
+	s, err := app.models.WebAuthnSession.Take(r.Context(), input.SessionToken, &user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound,
+			data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, InvalidWebAuthnSessionMessage)
+		default:
+			return err
+		}
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(s.Data, &session)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(input.Credential)
+	if err != nil {
+		return app.writeError(w, r, http.StatusBadRequest, InvalidWebAuthnMessage)
+	}
+
+	wu, err := app.loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	cred, err := app.webauthn.CreateCredential(wu, session, parsed)
+	if err != nil {
+		return app.writeError(w, r, http.StatusBadRequest, InvalidWebAuthnMessage)
+	}
+
+	credData, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+
+	c := &data.WebAuthnCredential{
+		ID:     cred.ID,
+		UserID: user.ID,
+		Name:   input.Name,
+		Data:   credData,
+	}
+
+	err = app.models.WebAuthnCredential.Insert(r.Context(), c)
+	if err != nil {
+		switch err {
+		case data.ErrDuplicateCredential:
+			return app.writeError(w, r, http.StatusConflict, DuplicateCredentialMessage)
+		default:
+			return err
+		}
+	}
+
+	return app.writeJSON(w, http.StatusCreated, envelope{"webauthn_credential": newWebAuthnCredentialResponse(c)}, nil)
+}
+
+// Begin a passkey login. The user is identified by the discoverable
+// credential, so no email is required.
+func (app *application) webauthnLoginBeginPost(w http.ResponseWriter, r *http.Request) error {
+	assertion, session, err := app.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		return err
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	t, err := app.models.WebAuthnSession.New(r.Context(), nil, sessionData)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"options": assertion, "session_token": t}, nil)
+}
+
+// Finish a passkey login with the authenticator's assertion response.
+// Issues the same tokens as a password login.
+func (app *application) webauthnLoginFinishPost(w http.ResponseWriter, r *http.Request) error {
+	var input struct {
+		SessionToken string          `json:"session_token"`
+		DeviceName   string          `json:"device_name"`
+		Credential   json.RawMessage `json:"credential"`
+	}
+
+	err := app.readJSON(r, &input)
+	if err != nil {
+		return err
+	}
+
+	err = validation.ValidateStruct(&input,
+		validation.Field(&input.SessionToken, validation.Required),
+		validation.Field(&input.DeviceName, data.DeviceNameLength),
+		validation.Field(&input.Credential, validation.Required),
+	)
+	if err != nil {
+		return err
+	}
+
+	s, err := app.models.WebAuthnSession.Take(r.Context(), input.SessionToken, nil)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound,
+			data.ErrExpiredToken:
+			return app.writeError(w, r, http.StatusUnauthorized, InvalidWebAuthnSessionMessage)
+		default:
+			return err
+		}
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(s.Data, &session)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(input.Credential)
+	if err != nil {
+		return app.writeError(w, r, http.StatusBadRequest, InvalidWebAuthnMessage)
+	}
+
+	var wu *webauthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := app.models.User.Get(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+
+		wu, err = app.loadWebAuthnUser(r.Context(), user)
+		return wu, err
+	}
+
+	cred, err := app.webauthn.ValidateDiscoverableLogin(handler, session, parsed)
+	if err != nil || cred.Authenticator.CloneWarning {
+		return app.writeError(w, r, http.StatusUnauthorized, InvalidCredentailsMessage)
+	}
+
+	credData, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.WebAuthnCredential.UpdateForLogin(r.Context(), cred.ID, credData)
+	if err != nil {
+		return err
+	}
+
+	env, err := app.newLoginTokens(r.Context(), wu.user, app.requestDevice(r, input.DeviceName))
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, http.StatusCreated, env, nil)
+}
+
+// List the authenticated user's passkeys.
+func (app *application) usersMeWebAuthnGet(w http.ResponseWriter, r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	creds, err := app.models.WebAuthnCredential.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	res := make([]webauthnCredentialResponse, len(creds))
+	for i, c := range creds {
+		res[i] = newWebAuthnCredentialResponse(c)
+	}
+
+	return app.writeJSON(w, http.StatusOK, envelope{"webauthn_credentials": res}, nil)
+}
+
+// Remove one of the authenticated user's passkeys.
+func (app *application) usersMeWebAuthnDelete(w http.ResponseWriter, r *http.Request) error {
+	id, err := base64.RawURLEncoding.DecodeString(chi.URLParam(r, "id"))
+	if err != nil {
+		return app.writeError(w, r, http.StatusNotFound, nil)
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.WebAuthnCredential.DeleteForUser(r.Context(), id, user.ID)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			return app.writeError(w, r, http.StatusNotFound, nil)
+		default:
+			return err
+		}
+	}
+
+	msg := envelope{"message": app.translate(r, "passkey successfully removed")}
+
+	return app.writeJSON(w, http.StatusOK, msg, nil)
+}
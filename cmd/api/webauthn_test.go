@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/api/internal/data"
+)
+
+var b64url = base64.RawURLEncoding
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Software authenticator holding a single ES256 passkey, answering
+// ceremonies the way a browser and platform authenticator would.
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, id: id}
+}
+
+// Options of a ceremony begun by the server
+type ceremonyOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		User      struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+func decodeOptions(t *testing.T, env map[string]any) (ceremonyOptions, string) {
+	t.Helper()
+
+	js, err := json.Marshal(env["options"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts ceremonyOptions
+	err = json.Unmarshal(js, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, ok := env["session_token"].(map[string]any)
+	if !ok {
+		t.Fatalf("no session token in %v", env)
+	}
+
+	return opts, session["token"].(string)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, typ, challenge string) []byte {
+	t.Helper()
+
+	js, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return js
+}
+
+func (a *softAuthenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags)
+	return binary.BigEndian.AppendUint32(b, a.signCount)
+}
+
+// Credential for navigator.credentials.create()
+func (a *softAuthenticator) create(t *testing.T, opts ceremonyOptions) any {
+	t.Helper()
+
+	userHandle, err := b64url.DecodeString(opts.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = userHandle
+
+	pub, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := a.authData(flagUserPresent | flagUserVerified | flagAttestedData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, pub...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]any{
+		"id":    b64url.EncodeToString(a.id),
+		"rawId": b64url.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64url.EncodeToString(a.clientData(t, "webauthn.create", opts.PublicKey.Challenge)),
+			"attestationObject": b64url.EncodeToString(attestation),
+		},
+	}
+}
+
+// Assertion for navigator.credentials.get(), signed with the current
+// sign count
+func (a *softAuthenticator) get(t *testing.T, opts ceremonyOptions) any {
+	t.Helper()
+
+	clientData := a.clientData(t, "webauthn.get", opts.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	authData := a.authData(flagUserPresent | flagUserVerified)
+
+	digest := sha256.Sum256(append(authData[:len(authData):len(authData)], clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]any{
+		"id":    b64url.EncodeToString(a.id),
+		"rawId": b64url.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64url.EncodeToString(clientData),
+			"authenticatorData": b64url.EncodeToString(authData),
+			"signature":         b64url.EncodeToString(sig),
+			"userHandle":        b64url.EncodeToString(a.userHandle),
+		},
+	}
+}
+
+// Register the authenticator's passkey for the user with token.
+func registerPasskey(t *testing.T, ts *testServer, token string, a *softAuthenticator) {
+	t.Helper()
+
+	code, env := ts.do(t, http.MethodPost, "/v1/webauthn/registration/begin", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("begin registration: got status %d; want %d: %v", code, http.StatusOK, env)
+	}
+	opts, session := decodeOptions(t, env)
+
+	code, env = ts.do(t, http.MethodPost, "/v1/webauthn/registration/finish", token, map[string]any{
+		"session_token": session,
+		"name":          "laptop",
+		"credential":    a.create(t, opts),
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("finish registration: got status %d; want %d: %v", code, http.StatusCreated, env)
+	}
+}
+
+// Log in with the authenticator's passkey, returning the response.
+func loginPasskey(t *testing.T, ts *testServer, a *softAuthenticator) (int, map[string]any) {
+	t.Helper()
+
+	code, env := ts.do(t, http.MethodPost, "/v1/webauthn/login/begin", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("begin login: got status %d; want %d: %v", code, http.StatusOK, env)
+	}
+	opts, session := decodeOptions(t, env)
+
+	return ts.do(t, http.MethodPost, "/v1/webauthn/login/finish", "", map[string]any{
+		"session_token": session,
+		"credential":    a.get(t, opts),
+	})
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user, token := newTestUser(t, app, "alice@example.com")
+	a := newSoftAuthenticator(t)
+
+	registerPasskey(t, ts, token, a)
+
+	code, env := ts.do(t, http.MethodGet, "/v1/users/me/webauthn", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+	creds := env["webauthn_credentials"].([]any)
+	if len(creds) != 1 || creds[0].(map[string]any)["id"] != b64url.EncodeToString(a.id) {
+		t.Fatalf("got credentials %v; want the registered passkey", creds)
+	}
+
+	a.signCount = 1
+	code, env = loginPasskey(t, ts, a)
+	if code != http.StatusCreated {
+		t.Fatalf("login: got status %d; want %d: %v", code, http.StatusCreated, env)
+	}
+
+	at := env["authentication_token"].(map[string]any)["token"].(string)
+	code, env = ts.do(t, http.MethodGet, "/v1/users/me", at, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+	if email := env["user"].(map[string]any)["email"]; email != user.Email {
+		t.Errorf("logged in as %v; want %s", email, user.Email)
+	}
+
+	// Registering the same passkey again is rejected
+	code, env = ts.do(t, http.MethodPost, "/v1/webauthn/registration/begin", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+	opts, session := decodeOptions(t, env)
+	code, _ = ts.do(t, http.MethodPost, "/v1/webauthn/registration/finish", token, map[string]any{
+		"session_token": session,
+		"name":          "again",
+		"credential":    a.create(t, opts),
+	})
+	if code != http.StatusConflict {
+		t.Errorf("duplicate registration: got status %d; want %d", code, http.StatusConflict)
+	}
+}
+
+func TestWebAuthnSignCountRegression(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "bob@example.com")
+	a := newSoftAuthenticator(t)
+
+	registerPasskey(t, ts, token, a)
+
+	a.signCount = 5
+	code, env := loginPasskey(t, ts, a)
+	if code != http.StatusCreated {
+		t.Fatalf("login: got status %d; want %d: %v", code, http.StatusCreated, env)
+	}
+
+	// A cloned authenticator replays a count that was already used
+	for _, count := range []uint32{5, 3} {
+		a.signCount = count
+		code, _ = loginPasskey(t, ts, a)
+		if code != http.StatusUnauthorized {
+			t.Errorf("sign count %d: got status %d; want %d", count, code, http.StatusUnauthorized)
+		}
+	}
+
+	a.signCount = 6
+	code, env = loginPasskey(t, ts, a)
+	if code != http.StatusCreated {
+		t.Errorf("login: got status %d; want %d: %v", code, http.StatusCreated, env)
+	}
+}
+
+func TestWebAuthnSessionSingleUse(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, token := newTestUser(t, app, "carol@example.com")
+	a := newSoftAuthenticator(t)
+
+	registerPasskey(t, ts, token, a)
+
+	code, env := ts.do(t, http.MethodPost, "/v1/webauthn/login/begin", "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+	opts, session := decodeOptions(t, env)
+
+	a.signCount = 1
+	input := map[string]any{"session_token": session, "credential": a.get(t, opts)}
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/webauthn/login/finish", "", input)
+	if code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", code, http.StatusCreated)
+	}
+
+	a.signCount = 2
+	input["credential"] = a.get(t, opts)
+
+	code, _ = ts.do(t, http.MethodPost, "/v1/webauthn/login/finish", "", input)
+	if code != http.StatusUnauthorized {
+		t.Errorf("reused session: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestWebAuthnDelete(t *testing.T) {
+	app, _ := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	owner, ownerToken := newTestUser(t, app, "dave@example.com")
+	_, otherToken := newTestUser(t, app, "eve@example.com")
+	a := newSoftAuthenticator(t)
+
+	registerPasskey(t, ts, ownerToken, a)
+
+	path := "/v1/users/me/webauthn/" + b64url.EncodeToString(a.id)
+
+	// Another user's credential is not found
+	code, _ := ts.do(t, http.MethodDelete, path, otherToken, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("other user: got status %d; want %d", code, http.StatusNotFound)
+	}
+
+	// The owner must have logged in recently
+	familyID, err := uuid.NewV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := app.newAuthenticationTokens(context.Background(), owner, familyID, time.Now().Add(-time.Hour), data.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleToken := env["authentication_token"].(*data.Token).Plaintext
+
+	code, _ = ts.do(t, http.MethodDelete, path, staleToken, nil)
+	if code != http.StatusForbidden {
+		t.Errorf("stale login: got status %d; want %d", code, http.StatusForbidden)
+	}
+
+	a.signCount = 1
+	code, env = loginPasskey(t, ts, a)
+	if code != http.StatusCreated {
+		t.Fatalf("login after rejected deletes: got status %d; want %d: %v", code, http.StatusCreated, env)
+	}
+
+	code, _ = ts.do(t, http.MethodDelete, path, ownerToken, nil)
+	if code != http.StatusOK {
+		t.Fatalf("owner: got status %d; want %d", code, http.StatusOK)
+	}
+
+	a.signCount = 2
+	code, _ = loginPasskey(t, ts, a)
+	if code != http.StatusUnauthorized {
+		t.Errorf("login after delete: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+}
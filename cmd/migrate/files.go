@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/micahco/api/migrations"
+	"github.com/pressly/goose/v3"
+)
+
+// Template of a new migration, as goose create writes it
+const migrationTemplate = `-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
+`
+
+// Sections of a goose SQL migration, without the annotations
+type migrationFile struct {
+	up, down       string
+	hasUp, hasDown bool
+}
+
+func parseMigration(fsys fs.FS, name string) (*migrationFile, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f migrationFile
+	var up, down strings.Builder
+	var section *strings.Builder
+
+	for _, line := range strings.Split(string(b), "\n") {
+		annotation := strings.ToLower(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(annotation, "-- +goose up"):
+			f.hasUp = true
+			section = &up
+		case strings.HasPrefix(annotation, "-- +goose down"):
+			f.hasDown = true
+			section = &down
+		case strings.HasPrefix(annotation, "-- +goose"):
+			// StatementBegin, StatementEnd and NO TRANSACTION
+		case section != nil:
+			section.WriteString(line + "\n")
+		}
+	}
+
+	f.up = strings.TrimSpace(up.String())
+	f.down = strings.TrimSpace(down.String())
+
+	return &f, nil
+}
+
+// Check every embedded migration set. Versions must run from 1 without
+// gaps or duplicates, every file needs Up and Down sections with SQL,
+// and each set must have the same migrations as the PostgreSQL one.
+func validate() error {
+	var problems []string
+	problem := func(name, format string, a ...any) {
+		problems = append(problems, name+": "+fmt.Sprintf(format, a...))
+	}
+
+	sets := make(map[string]map[int64]string)
+
+	for _, dialect := range migrations.Dialects {
+		dir := path.Join("migrations", migrations.Dir(dialect))
+
+		fsys, err := migrations.FS(dialect)
+		if err != nil {
+			return err
+		}
+
+		names, err := fs.Glob(fsys, "*.sql")
+		if err != nil {
+			return err
+		}
+
+		versions := make(map[int64]string)
+		sets[dialect] = versions
+
+		for _, name := range names {
+			v, err := goose.NumericComponent(name)
+			if err != nil {
+				problem(path.Join(dir, name), "no version prefix")
+				continue
+			}
+
+			if other, ok := versions[v]; ok {
+				problem(path.Join(dir, name), "duplicate version %d, also used by %s", v, other)
+				continue
+			}
+			versions[v] = name
+
+			f, err := parseMigration(fsys, name)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case !f.hasUp:
+				problem(path.Join(dir, name), "missing Up section")
+			case f.up == "":
+				problem(path.Join(dir, name), "empty Up section")
+			}
+
+			switch {
+			case !f.hasDown:
+				problem(path.Join(dir, name), "missing Down section")
+			case f.down == "":
+				problem(path.Join(dir, name), "empty Down section")
+			}
+		}
+
+		if len(versions) > 0 {
+			latest := slices.Max(slices.Collect(maps.Keys(versions)))
+			for v := int64(1); v < latest; v++ {
+				if _, ok := versions[v]; !ok {
+					problem(dir, "gap at version %d", v)
+				}
+			}
+		}
+	}
+
+	want := sets[migrations.DialectPostgres]
+	for _, dialect := range migrations.Dialects[1:] {
+		dir := path.Join("migrations", migrations.Dir(dialect))
+		got := sets[dialect]
+
+		for _, v := range slices.Sorted(maps.Keys(want)) {
+			if got[v] != want[v] {
+				problem(dir, "no mirror of %s", want[v])
+			}
+		}
+
+		for _, v := range slices.Sorted(maps.Keys(got)) {
+			if _, ok := want[v]; !ok {
+				problem(path.Join(dir, got[v]), "no PostgreSQL migration with version %d", v)
+			}
+		}
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+
+	fmt.Printf("%d migrations in each of %d sets\n", len(want), len(sets))
+
+	return nil
+}
+
+var nonWord = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Create the next sequential migration in the source directory of
+// every migration set, so the sets keep mirroring each other. A dry run
+// prints the files instead of writing them.
+func create(dir, name string, dryRun bool) error {
+	name = strings.Trim(nonWord.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return errors.New("invalid migration name")
+	}
+
+	var next int64
+	for _, dialect := range migrations.Dialects {
+		paths, err := filepath.Glob(filepath.Join(dir, migrations.Dir(dialect), "*.sql"))
+		if err != nil {
+			return err
+		}
+
+		for _, p := range paths {
+			v, err := goose.NumericComponent(p)
+			if err == nil && v > next {
+				next = v
+			}
+		}
+	}
+
+	filename := fmt.Sprintf("%05d_%s.sql", next+1, name)
+
+	for _, dialect := range migrations.Dialects {
+		p := filepath.Join(dir, migrations.Dir(dialect), filename)
+
+		if dryRun {
+			fmt.Printf("-- %s\n%s\n", p, migrationTemplate)
+			continue
+		}
+
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.WriteString(migrationTemplate)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("created", p)
+	}
+
+	return nil
+}
@@ -2,63 +2,192 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"strconv"
 	"time"
 
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/micahco/api/internal/data"
 	"github.com/micahco/api/migrations"
-	"github.com/pressly/goose/v3"
 )
 
+const usage = `Usage: migrate [flags] <command> [args]
+
+Commands:
+  up               apply every pending migration
+  up-to VERSION    apply pending migrations up to and including VERSION
+  down             roll back the latest migration
+  down-to VERSION  roll back migrations down to, but not including, VERSION
+  redo             roll back the latest migration and apply it again
+  reset            roll back every migration
+  status           list the migrations and when they were applied
+  version          print the current database version
+  validate         check the embedded migrations for gaps and missing Down sections
+  create NAME      create the next migration in every migration set
+
+Flags:
+`
+
 type config struct {
-	dsn   string
-	up    bool
-	reset bool
+	dsn    string
+	dryRun bool
+	dir    string
+	up     bool
+	reset  bool
+}
+
+// Commands that only read or write migration files, without a database
+var fileCommands = map[string]bool{
+	"validate": true,
+	"create":   true,
+}
+
+var dbCommands = map[string]bool{
+	"up":      true,
+	"up-to":   true,
+	"down":    true,
+	"down-to": true,
+	"redo":    true,
+	"reset":   true,
+	"status":  true,
+	"version": true,
 }
 
 func main() {
 	var cfg config
 
-	flag.StringVar(&cfg.dsn, "dsn", os.Getenv("DATABASE_URL"), "PostgreSQL DSN")
-	flag.BoolVar(&cfg.up, "up", false, "apply all up database migrations")
-	flag.BoolVar(&cfg.reset, "reset", false, "reset the entire databse schema")
+	flag.StringVar(&cfg.dsn, "dsn", os.Getenv("DATABASE_URL"), "PostgreSQL DSN, or sqlite:path for a SQLite database")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "print the SQL a command would run, without running it")
+	flag.StringVar(&cfg.dir, "dir", "migrations", "source directory new migrations are created in")
+	flag.BoolVar(&cfg.up, "up", false, "same as the up command")
+	flag.BoolVar(&cfg.reset, "reset", false, "same as the reset command")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	pool, err := openPool(cfg.dsn)
+	args := flag.Args()
+	switch {
+	case cfg.up:
+		args = []string{"up"}
+	case cfg.reset:
+		args = []string{"reset"}
+	}
+
+	if len(args) == 0 || !fileCommands[args[0]] && !dbCommands[args[0]] {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(cfg, args[0], args[1:])
 	if err != nil {
-		log.Fatalf("pool: %s\n", err)
+		log.Fatalf("%s: %s\n", args[0], err)
 	}
-	defer pool.Close()
+}
+
+func run(cfg config, cmd string, args []string) error {
+	switch cmd {
+	case "validate":
+		return validate()
+	case "create":
+		if len(args) != 1 {
+			return errors.New("expected a migration name")
+		}
 
-	db := stdlib.OpenDBFromPool(pool)
-	defer db.Close()
+		return create(cfg.dir, args[0], cfg.dryRun)
+	}
 
-	goose.SetBaseFS(migrations.Files)
+	db, dialect, closeDB, err := openDB(cfg.dsn)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	defer closeDB()
 
-	if err := goose.SetDialect("postgres"); err != nil {
-		log.Fatalf("failed to set dialect: %s\n", err)
+	m, err := newMigrator(db, dialect, cfg.dryRun)
+	if err != nil {
+		return err
 	}
 
-	if cfg.up {
-		err = migrations.Up(db)
+	ctx := context.Background()
+
+	switch cmd {
+	case "up":
+		return m.up(ctx, math.MaxInt64)
+	case "up-to":
+		version, err := parseVersion(args)
 		if err != nil {
-			log.Fatalf("up: %s\n", err)
+			return err
 		}
-	} else if cfg.reset {
-		err = migrations.Reset(db)
+
+		return m.up(ctx, version)
+	case "down":
+		return m.down(ctx)
+	case "down-to":
+		version, err := parseVersion(args)
+		if err != nil {
+			return err
+		}
+
+		return m.downTo(ctx, version)
+	case "redo":
+		return m.redo(ctx)
+	case "reset":
+		return m.downTo(ctx, 0)
+	case "status":
+		return m.status(ctx)
+	default:
+		return m.version(ctx)
+	}
+}
+
+func parseVersion(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected a version")
+	}
+
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version %q", args[0])
+	}
+
+	return version, nil
+}
+
+// Open the database named by dsn, with the goose dialect of its
+// migrations. DSNs with the sqlite: scheme open a SQLite database,
+// anything else is a PostgreSQL DSN.
+func openDB(dsn string) (db *sql.DB, dialect string, closeDB func(), err error) {
+	if data.IsSQLiteDSN(dsn) {
+		db, err := data.OpenSQLite(dsn)
 		if err != nil {
-			log.Fatalf("reset: %s\n", err)
+			return nil, "", nil, err
 		}
-	} else {
-		fmt.Println("nothing happended")
+
+		return db, migrations.DialectSQLite, func() { db.Close() }, nil
+	}
+
+	pool, err := openPool(dsn)
+	if err != nil {
+		return nil, "", nil, err
 	}
+
+	db = stdlib.OpenDBFromPool(pool)
+	closeDB = func() {
+		db.Close()
+		pool.Close()
+	}
+
+	return db, migrations.DialectPostgres, closeDB, nil
 }
 
 func openPool(dsn string) (*pgxpool.Pool, error) {
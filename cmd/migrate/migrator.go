@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/micahco/api/migrations"
+	"github.com/pressly/goose/v3"
+)
+
+// Runs the embedded migrations of one dialect. In a dry run the SQL a
+// command would run is printed instead, and the database is only read.
+type migrator struct {
+	db       *sql.DB
+	dialect  string
+	provider *goose.Provider
+	dryRun   bool
+}
+
+func newMigrator(db *sql.DB, dialect string, dryRun bool) (*migrator, error) {
+	provider, err := migrations.NewProvider(db, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{db, dialect, provider, dryRun}, nil
+}
+
+// Apply pending migrations up to and including version.
+func (m *migrator) up(ctx context.Context, version int64) error {
+	if m.dryRun {
+		statuses, err := m.statuses(ctx)
+		if err != nil {
+			return err
+		}
+
+		var plan []*goose.Source
+		for _, s := range statuses {
+			if s.State == goose.StatePending && s.Source.Version <= version {
+				plan = append(plan, s.Source)
+			}
+		}
+
+		return printPlan(m.dialect, plan, true)
+	}
+
+	results, err := m.provider.UpTo(ctx, version)
+	printResults(results)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		fmt.Println("no migrations to apply")
+	}
+
+	return nil
+}
+
+// Roll back the latest applied migration.
+func (m *migrator) down(ctx context.Context) error {
+	if m.dryRun {
+		latest, err := m.latest(ctx)
+		if err != nil {
+			return err
+		}
+
+		return printPlan(m.dialect, latest, false)
+	}
+
+	result, err := m.provider.Down(ctx)
+	if errors.Is(err, goose.ErrNoNextVersion) {
+		return errors.New("no migrations to roll back")
+	}
+	if err != nil {
+		return err
+	}
+
+	printResults([]*goose.MigrationResult{result})
+
+	return nil
+}
+
+// Roll back applied migrations newer than version, latest first.
+func (m *migrator) downTo(ctx context.Context, version int64) error {
+	if m.dryRun {
+		statuses, err := m.statuses(ctx)
+		if err != nil {
+			return err
+		}
+
+		var plan []*goose.Source
+		for _, s := range slices.Backward(statuses) {
+			if s.State == goose.StateApplied && s.Source.Version > version {
+				plan = append(plan, s.Source)
+			}
+		}
+
+		return printPlan(m.dialect, plan, false)
+	}
+
+	results, err := m.provider.DownTo(ctx, version)
+	printResults(results)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		fmt.Println("no migrations to roll back")
+	}
+
+	return nil
+}
+
+// Roll back the latest applied migration and apply it again.
+func (m *migrator) redo(ctx context.Context) error {
+	if m.dryRun {
+		latest, err := m.latest(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = printPlan(m.dialect, latest, false)
+		if err != nil {
+			return err
+		}
+
+		return printPlan(m.dialect, latest, true)
+	}
+
+	result, err := m.provider.Down(ctx)
+	if errors.Is(err, goose.ErrNoNextVersion) {
+		return errors.New("no migrations to roll back")
+	}
+	if err != nil {
+		return err
+	}
+
+	printResults([]*goose.MigrationResult{result})
+
+	result, err = m.provider.ApplyVersion(ctx, result.Source.Version, true)
+	if err != nil {
+		return err
+	}
+
+	printResults([]*goose.MigrationResult{result})
+
+	return nil
+}
+
+func (m *migrator) status(ctx context.Context) error {
+	statuses, err := m.statuses(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-19s    %s\n", "Applied At", "Migration")
+	for _, s := range statuses {
+		appliedAt := "Pending"
+		if s.State == goose.StateApplied {
+			appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Printf("%-19s -- %s\n", appliedAt, s.Source.Path)
+	}
+
+	return nil
+}
+
+func (m *migrator) version(ctx context.Context) error {
+	exists, err := m.versionTableExists(ctx)
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	if exists {
+		version, err = m.provider.GetDBVersion(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(version)
+
+	return nil
+}
+
+// Status of every migration, in version order. The goose provider
+// creates its version table before reading it, so a database that was
+// never migrated is reported as all pending without touching it.
+func (m *migrator) statuses(ctx context.Context) ([]*goose.MigrationStatus, error) {
+	exists, err := m.versionTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return m.provider.Status(ctx)
+	}
+
+	var statuses []*goose.MigrationStatus
+	for _, source := range m.provider.ListSources() {
+		statuses = append(statuses, &goose.MigrationStatus{
+			Source: source,
+			State:  goose.StatePending,
+		})
+	}
+
+	return statuses, nil
+}
+
+// The latest applied migration, as a plan of at most one.
+func (m *migrator) latest(ctx context.Context) ([]*goose.Source, error) {
+	statuses, err := m.statuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range slices.Backward(statuses) {
+		if s.State == goose.StateApplied {
+			return []*goose.Source{s.Source}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *migrator) versionTableExists(ctx context.Context) (bool, error) {
+	query := `SELECT to_regclass($1) IS NOT NULL;`
+	if m.dialect == migrations.DialectSQLite {
+		query = `
+			SELECT EXISTS (
+				SELECT 1 FROM sqlite_master
+				WHERE type = 'table' AND name = $1
+			);`
+	}
+
+	var exists bool
+	err := m.db.QueryRowContext(ctx, query, goose.DefaultTablename).Scan(&exists)
+
+	return exists, err
+}
+
+func printResults(results []*goose.MigrationResult) {
+	for _, r := range results {
+		fmt.Println(r)
+	}
+}
+
+// Print the Up or Down SQL of each migration in plan, in order.
+func printPlan(dialect string, plan []*goose.Source, up bool) error {
+	if len(plan) == 0 {
+		fmt.Println("-- no migrations to run")
+		return nil
+	}
+
+	fsys, err := migrations.FS(dialect)
+	if err != nil {
+		return err
+	}
+
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+
+	for _, source := range plan {
+		f, err := parseMigration(fsys, source.Path)
+		if err != nil {
+			return err
+		}
+
+		stmts := f.down
+		if up {
+			stmts = f.up
+		}
+
+		fmt.Printf("-- %s (%s)\n%s\n\n", source.Path, direction, stmts)
+	}
+
+	return nil
+}
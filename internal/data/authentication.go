@@ -2,43 +2,124 @@ package data
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/micahco/api/internal/paseto"
 )
 
-// Default expiry duration
-const AuthenticationTokenTTL = time.Hour * 36
+// Default expiry duration. Access is kept short lived and extended
+// with refresh tokens.
+const AuthenticationTokenTTL = time.Minute * 15
+
+// Minimum interval between last used updates for a token
+const lastUsedInterval = time.Minute
 
 type AuthenticationTokenModel struct {
-	pool *pgxpool.Pool
+	db   dbtx
+	keys *paseto.KeyRing
 }
 
+// AuthenticatedAt is when the user last proved their identity with
+// a login or reauthentication, carried forward when refreshed.
 type AuthenticationToken struct {
-	UserID uuid.UUID
+	UserID          uuid.UUID
+	FamilyID        uuid.UUID
+	AuthenticatedAt time.Time
+	Device          Device
 	*Token
 }
 
+// Claims carried by a signed authentication token.
+type AuthenticationClaims struct {
+	UserID          uuid.UUID `json:"sub"`
+	FamilyID        uuid.UUID `json:"fid"`
+	Version         int       `json:"ver"`
+	AuthenticatedAt time.Time `json:"auth_time"`
+	IssuedAt        time.Time `json:"iat"`
+	Expiry          time.Time `json:"exp"`
+}
+
+// Reports whether the token was issued from a login or
+// reauthentication within maxAge.
+func (at *AuthenticationToken) Recent(maxAge time.Duration) bool {
+	if at.AuthenticatedAt.IsZero() {
+		return false
+	}
+
+	return time.Since(at.AuthenticatedAt) <= maxAge
+}
+
+// Authentication token described by verified claims
+func (c *AuthenticationClaims) AuthenticationToken(token string) *AuthenticationToken {
+	return &AuthenticationToken{
+		UserID:          c.UserID,
+		FamilyID:        c.FamilyID,
+		AuthenticatedAt: c.AuthenticatedAt,
+		Token: &Token{
+			Plaintext: token,
+			Expiry:    c.Expiry,
+		},
+	}
+}
+
+// Client metadata recorded when an authentication token is created.
+type Device struct {
+	IP        string
+	UserAgent string
+	Name      string
+}
+
+// Session is an authentication token as presented to its owner.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Expiry     time.Time `json:"expiry"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	DeviceName string    `json:"device_name"`
+	Current    bool      `json:"current"`
+}
+
 func (at AuthenticationToken) Validate() error {
 	return validation.ValidateStruct(&at,
 		validation.Field(&at.Hash, validation.Required),
 		validation.Field(&at.Expiry, validation.Required),
-		validation.Field(&at.UserID, validation.Required))
+		validation.Field(&at.UserID, validation.Required),
+		validation.Field(&at.FamilyID, validation.Required),
+		validation.Field(&at.AuthenticatedAt, validation.Required))
 }
 
-func (m AuthenticationTokenModel) New(userID uuid.UUID) (*Token, error) {
-	t, err := generateToken(AuthenticationTokenTTL)
+// Reports whether tokens are signed and self-contained. Signed tokens
+// are still recorded so sessions can be listed and refreshed, but are
+// only checked against their session once the user's version changed.
+// Deleting one ends its session, while the token itself stays valid
+// until it expires.
+func (m AuthenticationTokenModel) Signed() bool {
+	return m.keys != nil
+}
+
+func (m AuthenticationTokenModel) New(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error) {
+	var t *Token
+	var err error
+
+	if m.Signed() {
+		t, err = generateSignedToken(m.keys, user, familyID, authenticatedAt)
+	} else {
+		t, err = generateToken(AuthenticationTokenTTL)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	at := &AuthenticationToken{userID, t}
+	at := &AuthenticationToken{user.ID, familyID, authenticatedAt, device, t}
 
-	err = m.Insert(at)
+	err = m.Insert(ctx, at)
 	if err != nil {
 		return nil, err
 	}
@@ -46,39 +127,162 @@ func (m AuthenticationTokenModel) New(userID uuid.UUID) (*Token, error) {
 	return t, err
 }
 
-func (m AuthenticationTokenModel) Insert(t *AuthenticationToken) error {
+// Replace the authentication token of the session in the token family,
+// keeping the session's id, creation time, and device name unless a
+// new one is given. Returns ErrRecordNotFound if the session was
+// revoked.
+func (m AuthenticationTokenModel) Rotate(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error) {
+	var t *Token
+	var err error
+
+	if m.Signed() {
+		t, err = generateSignedToken(m.keys, user, familyID, authenticatedAt)
+	} else {
+		t, err = generateToken(AuthenticationTokenTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at := &AuthenticationToken{user.ID, familyID, authenticatedAt, device, t}
+
+	err = at.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `
+		UPDATE authentication_token_
+		SET hash_ = $1, expiry_ = $2, authenticated_at_ = $3, last_used_at_ = NOW(),
+		ip_ = $4, user_agent_ = $5, device_name_ = COALESCE(NULLIF($6, ''), device_name_)
+		WHERE family_id_ = $7
+		AND user_id_ = $8;`
+
+	args := []any{
+		at.Hash,
+		at.Expiry,
+		at.AuthenticatedAt,
+		at.Device.IP,
+		at.Device.UserAgent,
+		at.Device.Name,
+		at.FamilyID,
+		at.UserID,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.RowsAffected() == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return t, nil
+}
+
+func generateSignedToken(keys *paseto.KeyRing, user *User, familyID uuid.UUID, authenticatedAt time.Time) (*Token, error) {
+	now := time.Now()
+
+	claims := AuthenticationClaims{
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		Version:         user.Version,
+		AuthenticatedAt: authenticatedAt,
+		IssuedAt:        now,
+		Expiry:          now.Add(AuthenticationTokenTTL),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	str, err := keys.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Token{
+		Plaintext: str,
+		Hash:      generateHash(str),
+		Expiry:    claims.Expiry,
+	}
+
+	return t, nil
+}
+
+// Verify a signed token with the key ring and return its claims,
+// without a database lookup.
+func (m AuthenticationTokenModel) ParseSigned(token string) (*AuthenticationClaims, error) {
+	return parseSignedToken(m.keys, token)
+}
+
+func parseSignedToken(keys *paseto.KeyRing, token string) (*AuthenticationClaims, error) {
+	payload, err := keys.Verify(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims AuthenticationClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().After(claims.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func (m AuthenticationTokenModel) Insert(ctx context.Context, t *AuthenticationToken) error {
 	err := t.Validate()
 	if err != nil {
 		return err
 	}
 
 	sql := `
-		INSERT INTO authentication_token_ (hash_, expiry_, user_id_)
-		VALUES($1, $2, $3);`
+		INSERT INTO authentication_token_ (hash_, expiry_, user_id_, family_id_,
+		authenticated_at_, ip_, user_agent_, device_name_)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8);`
 
-	args := []any{t.Hash, t.Expiry, t.UserID}
+	args := []any{
+		t.Hash,
+		t.Expiry,
+		t.UserID,
+		t.FamilyID,
+		t.AuthenticatedAt,
+		t.Device.IP,
+		t.Device.UserAgent,
+		t.Device.Name,
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	_, err = m.pool.Exec(ctx, sql, args...)
+	_, err = m.db.Exec(ctx, sql, args...)
 	return err
 }
 
-func (m AuthenticationTokenModel) Exists(email string) (bool, error) {
+func (m AuthenticationTokenModel) Exists(ctx context.Context, userID uuid.UUID) (bool, error) {
 	var exists bool
 
 	sql := `
 		SELECT EXISTS (
 			SELECT 1
 			FROM authentication_token_
-			WHERE email_ = $1
+			WHERE user_id_ = $1
 		);`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, email).Scan(&exists)
+	err := m.db.QueryRow(ctx, sql, userID).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -86,34 +290,81 @@ func (m AuthenticationTokenModel) Exists(email string) (bool, error) {
 	return exists, nil
 }
 
-func (m AuthenticationTokenModel) Purge(email string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+// Delete the authentication token matching the plaintext token,
+// along with the refresh tokens in its family.
+func (m AuthenticationTokenModel) Delete(ctx context.Context, token string) error {
+	var count int
+
+	sql := `
+		WITH deleted AS (
+			DELETE FROM authentication_token_
+			WHERE hash_ = $1
+			RETURNING family_id_
+		), refresh AS (
+			DELETE FROM refresh_token_
+			WHERE family_id_ IN (SELECT family_id_ FROM deleted)
+		)
+		SELECT COUNT(*) FROM deleted;`
+
+	hash := generateHash(token)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
+	err := m.db.QueryRow(ctx, sql, hash).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete every authentication token in the family.
+func (m AuthenticationTokenModel) PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error {
 	sql := `
 		DELETE FROM authentication_token_
-		WHERE email_ = $1;`
+		WHERE family_id_ = $1;`
 
-	_, err := m.pool.Exec(ctx, sql, email)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, familyID)
 	return err
 }
 
-func (m AuthenticationTokenModel) Verify(email, token string) error {
+// Delete every authentication token belonging to the user.
+func (m AuthenticationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM authentication_token_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+func (m AuthenticationTokenModel) Verify(ctx context.Context, token string, userID uuid.UUID) error {
 	var expiry time.Time
 
 	sql := `
 		SELECT expiry_
 		FROM authentication_token_
 		WHERE hash_ = $1
-		AND email_ = $2;`
+		AND user_id_ = $2;`
 
 	hash := generateHash(token)
-	args := []any{hash, email}
+	args := []any{hash, userID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, args...).Scan(&expiry)
+	err := m.db.QueryRow(ctx, sql, args...).Scan(&expiry)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -129,3 +380,117 @@ func (m AuthenticationTokenModel) Verify(email, token string) error {
 
 	return nil
 }
+
+// Get all sessions for the user that are unexpired or can still be
+// refreshed. The session matching the plaintext token is marked as
+// current.
+func (m AuthenticationTokenModel) GetAllForUser(ctx context.Context, userID uuid.UUID, token string) ([]*Session, error) {
+	sql := `
+		SELECT id_, created_at_, last_used_at_, expiry_, ip_, user_agent_,
+		device_name_, hash_ = $2
+		FROM authentication_token_
+		WHERE user_id_ = $1
+		AND (
+			expiry_ > NOW()
+			OR EXISTS (
+				SELECT 1
+				FROM refresh_token_
+				WHERE refresh_token_.family_id_ = authentication_token_.family_id_
+				AND refresh_token_.used_ = FALSE
+				AND refresh_token_.expiry_ > NOW()
+			)
+		)
+		ORDER BY last_used_at_ DESC;`
+
+	hash := generateHash(token)
+	args := []any{userID, hash}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var s Session
+
+		err := rows.Scan(
+			&s.ID,
+			&s.CreatedAt,
+			&s.LastUsedAt,
+			&s.Expiry,
+			&s.IP,
+			&s.UserAgent,
+			&s.DeviceName,
+			&s.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete the session with id belonging to the user, along with the
+// refresh tokens in its family.
+func (m AuthenticationTokenModel) DeleteForUser(ctx context.Context, id, userID uuid.UUID) error {
+	var count int
+
+	sql := `
+		WITH deleted AS (
+			DELETE FROM authentication_token_
+			WHERE id_ = $1
+			AND user_id_ = $2
+			RETURNING family_id_
+		), refresh AS (
+			DELETE FROM refresh_token_
+			WHERE family_id_ IN (SELECT family_id_ FROM deleted)
+		)
+		SELECT COUNT(*) FROM deleted;`
+
+	args := []any{id, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, args...).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Record that the token was just used. Updates are skipped when the
+// token was already used within lastUsedInterval.
+func (m AuthenticationTokenModel) UpdateLastUsed(ctx context.Context, token string) error {
+	sql := `
+		UPDATE authentication_token_
+		SET last_used_at_ = NOW()
+		WHERE hash_ = $1
+		AND last_used_at_ < $2;`
+
+	hash := generateHash(token)
+	args := []any{hash, time.Now().Add(-lastUsedInterval)}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, args...)
+	return err
+}
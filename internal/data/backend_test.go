@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/micahco/api/migrations"
+)
+
+// Conformance tests run against every backend, since the repositories
+// must behave the same down to the errors they return. The Postgres
+// backend runs when TEST_DATABASE_URL names a scratch database, whose
+// schema is reset for every test.
+
+type backend struct {
+	name string
+	open func(t *testing.T) Models
+}
+
+var backends = []backend{
+	{"postgres", openPostgres},
+	{"sqlite", openSQLite},
+	{"memory", openMemory},
+}
+
+// Run fn as a subtest for every backend, with freshly migrated models.
+func forEachBackend(t *testing.T, fn func(t *testing.T, m Models)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			fn(t, b.open(t))
+		})
+	}
+}
+
+func openPostgres(t *testing.T) Models {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		pgxuuid.Register(conn.TypeMap())
+		return nil
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	db := stdlib.OpenDBFromPool(pool)
+	t.Cleanup(func() { db.Close() })
+
+	provider, err := migrations.NewProvider(db, migrations.DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.DownTo(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(pool, nil, DefaultVerificationPolicies)
+}
+
+func openSQLite(t *testing.T) Models {
+	t.Helper()
+
+	db, err := OpenSQLite(SQLiteScheme + filepath.Join(t.TempDir(), "api.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider, err := migrations.NewProvider(db, migrations.DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLite(db, nil, DefaultVerificationPolicies)
+}
+
+func openMemory(t *testing.T) Models {
+	return NewMemory(nil, DefaultVerificationPolicies)
+}
+
+func newUser(t *testing.T, m Models, email string) *User {
+	t.Helper()
+
+	user, err := m.User.New(context.Background(), email, "pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// Maximum number of users held before the cache is cleared
+	userCacheSize = 10000
+	// How long a user is served from the cache
+	UserCacheTTL = time.Minute
+)
+
+// In-process cache of users, used to authenticate signed tokens
+// without a database lookup. The cache is not shared between
+// processes, so a version bumped through another process is only seen
+// once the cached user expires, at most UserCacheTTL later.
+type userCache struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]cachedUser
+}
+
+type cachedUser struct {
+	user   User
+	expiry time.Time
+}
+
+func newUserCache() *userCache {
+	return &userCache{
+		users: map[uuid.UUID]cachedUser{},
+	}
+}
+
+// Get a copy of the cached user, so callers may modify it freely.
+func (c *userCache) get(id uuid.UUID) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cu, ok := c.users[id]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(cu.expiry) {
+		delete(c.users, id)
+		return nil, false
+	}
+
+	u := cu.user
+
+	return &u, true
+}
+
+func (c *userCache) set(user *User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.users) >= userCacheSize {
+		clear(c.users)
+	}
+
+	c.users[user.ID] = cachedUser{
+		user:   *user,
+		expiry: time.Now().Add(UserCacheTTL),
+	}
+}
+
+func (c *userCache) delete(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.users, id)
+}
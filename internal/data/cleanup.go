@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Rows deleted per statement, keeping locks and WAL bursts small
+	CleanupBatchSize = 1000
+
+	// Advisory lock held by the replica running a cleanup
+	cleanupLockKey = 0x6170695f6763 // "api_gc"
+)
+
+type CleanupModel struct {
+	pool *pgxpool.Pool
+}
+
+// Tables with expiring rows, the key rows are deleted by, and when a
+// row may be deleted. $2 in a condition is the time retention ago,
+// which is the current time for rows that expire.
+var cleanupTables = []struct {
+	name      string
+	key       string
+	retention time.Duration
+	condition string
+}{
+	{"verification_token_", "hash_", 0, "expiry_ < $2"},
+	// Failures only guard outstanding tokens, and a new token starts
+	// over with none
+	{"verification_attempt_", "scope_, email_", VerificationTokenTTL, `last_failure_at_ < $2
+		AND NOT EXISTS (
+			SELECT 1
+			FROM verification_token_
+			WHERE verification_token_.scope_ = verification_attempt_.scope_
+			AND verification_token_.email_ = verification_attempt_.email_
+		)`},
+	// Expired authentication tokens still describe a session while
+	// their family has a live refresh token
+	{"authentication_token_", "hash_", 0, `expiry_ < $2
+		AND NOT EXISTS (
+			SELECT 1
+			FROM refresh_token_
+			WHERE refresh_token_.family_id_ = authentication_token_.family_id_
+			AND refresh_token_.used_ = FALSE
+			AND refresh_token_.expiry_ > $2
+		)`},
+	{"refresh_token_", "hash_", 0, "expiry_ < $2"},
+	{"personal_access_token_", "hash_", 0, "expiry_ < $2"},
+	{"mfa_challenge_", "hash_", 0, "expiry_ < $2"},
+	{"webauthn_session_", "hash_", 0, "expiry_ < $2"},
+	{"login_attempt_", "scope_, email_", LoginAttemptWindow, "last_failure_at_ < $2"},
+	// Dead jobs are due again after the backoff of their last attempt
+	{"job_", "id_", JobDeadRetention, "status_ = 'dead' AND run_at_ < $2"},
+}
+
+// Delete expired rows in batches. Returns the number of rows deleted
+// by table, or ok false if another process holds the cleanup lock.
+func (m CleanupModel) DeleteExpired(ctx context.Context) (deleted map[string]int64, ok bool, err error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Release()
+
+	sql := `
+		SELECT pg_try_advisory_lock($1);`
+
+	err = conn.QueryRow(ctx, sql, cleanupLockKey).Scan(&ok)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	defer func() {
+		// Unlock even if ctx was cancelled mid cleanup
+		unlockCtx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer cancel()
+
+		_, unlockErr := conn.Exec(unlockCtx, `SELECT pg_advisory_unlock($1);`, cleanupLockKey)
+		if unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}()
+
+	deleted = make(map[string]int64, len(cleanupTables))
+
+	for _, t := range cleanupTables {
+		sql := fmt.Sprintf(`
+			DELETE FROM %[1]s
+			WHERE (%[2]s) IN (
+				SELECT %[2]s
+				FROM %[1]s
+				WHERE %[3]s
+				LIMIT $1
+			);`, t.name, t.key, t.condition)
+
+		for {
+			batchCtx, cancel := context.WithTimeout(ctx, ctxTimeout)
+			result, err := conn.Exec(batchCtx, sql, CleanupBatchSize, time.Now().Add(-t.retention))
+			cancel()
+			if err != nil {
+				return deleted, true, err
+			}
+
+			deleted[t.name] += result.RowsAffected()
+
+			if result.RowsAffected() < CleanupBatchSize {
+				break
+			}
+		}
+	}
+
+	return deleted, true, nil
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCleanupDeleteExpired(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		expired, err := generateToken(-time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.VerificationToken.Insert(ctx, &VerificationToken{
+			Scope: ScopeRegistration,
+			Email: "bob@example.com",
+			Token: expired,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		live, err := m.VerificationToken.New(ctx, ScopeRegistration, "carol@example.com", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = m.PersonalAccessToken.New(ctx, alice.ID, "old", []string{TokenScopeUserRead}, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deleted, ok, err := m.Cleanup.DeleteExpired(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("cleanup lock not acquired")
+		}
+
+		for table, want := range map[string]int64{
+			"verification_token_":    1,
+			"personal_access_token_": 1,
+			"refresh_token_":         0,
+		} {
+			if deleted[table] != want {
+				t.Errorf("%s: got %d deleted; want %d", table, deleted[table], want)
+			}
+		}
+
+		err = m.VerificationToken.Verify(ctx, expired.Plaintext, ScopeRegistration, "bob@example.com", nil)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expired: got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		err = m.VerificationToken.Verify(ctx, live.Plaintext, ScopeRegistration, "carol@example.com", nil)
+		if err != nil {
+			t.Errorf("live: %v", err)
+		}
+	})
+}
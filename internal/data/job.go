@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	JobKindMail = "mail"
+
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDead    = "dead"
+)
+
+const (
+	// Attempts before a job is moved to the dead letter state
+	JobMaxAttempts = 8
+	// Delay before the first retry, doubled for every later one
+	JobBaseBackoff = 30 * time.Second
+	JobMaxBackoff  = time.Hour
+	// How long a claimed job is reserved for its worker. Jobs still
+	// running after this are assumed lost and claimed again.
+	JobLease = 5 * time.Minute
+	// How long dead jobs are kept for inspection before cleanup
+	JobDeadRetention = 7 * 24 * time.Hour
+)
+
+// Error recorded for a job whose lease expired on its last attempt
+const JobLostMessage = "lease expired on the last attempt"
+
+type JobModel struct {
+	db dbtx
+}
+
+type Job struct {
+	ID          uuid.UUID
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+func (j Job) Validate() error {
+	return validation.ValidateStruct(&j,
+		validation.Field(&j.Kind, validation.Required),
+		validation.Field(&j.Payload, validation.Required),
+		validation.Field(&j.MaxAttempts, validation.Required))
+}
+
+// Mail to send once the token it carries is stored. Mailed tokens
+// are added to Data as "token" when the job is enqueued, and removed
+// from jobs that are moved to the dead letter state.
+type Mail struct {
+	Recipient string         `json:"recipient"`
+	Locale    string         `json:"locale"`
+	Template  string         `json:"template"`
+	Data      map[string]any `json:"data"`
+}
+
+func newMailJob(mail *Mail, t *Token) (*Job, error) {
+	data := map[string]any{}
+	for k, v := range mail.Data {
+		data[k] = v
+	}
+	data["token"] = t.Plaintext
+
+	payload, err := json.Marshal(Mail{mail.Recipient, mail.Locale, mail.Template, data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{
+		Kind:        JobKindMail,
+		Payload:     payload,
+		MaxAttempts: JobMaxAttempts,
+	}, nil
+}
+
+// Insert job, in the transaction of the change it follows from when
+// db is a pgx.Tx.
+func enqueueJob(ctx context.Context, db execer, job *Job) error {
+	err := job.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO job_ (kind_, payload_, max_attempts_)
+		VALUES($1, $2, $3);`
+
+	args := []any{job.Kind, job.Payload, job.MaxAttempts}
+
+	_, err = db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Claim the next job that is due, reserving it for JobLease. Jobs
+// claimed by other workers are skipped, and lost jobs without attempts
+// left are moved to the dead letter state. Returns ErrRecordNotFound
+// when no job is due.
+func (m JobModel) Claim(ctx context.Context) (*Job, error) {
+	var j Job
+
+	sql := `
+		UPDATE job_
+		SET status_ = $1, locked_until_ = NULL, last_error_ = $2,
+		payload_ = payload_ #- '{data,token}'
+		WHERE status_ = $3
+		AND locked_until_ < NOW()
+		AND attempts_ >= max_attempts_;`
+
+	args := []any{JobStatusDead, JobLostMessage, JobStatusRunning}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `
+		UPDATE job_
+		SET status_ = $1, attempts_ = attempts_ + 1, locked_until_ = $2
+		WHERE id_ = (
+			SELECT id_
+			FROM job_
+			WHERE (status_ = $3 AND run_at_ <= NOW())
+			OR (status_ = $1 AND locked_until_ < NOW() AND attempts_ < max_attempts_)
+			ORDER BY run_at_
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id_, kind_, payload_, attempts_, max_attempts_;`
+
+	args = []any{JobStatusRunning, time.Now().Add(JobLease), JobStatusPending}
+
+	err = m.db.QueryRow(ctx, sql, args...).Scan(
+		&j.ID,
+		&j.Kind,
+		&j.Payload,
+		&j.Attempts,
+		&j.MaxAttempts,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &j, nil
+}
+
+// Remove a job that ran successfully.
+func (m JobModel) Complete(ctx context.Context, job *Job) error {
+	sql := `
+		DELETE FROM job_
+		WHERE id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, job.ID)
+	return err
+}
+
+// Record a failed run. The job is retried with exponential backoff,
+// or marked dead once it has used up its attempts.
+func (m JobModel) Fail(ctx context.Context, job *Job, jobErr error) error {
+	status := JobStatusPending
+	if job.Attempts >= job.MaxAttempts {
+		status = JobStatusDead
+	}
+
+	sql := `
+		UPDATE job_
+		SET status_ = $1, run_at_ = $2, locked_until_ = NULL, last_error_ = $3,
+		payload_ = CASE WHEN $1 = $5 THEN payload_ #- '{data,token}' ELSE payload_ END
+		WHERE id_ = $4;`
+
+	args := []any{status, time.Now().Add(JobBackoff(job.Attempts)), jobErr.Error(), job.ID, JobStatusDead}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Delay before retrying a job that failed its nth attempt.
+func JobBackoff(attempts int) time.Duration {
+	backoff := float64(JobBaseBackoff) * math.Pow(2, float64(attempts-1))
+	if backoff > float64(JobMaxBackoff) {
+		return JobMaxBackoff
+	}
+
+	return time.Duration(backoff)
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var errDelivery = errors.New("delivery failed")
+
+func TestJobMail(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		token, err := m.VerificationToken.New(ctx, ScopeRegistration, "alice@example.com", nil, &Mail{
+			Recipient: "alice@example.com",
+			Locale:    "fr",
+			Template:  "registration.tmpl",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		job, err := m.Job.Claim(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Kind != JobKindMail || job.Attempts != 1 || job.MaxAttempts != JobMaxAttempts {
+			t.Errorf("got %s job at attempt %d of %d", job.Kind, job.Attempts, job.MaxAttempts)
+		}
+
+		var mail Mail
+		err = json.Unmarshal(job.Payload, &mail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mail.Recipient != "alice@example.com" || mail.Locale != "fr" || mail.Data["token"] != token.Plaintext {
+			t.Errorf("got mail %+v", mail)
+		}
+
+		// A claimed job is not claimed again during its lease
+		_, err = m.Job.Claim(ctx)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("claimed twice: got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		err = m.Job.Complete(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = m.Job.Claim(ctx)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("completed: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
+
+func TestJobFail(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		_, err := m.VerificationToken.New(ctx, ScopeRegistration, "alice@example.com", nil, &Mail{
+			Recipient: "alice@example.com",
+			Template:  "registration.tmpl",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		job, err := m.Job.Claim(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A failed job waits for its backoff before it is due again
+		err = m.Job.Fail(ctx, job, errDelivery)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = m.Job.Claim(ctx)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("during backoff: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// Failed logins allowed before delays are enforced
+	LoginFreeAttempts = 3
+	// Failed logins after which the email is locked
+	LoginLockoutThreshold = 10
+	// How long an email stays locked
+	LoginLockoutDuration = time.Minute * 15
+	// Longest delay enforced between failed logins
+	LoginMaxDelay = time.Minute * 5
+	// Failures older than this are forgotten
+	LoginAttemptWindow = time.Hour * 24
+)
+
+// Scopes failed logins are counted in. Checking the password or code
+// of a logged in user again is counted apart from logging in, so a
+// stolen token cannot be used to lock the owner out of logging in.
+const (
+	LoginScopeLogin  = "login"
+	LoginScopeStepUp = "step-up"
+)
+
+type LoginAttemptModel struct {
+	db dbtx
+}
+
+// Failed login tracking for an email address. Attempts are tracked
+// whether or not a user with the email exists, so throttled and
+// locked responses do not reveal which accounts exist.
+type LoginAttempt struct {
+	Scope         string
+	Email         string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+	// Set by Reserve when the reserved attempt locked the email
+	NewlyLocked bool
+}
+
+// Time until another login may be attempted, or zero if one is
+// allowed now. Each failure past LoginFreeAttempts doubles the delay.
+func (a *LoginAttempt) RetryAfter(now time.Time) time.Duration {
+	if a.LockedUntil != nil && now.Before(*a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+
+	if a.Failures < LoginFreeAttempts || a.Failures >= LoginLockoutThreshold {
+		return 0
+	}
+
+	delay := min(time.Second<<(a.Failures-LoginFreeAttempts), LoginMaxDelay)
+
+	return max(a.LastFailureAt.Add(delay).Sub(now), 0)
+}
+
+// Reports whether the email is currently locked.
+func (a *LoginAttempt) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && now.Before(*a.LockedUntil)
+}
+
+// Reserve a login attempt in scope for the email before checking
+// credentials. The attempt counts as a failure until Reset is called
+// after a successful login, so concurrent logins cannot get past the
+// delays and lockout. If the email is throttled nothing is reserved,
+// and the time until another login may be attempted is returned.
+func (m LoginAttemptModel) Reserve(ctx context.Context, scope, email string) (*LoginAttempt, time.Duration, error) {
+	a := LoginAttempt{Scope: scope, Email: email}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize reservations for the email, which may have no row yet
+	sql := `
+		SELECT pg_advisory_xact_lock(hashtext('login_attempt_:' || $1 || ':' || lower($2)));`
+
+	_, err = tx.Exec(ctx, sql, scope, email)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sql = `
+		SELECT failures_, last_failure_at_, locked_until_
+		FROM login_attempt_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	err = tx.QueryRow(ctx, sql, scope, email).Scan(&a.Failures, &a.LastFailureAt, &a.LockedUntil)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, err
+	}
+
+	if wait := a.reserve(time.Now()); wait > 0 {
+		return &a, wait, nil
+	}
+
+	err = LoginAttemptModel{tx}.Insert(ctx, &a)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &a, 0, nil
+}
+
+// Record the attempt, replacing failed logins in its scope for its
+// email.
+func (m LoginAttemptModel) Insert(ctx context.Context, a *LoginAttempt) error {
+	sql := `
+		INSERT INTO login_attempt_ (scope_, email_, failures_, last_failure_at_, locked_until_)
+		VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT (scope_, email_) DO UPDATE
+		SET failures_ = EXCLUDED.failures_,
+		last_failure_at_ = EXCLUDED.last_failure_at_,
+		locked_until_ = EXCLUDED.locked_until_;`
+
+	args := []any{a.Scope, a.Email, a.Failures, a.LastFailureAt, a.LockedUntil}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Clear failed logins in scope for the email, unlocking it.
+func (m LoginAttemptModel) Reset(ctx context.Context, scope, email string) error {
+	sql := `
+		DELETE FROM login_attempt_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, scope, email)
+	return err
+}
+
+// Count an attempt at now as a failure, locking the email once
+// LoginLockoutThreshold is reached. Returns the time to wait instead,
+// leaving the attempt unchanged, if the email is throttled.
+func (a *LoginAttempt) reserve(now time.Time) time.Duration {
+	a.forgetExpired(now)
+	a.NewlyLocked = false
+
+	if wait := a.RetryAfter(now); wait > 0 {
+		return wait
+	}
+
+	a.Failures++
+	a.LastFailureAt = now
+	if a.Failures >= LoginLockoutThreshold {
+		lockedUntil := now.Add(LoginLockoutDuration)
+		a.LockedUntil = &lockedUntil
+		a.NewlyLocked = true
+	}
+
+	return 0
+}
+
+// Start over once a lock has passed or failures fall out of the window.
+func (a *LoginAttempt) forgetExpired(now time.Time) {
+	expiredLock := a.LockedUntil != nil && !now.Before(*a.LockedUntil)
+	stale := now.Sub(a.LastFailureAt) > LoginAttemptWindow
+
+	if expiredLock || stale {
+		a.Failures = 0
+		a.LockedUntil = nil
+	}
+}
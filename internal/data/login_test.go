@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptReserve(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		for i := 1; i <= LoginFreeAttempts; i++ {
+			a, wait, err := m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != 0 {
+				t.Fatalf("attempt %d: throttled for %s", i, wait)
+			}
+			if a.Failures != i {
+				t.Fatalf("attempt %d: got %d failures", i, a.Failures)
+			}
+		}
+
+		// The next attempt must wait for the delay
+		a, wait, err := m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "ALICE@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait == 0 {
+			t.Error("attempt past the free attempts was not throttled")
+		}
+		if a.Failures != LoginFreeAttempts {
+			t.Errorf("throttled attempt counted: got %d failures; want %d", a.Failures, LoginFreeAttempts)
+		}
+
+		// Other emails are tracked apart
+		_, wait, err = m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "bob@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Errorf("other email throttled for %s", wait)
+		}
+
+		err = m.LoginAttempt.Reset(ctx, LoginScopeLogin, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, wait, err = m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 || a.Failures != 1 {
+			t.Errorf("after reset: got %d failures and wait %s; want 1 and none", a.Failures, wait)
+		}
+	})
+}
+
+// Record failures for the email in scope so that the next reservation
+// locks it.
+func insertNearLockout(t *testing.T, m Models, scope, email string) {
+	t.Helper()
+
+	err := m.LoginAttempt.Insert(context.Background(), &LoginAttempt{
+		Scope:         scope,
+		Email:         email,
+		Failures:      LoginLockoutThreshold - 1,
+		LastFailureAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginAttemptLockout(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		insertNearLockout(t, m, LoginScopeLogin, "alice@example.com")
+
+		a, wait, err := m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "ALICE@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 || !a.NewlyLocked || !a.Locked(time.Now()) {
+			t.Fatalf("attempt reaching the threshold: got wait %s, newly locked %t", wait, a.NewlyLocked)
+		}
+
+		// Later attempts wait out the lock without locking again
+		a, wait, err = m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait <= LoginMaxDelay || a.NewlyLocked {
+			t.Errorf("locked attempt: got wait %s, newly locked %t", wait, a.NewlyLocked)
+		}
+
+		err = m.LoginAttempt.Reset(ctx, LoginScopeLogin, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, wait, err = m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 || a.NewlyLocked || a.Failures != 1 {
+			t.Errorf("after reset: got %d failures and wait %s", a.Failures, wait)
+		}
+	})
+}
+
+func TestLoginAttemptScopes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		insertNearLockout(t, m, LoginScopeStepUp, "alice@example.com")
+
+		a, _, err := m.LoginAttempt.Reserve(ctx, LoginScopeStepUp, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.NewlyLocked {
+			t.Fatal("step-up checks not locked")
+		}
+
+		a, wait, err := m.LoginAttempt.Reserve(ctx, LoginScopeLogin, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 || a.Failures != 1 || a.Locked(time.Now()) {
+			t.Errorf("login after step-up lockout: got %d failures and wait %s", a.Failures, wait)
+		}
+
+		err = m.LoginAttempt.Reset(ctx, LoginScopeLogin, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Resetting logins leaves step-up checks locked
+		a, wait, err = m.LoginAttempt.Reserve(ctx, LoginScopeStepUp, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait == 0 || !a.Locked(time.Now()) {
+			t.Error("step-up checks not locked")
+		}
+	})
+}
@@ -0,0 +1,1014 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/api/internal/paseto"
+)
+
+// In-memory models keep every table in a map guarded by a single lock.
+// They follow the Postgres models, including their errors, so handlers
+// can run without a database. Transactions hold the lock until they
+// end, and are rolled back by restoring a snapshot of the tables.
+
+type memoryTables struct {
+	users                map[uuid.UUID]User
+	verificationTokens   map[string]memoryVerificationToken
+	verificationAttempts map[memoryAttemptKey]memoryVerificationAttempt
+	authenticationTokens map[string]memoryAuthenticationToken
+	refreshTokens        map[string]memoryRefreshToken
+	personalAccessTokens map[string]memoryPersonalAccessToken
+	totps                map[uuid.UUID]TOTP
+	recoveryCodes        map[string]uuid.UUID
+	mfaChallenges        map[string]memoryMFAChallenge
+	webAuthnCredentials  map[string]WebAuthnCredential
+	webAuthnSessions     map[string]memoryWebAuthnSession
+	loginAttempts        map[memoryAttemptKey]LoginAttempt
+	jobs                 map[uuid.UUID]memoryJob
+}
+
+func newMemoryTables() memoryTables {
+	return memoryTables{
+		users:                map[uuid.UUID]User{},
+		verificationTokens:   map[string]memoryVerificationToken{},
+		verificationAttempts: map[memoryAttemptKey]memoryVerificationAttempt{},
+		authenticationTokens: map[string]memoryAuthenticationToken{},
+		refreshTokens:        map[string]memoryRefreshToken{},
+		personalAccessTokens: map[string]memoryPersonalAccessToken{},
+		totps:                map[uuid.UUID]TOTP{},
+		recoveryCodes:        map[string]uuid.UUID{},
+		mfaChallenges:        map[string]memoryMFAChallenge{},
+		webAuthnCredentials:  map[string]WebAuthnCredential{},
+		webAuthnSessions:     map[string]memoryWebAuthnSession{},
+		loginAttempts:        map[memoryAttemptKey]LoginAttempt{},
+		jobs:                 map[uuid.UUID]memoryJob{},
+	}
+}
+
+// Copy of the tables. Rows are stored by value and never modified in
+// place, so copying the maps is enough.
+func (t *memoryTables) clone() memoryTables {
+	return memoryTables{
+		users:                maps.Clone(t.users),
+		verificationTokens:   maps.Clone(t.verificationTokens),
+		verificationAttempts: maps.Clone(t.verificationAttempts),
+		authenticationTokens: maps.Clone(t.authenticationTokens),
+		refreshTokens:        maps.Clone(t.refreshTokens),
+		personalAccessTokens: maps.Clone(t.personalAccessTokens),
+		totps:                maps.Clone(t.totps),
+		recoveryCodes:        maps.Clone(t.recoveryCodes),
+		mfaChallenges:        maps.Clone(t.mfaChallenges),
+		webAuthnCredentials:  maps.Clone(t.webAuthnCredentials),
+		webAuthnSessions:     maps.Clone(t.webAuthnSessions),
+		loginAttempts:        maps.Clone(t.loginAttempts),
+		jobs:                 maps.Clone(t.jobs),
+	}
+}
+
+type memoryStore struct {
+	mu     sync.Mutex
+	tables memoryTables
+}
+
+// Handle on the store held by memory models. Models of a transaction
+// run while it holds the lock, so they do not take it again.
+type memoryDB struct {
+	store *memoryStore
+	inTx  bool
+}
+
+func (db memoryDB) lock() *memoryTables {
+	if !db.inTx {
+		db.store.mu.Lock()
+	}
+
+	return &db.store.tables
+}
+
+func (db memoryDB) unlock() {
+	if !db.inTx {
+		db.store.mu.Unlock()
+	}
+}
+
+// DSN selecting the in-memory backend. Nothing is kept across restarts.
+const MemoryDSN = "memory:"
+
+// Dependencies of the memory models, shared by their transactions.
+type memory struct {
+	store    *memoryStore
+	keys     *paseto.KeyRing
+	policies VerificationPolicies
+}
+
+// Create models that keep everything in memory, for tests and for
+// running without a database. keys and policies are used as in New.
+func NewMemory(keys *paseto.KeyRing, policies VerificationPolicies) Models {
+	m := &memory{
+		store:    &memoryStore{tables: newMemoryTables()},
+		keys:     keys,
+		policies: policies,
+	}
+
+	return m.models(memoryDB{m.store, false})
+}
+
+func (m *memory) models(db memoryDB) Models {
+	return Models{
+		User:                memoryUserModel{db, m.policies},
+		VerificationToken:   memoryVerificationTokenModel{db, m.policies},
+		AuthenticationToken: memoryAuthenticationTokenModel{db, m.keys},
+		RefreshToken:        memoryRefreshTokenModel{db},
+		PersonalAccessToken: memoryPersonalAccessTokenModel{db},
+		TOTP:                memoryTOTPModel{db},
+		RecoveryCode:        memoryRecoveryCodeModel{db},
+		MFAChallenge:        memoryMFAChallengeModel{db},
+		WebAuthnCredential:  memoryWebAuthnCredentialModel{db},
+		WebAuthnSession:     memoryWebAuthnSessionModel{db},
+		LoginAttempt:        memoryLoginAttemptModel{db},
+		Cleanup:             memoryCleanupModel{db},
+		Job:                 memoryJobModel{db},
+		withTx: func(ctx context.Context, fn func(tx Models) error) error {
+			return m.withTx(db, fn)
+		},
+	}
+}
+
+// Run fn holding the lock, restoring the tables unless it succeeds.
+// A nested transaction restores only its own changes, like a savepoint.
+func (m *memory) withTx(db memoryDB, fn func(tx Models) error) error {
+	t := db.lock()
+	defer db.unlock()
+
+	snapshot := t.clone()
+	committed := false
+
+	defer func() {
+		if !committed {
+			*t = snapshot
+		}
+	}()
+
+	err := fn(m.models(memoryDB{db.store, true}))
+	if err != nil {
+		return err
+	}
+
+	committed = true
+
+	return nil
+}
+
+// Emails are case insensitive, as with citext
+func foldEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+func copyUUID(id *uuid.UUID) *uuid.UUID {
+	if id == nil {
+		return nil
+	}
+
+	c := *id
+
+	return &c
+}
+
+// Reports whether a and b are equal, treating nil as a value like
+// IS NOT DISTINCT FROM.
+func equalUUID(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// Delete rows referencing the user, as ON DELETE CASCADE does.
+func (t *memoryTables) cascadeUser(id uuid.UUID) {
+	for k, vt := range t.verificationTokens {
+		if vt.userID != nil && *vt.userID == id {
+			delete(t.verificationTokens, k)
+		}
+	}
+	for k, at := range t.authenticationTokens {
+		if at.userID == id {
+			delete(t.authenticationTokens, k)
+		}
+	}
+	for k, rt := range t.refreshTokens {
+		if rt.userID == id {
+			delete(t.refreshTokens, k)
+		}
+	}
+	for k, pat := range t.personalAccessTokens {
+		if pat.userID == id {
+			delete(t.personalAccessTokens, k)
+		}
+	}
+	for k, userID := range t.recoveryCodes {
+		if userID == id {
+			delete(t.recoveryCodes, k)
+		}
+	}
+	for k, c := range t.mfaChallenges {
+		if c.userID == id {
+			delete(t.mfaChallenges, k)
+		}
+	}
+	for k, c := range t.webAuthnCredentials {
+		if c.UserID == id {
+			delete(t.webAuthnCredentials, k)
+		}
+	}
+	for k, s := range t.webAuthnSessions {
+		if s.userID != nil && *s.userID == id {
+			delete(t.webAuthnSessions, k)
+		}
+	}
+
+	delete(t.totps, id)
+}
+
+type memoryUserModel struct {
+	db       memoryDB
+	policies VerificationPolicies
+}
+
+func (m memoryUserModel) New(ctx context.Context, email, password string) (*User, error) {
+	user := &User{Email: email}
+
+	err := user.SetPasswordHash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (m memoryUserModel) Insert(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	if _, ok := t.userWithEmail(user.Email); ok {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = id
+	user.CreatedAt = time.Now()
+	user.Version = 1
+
+	t.users[id] = *user
+
+	return nil
+}
+
+func (t *memoryTables) userWithEmail(email string) (User, bool) {
+	for _, u := range t.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, true
+		}
+	}
+
+	return User{}, false
+}
+
+func (m memoryUserModel) GetForCredentials(ctx context.Context, email, password string) (*User, error) {
+	t := m.db.lock()
+	u, ok := t.userWithEmail(email)
+	m.db.unlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	match, err := argon2id.ComparePasswordAndHash(password, string(u.PasswordHash))
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &u, nil
+}
+
+func (m memoryUserModel) Get(ctx context.Context, id uuid.UUID) (*User, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	u, ok := t.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &u, nil
+}
+
+func (m memoryUserModel) GetForEmail(ctx context.Context, email string) (*User, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	u, ok := t.userWithEmail(email)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &u, nil
+}
+
+func (m memoryUserModel) GetForAuthenticationClaims(ctx context.Context, claims *AuthenticationClaims) (*User, error) {
+	u, err := m.Get(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Version != claims.Version {
+		return nil, ErrStaleToken
+	}
+
+	return u, nil
+}
+
+func (m memoryUserModel) GetForAuthenticationToken(ctx context.Context, token string) (*User, *AuthenticationToken, error) {
+	hash := generateHash(token)
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	row, ok := t.authenticationTokens[string(hash)]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	u, ok := t.users[row.userID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	if time.Now().After(row.expiry) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	at := &AuthenticationToken{
+		UserID:          u.ID,
+		FamilyID:        row.familyID,
+		AuthenticatedAt: row.authenticatedAt,
+		Token: &Token{
+			Plaintext: token,
+			Hash:      hash,
+			Expiry:    row.expiry,
+		},
+	}
+
+	return &u, at, nil
+}
+
+func (m memoryUserModel) GetForVerificationToken(ctx context.Context, scope, token string) (*User, *VerificationToken, error) {
+	hash := generateHash(token)
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	vt, ok := t.verificationTokens[string(hash)]
+	if !ok || vt.scope != scope || vt.userID == nil {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	u, ok := t.users[*vt.userID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	err := t.checkVerificationAttempts(scope, vt.email, m.policies.Get(scope).TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if time.Now().After(vt.expiry) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	t.resetVerificationAttempts(scope, vt.email)
+
+	return &u, &VerificationToken{
+		Scope:  scope,
+		Email:  vt.email,
+		UserID: &u.ID,
+		Token:  &Token{Plaintext: token, Hash: hash, Expiry: vt.expiry},
+	}, nil
+}
+
+func (m memoryUserModel) ExistsWithEmail(ctx context.Context, email string) (bool, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	_, ok := t.userWithEmail(email)
+
+	return ok, nil
+}
+
+func (m memoryUserModel) Update(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	current, ok := t.users[user.ID]
+	if !ok || current.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if u, ok := t.userWithEmail(user.Email); ok && u.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	if foldEmail(current.Email) != foldEmail(user.Email) || !bytes.Equal(current.PasswordHash, user.PasswordHash) {
+		user.Version++
+	}
+
+	updated := *user
+	updated.CreatedAt = current.CreatedAt
+	t.users[user.ID] = updated
+
+	return nil
+}
+
+func (m memoryUserModel) BumpVersion(ctx context.Context, user *User) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	current, ok := t.users[user.ID]
+	if !ok || current.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	current.Version++
+	t.users[user.ID] = current
+	user.Version = current.Version
+
+	return nil
+}
+
+func (m memoryUserModel) Delete(ctx context.Context, id uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	if _, ok := t.users[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(t.users, id)
+	t.cascadeUser(id)
+
+	return nil
+}
+
+type memoryVerificationToken struct {
+	scope     string
+	email     string
+	userID    *uuid.UUID
+	expiry    time.Time
+	createdAt time.Time
+}
+
+type memoryAttemptKey struct {
+	scope string
+	email string
+}
+
+type memoryVerificationAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+}
+
+type memoryVerificationTokenModel struct {
+	db       memoryDB
+	policies VerificationPolicies
+}
+
+func (m memoryVerificationTokenModel) New(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error) {
+	t, err := generateToken(m.policies.Get(scope).TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	vt := &VerificationToken{
+		Scope:  scope,
+		Email:  email,
+		UserID: userID,
+		Token:  t,
+	}
+
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	err = tables.issueVerificationToken(vt, mail)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m memoryVerificationTokenModel) Insert(ctx context.Context, vt *VerificationToken) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	return t.insertVerificationToken(vt)
+}
+
+func (t *memoryTables) insertVerificationToken(vt *VerificationToken) error {
+	err := vt.Validate()
+	if err != nil {
+		return err
+	}
+
+	t.verificationTokens[string(vt.Hash)] = memoryVerificationToken{
+		scope:     vt.Scope,
+		email:     vt.Email,
+		userID:    copyUUID(vt.UserID),
+		expiry:    vt.Expiry,
+		createdAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (t *memoryTables) issueVerificationToken(vt *VerificationToken, mail *Mail) error {
+	err := t.insertVerificationToken(vt)
+	if err != nil {
+		return err
+	}
+
+	t.resetVerificationAttempts(vt.Scope, vt.Email)
+
+	if mail == nil {
+		return nil
+	}
+
+	job, err := newMailJob(mail, vt.Token)
+	if err != nil {
+		return err
+	}
+
+	return t.enqueueJob(job)
+}
+
+func (m memoryVerificationTokenModel) Resend(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error) {
+	policy := m.policies.Get(scope)
+
+	t, err := generateToken(policy.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	vt := &VerificationToken{
+		Scope:  scope,
+		Email:  email,
+		UserID: userID,
+		Token:  t,
+	}
+
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	match := func(row memoryVerificationToken) bool {
+		if row.scope != scope || !strings.EqualFold(row.email, email) {
+			return false
+		}
+
+		return userID == nil || (row.userID != nil && *row.userID == *userID)
+	}
+
+	for _, row := range tables.verificationTokens {
+		if match(row) && time.Since(row.createdAt) < policy.Cooldown {
+			return nil, ErrCooldown
+		}
+	}
+
+	for k, row := range tables.verificationTokens {
+		if match(row) {
+			delete(tables.verificationTokens, k)
+		}
+	}
+
+	err = tables.issueVerificationToken(vt, mail)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m memoryVerificationTokenModel) PurgeWithEmail(ctx context.Context, email string) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, vt := range t.verificationTokens {
+		if strings.EqualFold(vt.email, email) {
+			delete(t.verificationTokens, k)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryVerificationTokenModel) PurgeWithScope(ctx context.Context, scope, email string) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.purgeVerificationTokens(scope, email)
+
+	return nil
+}
+
+func (t *memoryTables) purgeVerificationTokens(scope, email string) {
+	for k, vt := range t.verificationTokens {
+		if vt.scope == scope && strings.EqualFold(vt.email, email) {
+			delete(t.verificationTokens, k)
+		}
+	}
+}
+
+func (m memoryVerificationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, vt := range t.verificationTokens {
+		if vt.userID != nil && *vt.userID == userID && vt.scope != ScopeEmailRevert {
+			delete(t.verificationTokens, k)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryVerificationTokenModel) PurgeWithScopeUserID(ctx context.Context, scope string, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, vt := range t.verificationTokens {
+		if vt.scope == scope && vt.userID != nil && *vt.userID == userID {
+			delete(t.verificationTokens, k)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryVerificationTokenModel) Verify(ctx context.Context, token, scope, email string, userID *uuid.UUID) error {
+	hash := generateHash(token)
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	window := m.policies.Get(scope).TTL
+
+	err := t.checkVerificationAttempts(scope, email, window)
+	if err != nil {
+		return err
+	}
+
+	vt, ok := t.verificationTokens[string(hash)]
+	if ok && userID != nil {
+		ok = vt.userID != nil && *vt.userID == *userID
+	}
+	if !ok || vt.scope != scope || !strings.EqualFold(vt.email, email) {
+		return t.failVerification(scope, email, window)
+	}
+
+	if time.Now().After(vt.expiry) {
+		return ErrExpiredToken
+	}
+
+	t.resetVerificationAttempts(scope, email)
+
+	return nil
+}
+
+func (t *memoryTables) checkVerificationAttempts(scope, email string, window time.Duration) error {
+	a, ok := t.verificationAttempts[memoryAttemptKey{scope, foldEmail(email)}]
+	if ok && a.failures >= VerificationMaxAttempts && a.lastFailureAt.After(time.Now().Add(-window)) {
+		return ErrTooManyAttempts
+	}
+
+	return nil
+}
+
+func (t *memoryTables) failVerification(scope, email string, window time.Duration) error {
+	key := memoryAttemptKey{scope, foldEmail(email)}
+	now := time.Now()
+
+	a, ok := t.verificationAttempts[key]
+	if ok && a.lastFailureAt.After(now.Add(-window)) {
+		a.failures++
+	} else {
+		a.failures = 1
+	}
+	a.lastFailureAt = now
+
+	t.verificationAttempts[key] = a
+
+	if a.failures < VerificationMaxAttempts {
+		return ErrRecordNotFound
+	}
+
+	t.purgeVerificationTokens(scope, email)
+
+	return ErrTooManyAttempts
+}
+
+func (t *memoryTables) resetVerificationAttempts(scope, email string) {
+	delete(t.verificationAttempts, memoryAttemptKey{scope, foldEmail(email)})
+}
+
+type memoryLoginAttemptModel struct {
+	db memoryDB
+}
+
+func (m memoryLoginAttemptModel) Reserve(ctx context.Context, scope, email string) (*LoginAttempt, time.Duration, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	a := t.loginAttempt(scope, email)
+
+	if wait := a.reserve(time.Now()); wait > 0 {
+		return &a, wait, nil
+	}
+
+	t.loginAttempts[memoryAttemptKey{scope, foldEmail(email)}] = a
+
+	return &a, 0, nil
+}
+
+func (m memoryLoginAttemptModel) Insert(ctx context.Context, a *LoginAttempt) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.loginAttempts[memoryAttemptKey{a.Scope, foldEmail(a.Email)}] = *a
+
+	return nil
+}
+
+func (m memoryLoginAttemptModel) Reset(ctx context.Context, scope, email string) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	delete(t.loginAttempts, memoryAttemptKey{scope, foldEmail(email)})
+
+	return nil
+}
+
+// Failed login tracking in scope for the email, or an empty attempt.
+func (t *memoryTables) loginAttempt(scope, email string) LoginAttempt {
+	a := LoginAttempt{Scope: scope, Email: email}
+
+	if row, ok := t.loginAttempts[memoryAttemptKey{scope, foldEmail(email)}]; ok {
+		a.Failures = row.Failures
+		a.LastFailureAt = row.LastFailureAt
+		a.LockedUntil = row.LockedUntil
+	}
+
+	return a
+}
+
+type memoryJob struct {
+	Job
+	status      string
+	runAt       time.Time
+	lockedUntil time.Time
+	lastError   string
+}
+
+type memoryJobModel struct {
+	db memoryDB
+}
+
+func (t *memoryTables) enqueueJob(job *Job) error {
+	err := job.Validate()
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	row := memoryJob{
+		Job:    *job,
+		status: JobStatusPending,
+		runAt:  time.Now(),
+	}
+	row.ID = id
+	row.Attempts = 0
+
+	t.jobs[id] = row
+
+	return nil
+}
+
+func (m memoryJobModel) Claim(ctx context.Context) (*Job, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	now := time.Now()
+
+	var next *memoryJob
+	for _, j := range t.jobs {
+		lost := j.status == JobStatusRunning && j.lockedUntil.Before(now)
+		if lost && j.Attempts >= j.MaxAttempts {
+			j.bury(JobLostMessage)
+			t.jobs[j.ID] = j
+			continue
+		}
+
+		due := (j.status == JobStatusPending && !j.runAt.After(now)) || lost
+
+		if due && (next == nil || j.runAt.Before(next.runAt)) {
+			next = &j
+		}
+	}
+
+	if next == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	next.status = JobStatusRunning
+	next.Attempts++
+	next.lockedUntil = now.Add(JobLease)
+
+	t.jobs[next.ID] = *next
+
+	job := next.Job
+
+	return &job, nil
+}
+
+func (m memoryJobModel) Complete(ctx context.Context, job *Job) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	delete(t.jobs, job.ID)
+
+	return nil
+}
+
+func (m memoryJobModel) Fail(ctx context.Context, job *Job, jobErr error) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	row, ok := t.jobs[job.ID]
+	if !ok {
+		return nil
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		row.bury(jobErr.Error())
+	} else {
+		row.status = JobStatusPending
+		row.lockedUntil = time.Time{}
+		row.lastError = jobErr.Error()
+	}
+	row.runAt = time.Now().Add(JobBackoff(job.Attempts))
+
+	t.jobs[job.ID] = row
+
+	return nil
+}
+
+// Move the job to the dead letter state, removing any mailed token
+// from its payload.
+func (j *memoryJob) bury(lastError string) {
+	j.status = JobStatusDead
+	j.lockedUntil = time.Time{}
+	j.lastError = lastError
+
+	var payload map[string]any
+	if json.Unmarshal(j.Payload, &payload) != nil {
+		return
+	}
+
+	if data, ok := payload["data"].(map[string]any); ok {
+		delete(data, "token")
+
+		scrubbed, err := json.Marshal(payload)
+		if err == nil {
+			j.Payload = scrubbed
+		}
+	}
+}
+
+type memoryCleanupModel struct {
+	db memoryDB
+}
+
+// Delete expired rows, under the same conditions as the Postgres
+// cleanup. There is no other process to share the work with, so ok
+// is always true.
+func (m memoryCleanupModel) DeleteExpired(ctx context.Context) (map[string]int64, bool, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	now := time.Now()
+	deleted := make(map[string]int64, len(cleanupTables))
+	for _, table := range cleanupTables {
+		deleted[table.name] = 0
+	}
+
+	for k, vt := range t.verificationTokens {
+		if vt.expiry.Before(now) {
+			delete(t.verificationTokens, k)
+			deleted["verification_token_"]++
+		}
+	}
+	for k, a := range t.verificationAttempts {
+		if a.lastFailureAt.Before(now.Add(-VerificationTokenTTL)) && !t.outstandingVerification(k) {
+			delete(t.verificationAttempts, k)
+			deleted["verification_attempt_"]++
+		}
+	}
+	for k, at := range t.authenticationTokens {
+		if at.expiry.Before(now) && !t.liveRefreshToken(at.familyID, now) {
+			delete(t.authenticationTokens, k)
+			deleted["authentication_token_"]++
+		}
+	}
+	for k, rt := range t.refreshTokens {
+		if rt.expiry.Before(now) {
+			delete(t.refreshTokens, k)
+			deleted["refresh_token_"]++
+		}
+	}
+	for k, c := range t.mfaChallenges {
+		if c.expiry.Before(now) {
+			delete(t.mfaChallenges, k)
+			deleted["mfa_challenge_"]++
+		}
+	}
+	for k, pat := range t.personalAccessTokens {
+		if pat.expiry.Before(now) {
+			delete(t.personalAccessTokens, k)
+			deleted["personal_access_token_"]++
+		}
+	}
+	for k, s := range t.webAuthnSessions {
+		if s.expiry.Before(now) {
+			delete(t.webAuthnSessions, k)
+			deleted["webauthn_session_"]++
+		}
+	}
+	for k, a := range t.loginAttempts {
+		if a.LastFailureAt.Before(now.Add(-LoginAttemptWindow)) {
+			delete(t.loginAttempts, k)
+			deleted["login_attempt_"]++
+		}
+	}
+	for k, j := range t.jobs {
+		if j.status == JobStatusDead && j.runAt.Before(now.Add(-JobDeadRetention)) {
+			delete(t.jobs, k)
+			deleted["job_"]++
+		}
+	}
+
+	return deleted, true, nil
+}
+
+// Reports whether a verification token for the attempt's scope and
+// email is outstanding.
+func (t *memoryTables) outstandingVerification(key memoryAttemptKey) bool {
+	for _, vt := range t.verificationTokens {
+		if vt.scope == key.scope && foldEmail(vt.email) == key.email {
+			return true
+		}
+	}
+
+	return false
+}
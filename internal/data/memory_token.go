@@ -0,0 +1,997 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/api/internal/paseto"
+	"github.com/micahco/api/internal/totp"
+)
+
+type memoryAuthenticationToken struct {
+	id              uuid.UUID
+	userID          uuid.UUID
+	familyID        uuid.UUID
+	authenticatedAt time.Time
+	device          Device
+	expiry          time.Time
+	createdAt       time.Time
+	lastUsedAt      time.Time
+}
+
+type memoryAuthenticationTokenModel struct {
+	db   memoryDB
+	keys *paseto.KeyRing
+}
+
+func (m memoryAuthenticationTokenModel) Signed() bool {
+	return m.keys != nil
+}
+
+func (m memoryAuthenticationTokenModel) New(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error) {
+	var t *Token
+	var err error
+
+	if m.Signed() {
+		t, err = generateSignedToken(m.keys, user, familyID, authenticatedAt)
+	} else {
+		t, err = generateToken(AuthenticationTokenTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at := &AuthenticationToken{user.ID, familyID, authenticatedAt, device, t}
+
+	err = m.Insert(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m memoryAuthenticationTokenModel) Rotate(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error) {
+	var t *Token
+	var err error
+
+	if m.Signed() {
+		t, err = generateSignedToken(m.keys, user, familyID, authenticatedAt)
+	} else {
+		t, err = generateToken(AuthenticationTokenTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at := &AuthenticationToken{user.ID, familyID, authenticatedAt, device, t}
+
+	err = at.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	for k, row := range tables.authenticationTokens {
+		if row.familyID != familyID || row.userID != user.ID {
+			continue
+		}
+
+		row.authenticatedAt = at.AuthenticatedAt
+		row.expiry = at.Expiry
+		row.lastUsedAt = time.Now()
+		row.device.IP = device.IP
+		row.device.UserAgent = device.UserAgent
+		if device.Name != "" {
+			row.device.Name = device.Name
+		}
+
+		delete(tables.authenticationTokens, k)
+		tables.authenticationTokens[string(at.Hash)] = row
+
+		return t, nil
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryAuthenticationTokenModel) ParseSigned(token string) (*AuthenticationClaims, error) {
+	return parseSignedToken(m.keys, token)
+}
+
+func (m memoryAuthenticationTokenModel) Insert(ctx context.Context, at *AuthenticationToken) error {
+	err := at.Validate()
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	now := time.Now()
+
+	t.authenticationTokens[string(at.Hash)] = memoryAuthenticationToken{
+		id:              id,
+		userID:          at.UserID,
+		familyID:        at.FamilyID,
+		authenticatedAt: at.AuthenticatedAt,
+		device:          at.Device,
+		expiry:          at.Expiry,
+		createdAt:       now,
+		lastUsedAt:      now,
+	}
+
+	return nil
+}
+
+func (m memoryAuthenticationTokenModel) Exists(ctx context.Context, userID uuid.UUID) (bool, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for _, at := range t.authenticationTokens {
+		if at.userID == userID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m memoryAuthenticationTokenModel) Delete(ctx context.Context, token string) error {
+	key := string(generateHash(token))
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	at, ok := t.authenticationTokens[key]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(t.authenticationTokens, key)
+	t.purgeRefreshFamily(at.familyID)
+
+	return nil
+}
+
+func (m memoryAuthenticationTokenModel) PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, at := range t.authenticationTokens {
+		if at.familyID == familyID {
+			delete(t.authenticationTokens, k)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryAuthenticationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, at := range t.authenticationTokens {
+		if at.userID == userID {
+			delete(t.authenticationTokens, k)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryAuthenticationTokenModel) Verify(ctx context.Context, token string, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	at, ok := t.authenticationTokens[string(generateHash(token))]
+	if !ok || at.userID != userID {
+		return ErrRecordNotFound
+	}
+
+	if time.Now().After(at.expiry) {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
+
+func (m memoryAuthenticationTokenModel) GetAllForUser(ctx context.Context, userID uuid.UUID, token string) ([]*Session, error) {
+	current := string(generateHash(token))
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	now := time.Now()
+
+	sessions := []*Session{}
+	for k, at := range t.authenticationTokens {
+		if at.userID != userID {
+			continue
+		}
+		if !at.expiry.After(now) && !t.liveRefreshToken(at.familyID, now) {
+			continue
+		}
+
+		sessions = append(sessions, &Session{
+			ID:         at.id,
+			CreatedAt:  at.createdAt,
+			LastUsedAt: at.lastUsedAt,
+			Expiry:     at.expiry,
+			IP:         at.device.IP,
+			UserAgent:  at.device.UserAgent,
+			DeviceName: at.device.Name,
+			Current:    k == current,
+		})
+	}
+
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func (m memoryAuthenticationTokenModel) DeleteForUser(ctx context.Context, id, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, at := range t.authenticationTokens {
+		if at.id == id && at.userID == userID {
+			delete(t.authenticationTokens, k)
+			t.purgeRefreshFamily(at.familyID)
+
+			return nil
+		}
+	}
+
+	return ErrRecordNotFound
+}
+
+func (m memoryAuthenticationTokenModel) UpdateLastUsed(ctx context.Context, token string) error {
+	key := string(generateHash(token))
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	at, ok := t.authenticationTokens[key]
+	if !ok || !at.lastUsedAt.Before(time.Now().Add(-lastUsedInterval)) {
+		return nil
+	}
+
+	at.lastUsedAt = time.Now()
+	t.authenticationTokens[key] = at
+
+	return nil
+}
+
+type memoryRefreshToken struct {
+	userID          uuid.UUID
+	familyID        uuid.UUID
+	authenticatedAt time.Time
+	expiry          time.Time
+	used            bool
+}
+
+// Reports whether the family has an unused, unexpired refresh token.
+func (t *memoryTables) liveRefreshToken(familyID uuid.UUID, now time.Time) bool {
+	for _, rt := range t.refreshTokens {
+		if rt.familyID == familyID && !rt.used && rt.expiry.After(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *memoryTables) purgeRefreshFamily(familyID uuid.UUID) {
+	for k, rt := range t.refreshTokens {
+		if rt.familyID == familyID {
+			delete(t.refreshTokens, k)
+		}
+	}
+}
+
+// Delete every refresh and authentication token in the family.
+func (t *memoryTables) purgeFamily(familyID uuid.UUID) {
+	t.purgeRefreshFamily(familyID)
+
+	for k, at := range t.authenticationTokens {
+		if at.familyID == familyID {
+			delete(t.authenticationTokens, k)
+		}
+	}
+}
+
+type memoryRefreshTokenModel struct {
+	db memoryDB
+}
+
+func (m memoryRefreshTokenModel) New(ctx context.Context, userID, familyID uuid.UUID, authenticatedAt time.Time) (*Token, error) {
+	t, err := generateToken(RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &RefreshToken{userID, familyID, authenticatedAt, t}
+
+	err = m.Insert(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m memoryRefreshTokenModel) Insert(ctx context.Context, rt *RefreshToken) error {
+	err := rt.Validate()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.refreshTokens[string(rt.Hash)] = memoryRefreshToken{
+		userID:          rt.UserID,
+		familyID:        rt.FamilyID,
+		authenticatedAt: rt.AuthenticatedAt,
+		expiry:          rt.Expiry,
+	}
+
+	return nil
+}
+
+func (m memoryRefreshTokenModel) Use(ctx context.Context, token string) (*RefreshToken, error) {
+	hash := generateHash(token)
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	row, ok := t.refreshTokens[string(hash)]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	if row.used {
+		t.purgeFamily(row.familyID)
+
+		return nil, ErrReusedToken
+	}
+
+	if time.Now().After(row.expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	row.used = true
+	t.refreshTokens[string(hash)] = row
+
+	rt := &RefreshToken{
+		UserID:          row.userID,
+		FamilyID:        row.familyID,
+		AuthenticatedAt: row.authenticatedAt,
+		Token: &Token{
+			Hash:   hash,
+			Expiry: row.expiry,
+		},
+	}
+
+	return rt, nil
+}
+
+func (m memoryRefreshTokenModel) PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.purgeFamily(familyID)
+
+	return nil
+}
+
+func (m memoryRefreshTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, rt := range t.refreshTokens {
+		if rt.userID == userID {
+			delete(t.refreshTokens, k)
+		}
+	}
+
+	return nil
+}
+
+type memoryPersonalAccessToken struct {
+	id         uuid.UUID
+	userID     uuid.UUID
+	name       string
+	scopes     []string
+	createdAt  time.Time
+	lastUsedAt *time.Time
+	expiry     time.Time
+}
+
+type memoryPersonalAccessTokenModel struct {
+	db memoryDB
+}
+
+func (m memoryPersonalAccessTokenModel) New(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiry time.Time) (*PersonalAccessToken, error) {
+	t, err := generatePrefixedToken(PersonalAccessTokenPrefix, time.Until(expiry))
+	if err != nil {
+		return nil, err
+	}
+
+	pat := &PersonalAccessToken{
+		UserID: userID,
+		Name:   name,
+		Scopes: scopes,
+		Token:  t,
+	}
+
+	err = m.Insert(ctx, pat)
+	if err != nil {
+		return nil, err
+	}
+
+	return pat, nil
+}
+
+func (m memoryPersonalAccessTokenModel) Insert(ctx context.Context, pat *PersonalAccessToken) error {
+	err := pat.Validate()
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	pat.ID = id
+	pat.CreatedAt = time.Now()
+
+	t.personalAccessTokens[string(pat.Hash)] = memoryPersonalAccessToken{
+		id:        pat.ID,
+		userID:    pat.UserID,
+		name:      pat.Name,
+		scopes:    slices.Clone(pat.Scopes),
+		createdAt: pat.CreatedAt,
+		expiry:    pat.Expiry,
+	}
+
+	return nil
+}
+
+func (m memoryPersonalAccessTokenModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*PersonalAccessToken, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	now := time.Now()
+
+	tokens := []*PersonalAccessToken{}
+	for _, pat := range t.personalAccessTokens {
+		if pat.userID != userID || !pat.expiry.After(now) {
+			continue
+		}
+
+		tokens = append(tokens, &PersonalAccessToken{
+			ID:         pat.id,
+			UserID:     pat.userID,
+			Name:       pat.name,
+			Scopes:     slices.Clone(pat.scopes),
+			CreatedAt:  pat.createdAt,
+			LastUsedAt: pat.lastUsedAt,
+			Token:      &Token{Expiry: pat.expiry},
+		})
+	}
+
+	slices.SortFunc(tokens, func(a, b *PersonalAccessToken) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (m memoryPersonalAccessTokenModel) DeleteForUser(ctx context.Context, id, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, pat := range t.personalAccessTokens {
+		if pat.id == id && pat.userID == userID {
+			delete(t.personalAccessTokens, k)
+
+			return nil
+		}
+	}
+
+	return ErrRecordNotFound
+}
+
+func (m memoryPersonalAccessTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, pat := range t.personalAccessTokens {
+		if pat.userID == userID {
+			delete(t.personalAccessTokens, k)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryPersonalAccessTokenModel) GetForToken(ctx context.Context, token string) (*User, []string, error) {
+	key := string(generateHash(token))
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	pat, ok := t.personalAccessTokens[key]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	u, ok := t.users[pat.userID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	now := time.Now()
+	if now.After(pat.expiry) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	pat.lastUsedAt = &now
+	t.personalAccessTokens[key] = pat
+
+	return &u, slices.Clone(pat.scopes), nil
+}
+
+type memoryTOTPModel struct {
+	db memoryDB
+}
+
+func (m memoryTOTPModel) New(ctx context.Context, userID uuid.UUID) (*TOTP, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	if row, ok := tables.totps[userID]; ok && row.Confirmed {
+		return nil, ErrMFAEnabled
+	}
+
+	t := TOTP{
+		UserID: userID,
+		Secret: secret,
+	}
+
+	tables.totps[userID] = t
+
+	return &t, nil
+}
+
+func (m memoryTOTPModel) Get(ctx context.Context, userID uuid.UUID) (*TOTP, error) {
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	t, ok := tables.totps[userID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &t, nil
+}
+
+func (m memoryTOTPModel) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	t, ok := tables.totps[userID]
+
+	return ok && t.Confirmed, nil
+}
+
+func (m memoryTOTPModel) Verify(ctx context.Context, t *TOTP, code string) error {
+	counter, ok := totp.Validate(t.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	row, ok := tables.totps[t.UserID]
+	if !ok || row.LastCounter >= int64(counter) {
+		return ErrInvalidCode
+	}
+
+	row.LastCounter = int64(counter)
+	tables.totps[t.UserID] = row
+
+	t.LastCounter = int64(counter)
+
+	return nil
+}
+
+func (m memoryTOTPModel) Confirm(ctx context.Context, userID uuid.UUID) error {
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	if t, ok := tables.totps[userID]; ok {
+		t.Confirmed = true
+		tables.totps[userID] = t
+	}
+
+	return nil
+}
+
+func (m memoryTOTPModel) Delete(ctx context.Context, userID uuid.UUID) error {
+	tables := m.db.lock()
+	defer m.db.unlock()
+
+	delete(tables.totps, userID)
+
+	return nil
+}
+
+type memoryRecoveryCodeModel struct {
+	db memoryDB
+}
+
+func (m memoryRecoveryCodeModel) New(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.purgeRecoveryCodes(userID)
+
+	for _, code := range codes {
+		t.recoveryCodes[string(generateHash(normalizeRecoveryCode(code)))] = userID
+	}
+
+	return codes, nil
+}
+
+func (m memoryRecoveryCodeModel) Use(ctx context.Context, userID uuid.UUID, code string) error {
+	key := string(generateHash(normalizeRecoveryCode(code)))
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	owner, ok := t.recoveryCodes[key]
+	if !ok || owner != userID {
+		return ErrInvalidCode
+	}
+
+	delete(t.recoveryCodes, key)
+
+	return nil
+}
+
+func (m memoryRecoveryCodeModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.purgeRecoveryCodes(userID)
+
+	return nil
+}
+
+func (t *memoryTables) purgeRecoveryCodes(userID uuid.UUID) {
+	for k, owner := range t.recoveryCodes {
+		if owner == userID {
+			delete(t.recoveryCodes, k)
+		}
+	}
+}
+
+type memoryMFAChallenge struct {
+	userID     uuid.UUID
+	deviceName string
+	attempts   int
+	expiry     time.Time
+}
+
+type memoryMFAChallengeModel struct {
+	db memoryDB
+}
+
+func (m memoryMFAChallengeModel) New(ctx context.Context, userID uuid.UUID, deviceName string) (*Token, error) {
+	t, err := generateToken(MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MFAChallenge{
+		UserID:     userID,
+		DeviceName: deviceName,
+		Token:      t,
+	}
+
+	err = m.Insert(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m memoryMFAChallengeModel) Insert(ctx context.Context, c *MFAChallenge) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.mfaChallenges[string(c.Hash)] = memoryMFAChallenge{
+		userID:     c.UserID,
+		deviceName: c.DeviceName,
+		expiry:     c.Expiry,
+	}
+
+	return nil
+}
+
+func (m memoryMFAChallengeModel) Get(ctx context.Context, token string) (*MFAChallenge, error) {
+	hash := generateHash(token)
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	row, ok := t.mfaChallenges[string(hash)]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	if time.Now().After(row.expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	c := &MFAChallenge{
+		UserID:     row.userID,
+		DeviceName: row.deviceName,
+		Attempts:   row.attempts,
+		Token: &Token{
+			Hash:   hash,
+			Expiry: row.expiry,
+		},
+	}
+
+	return c, nil
+}
+
+func (m memoryMFAChallengeModel) Fail(ctx context.Context, c *MFAChallenge) error {
+	key := string(c.Hash)
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	row, ok := t.mfaChallenges[key]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	row.attempts++
+	c.Attempts = row.attempts
+
+	if row.attempts >= MFAChallengeMaxAttempts {
+		delete(t.mfaChallenges, key)
+	} else {
+		t.mfaChallenges[key] = row
+	}
+
+	return nil
+}
+
+func (m memoryMFAChallengeModel) Delete(ctx context.Context, c *MFAChallenge) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	delete(t.mfaChallenges, string(c.Hash))
+
+	return nil
+}
+
+func (m memoryMFAChallengeModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, c := range t.mfaChallenges {
+		if c.userID == userID {
+			delete(t.mfaChallenges, k)
+		}
+	}
+
+	return nil
+}
+
+type memoryWebAuthnCredentialModel struct {
+	db memoryDB
+}
+
+func (m memoryWebAuthnCredentialModel) Insert(ctx context.Context, c *WebAuthnCredential) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	if _, ok := t.webAuthnCredentials[string(c.ID)]; ok {
+		return ErrDuplicateCredential
+	}
+
+	c.CreatedAt = time.Now()
+
+	t.webAuthnCredentials[string(c.ID)] = *c
+
+	return nil
+}
+
+func (m memoryWebAuthnCredentialModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*WebAuthnCredential, error) {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	creds := []*WebAuthnCredential{}
+	for _, c := range t.webAuthnCredentials {
+		if c.UserID == userID {
+			creds = append(creds, &c)
+		}
+	}
+
+	slices.SortFunc(creds, func(a, b *WebAuthnCredential) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return creds, nil
+}
+
+func (m memoryWebAuthnCredentialModel) UpdateForLogin(ctx context.Context, id, data []byte) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	c, ok := t.webAuthnCredentials[string(id)]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	now := time.Now()
+	c.Data = data
+	c.LastUsedAt = &now
+
+	t.webAuthnCredentials[string(id)] = c
+
+	return nil
+}
+
+func (m memoryWebAuthnCredentialModel) DeleteForUser(ctx context.Context, id []byte, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	c, ok := t.webAuthnCredentials[string(id)]
+	if !ok || c.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(t.webAuthnCredentials, string(id))
+
+	return nil
+}
+
+func (m memoryWebAuthnCredentialModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	for k, c := range t.webAuthnCredentials {
+		if c.UserID == userID {
+			delete(t.webAuthnCredentials, k)
+		}
+	}
+
+	return nil
+}
+
+type memoryWebAuthnSession struct {
+	userID *uuid.UUID
+	data   []byte
+	expiry time.Time
+}
+
+type memoryWebAuthnSessionModel struct {
+	db memoryDB
+}
+
+func (m memoryWebAuthnSessionModel) New(ctx context.Context, userID *uuid.UUID, data []byte) (*Token, error) {
+	t, err := generateToken(WebAuthnSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &WebAuthnSession{
+		UserID: userID,
+		Data:   data,
+		Token:  t,
+	}
+
+	err = m.Insert(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m memoryWebAuthnSessionModel) Insert(ctx context.Context, s *WebAuthnSession) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	t.webAuthnSessions[string(s.Hash)] = memoryWebAuthnSession{
+		userID: copyUUID(s.UserID),
+		data:   s.Data,
+		expiry: s.Expiry,
+	}
+
+	return nil
+}
+
+func (m memoryWebAuthnSessionModel) Take(ctx context.Context, token string, userID *uuid.UUID) (*WebAuthnSession, error) {
+	hash := generateHash(token)
+
+	t := m.db.lock()
+	defer m.db.unlock()
+
+	row, ok := t.webAuthnSessions[string(hash)]
+	if !ok || !equalUUID(row.userID, userID) {
+		return nil, ErrRecordNotFound
+	}
+
+	delete(t.webAuthnSessions, string(hash))
+
+	if time.Now().After(row.expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	s := &WebAuthnSession{
+		UserID: copyUUID(row.userID),
+		Data:   row.data,
+		Token: &Token{
+			Hash:   hash,
+			Expiry: row.expiry,
+		},
+	}
+
+	return s, nil
+}
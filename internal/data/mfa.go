@@ -0,0 +1,435 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/micahco/api/internal/totp"
+)
+
+const (
+	// Default expiry duration of an MFA challenge
+	MFAChallengeTTL = time.Minute * 5
+	// Failed codes allowed before a challenge is discarded
+	MFAChallengeMaxAttempts = 5
+	// Number of recovery codes issued at once
+	RecoveryCodeCount = 10
+)
+
+// Multi-factor authentication models
+type TOTPModel struct {
+	db dbtx
+}
+
+type RecoveryCodeModel struct {
+	db dbtx
+}
+
+type MFAChallengeModel struct {
+	db dbtx
+}
+
+type TOTP struct {
+	UserID      uuid.UUID
+	Secret      []byte
+	Confirmed   bool
+	LastCounter int64
+}
+
+// Create or replace the unconfirmed TOTP secret for the user. Fails
+// with ErrMFAEnabled if the user already has a confirmed secret.
+func (m TOTPModel) New(ctx context.Context, userID uuid.UUID) (*TOTP, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TOTP{
+		UserID: userID,
+		Secret: secret,
+	}
+
+	sql := `
+		INSERT INTO totp_ (user_id_, secret_)
+		VALUES($1, $2)
+		ON CONFLICT (user_id_) DO UPDATE
+		SET secret_ = EXCLUDED.secret_, last_counter_ = 0
+		WHERE totp_.confirmed_ = FALSE;`
+
+	args := []any{t.UserID, t.Secret}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.RowsAffected() == 0 {
+		return nil, ErrMFAEnabled
+	}
+
+	return t, nil
+}
+
+func (m TOTPModel) Get(ctx context.Context, userID uuid.UUID) (*TOTP, error) {
+	t := TOTP{UserID: userID}
+
+	sql := `
+		SELECT secret_, confirmed_, last_counter_
+		FROM totp_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, userID).Scan(&t.Secret, &t.Confirmed, &t.LastCounter)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// Reports whether the user has confirmed a TOTP secret.
+func (m TOTPModel) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var exists bool
+
+	sql := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM totp_
+			WHERE user_id_ = $1
+			AND confirmed_ = TRUE
+		);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Verify the code against the secret. A code is only accepted once,
+// later codes must belong to a newer period.
+func (m TOTPModel) Verify(ctx context.Context, t *TOTP, code string) error {
+	counter, ok := totp.Validate(t.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	sql := `
+		UPDATE totp_
+		SET last_counter_ = $2
+		WHERE user_id_ = $1
+		AND last_counter_ < $2;`
+
+	args := []any{t.UserID, int64(counter)}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrInvalidCode
+	}
+
+	t.LastCounter = int64(counter)
+
+	return nil
+}
+
+func (m TOTPModel) Confirm(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		UPDATE totp_
+		SET confirmed_ = TRUE
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+func (m TOTPModel) Delete(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM totp_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+// Replace the user's recovery codes with a new set. Returns the
+// plaintext codes, only their hashes are stored.
+func (m RecoveryCodeModel) New(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `
+		DELETE FROM recovery_code_
+		WHERE user_id_ = $1;`
+
+	_, err = tx.Exec(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `
+		INSERT INTO recovery_code_ (hash_, user_id_)
+		VALUES($1, $2);`
+
+	for _, code := range codes {
+		_, err = tx.Exec(ctx, sql, generateHash(normalizeRecoveryCode(code)), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Consume one of the user's recovery codes.
+func (m RecoveryCodeModel) Use(ctx context.Context, userID uuid.UUID, code string) error {
+	sql := `
+		DELETE FROM recovery_code_
+		WHERE user_id_ = $1
+		AND hash_ = $2;`
+
+	args := []any{userID, generateHash(normalizeRecoveryCode(code))}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (m RecoveryCodeModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM recovery_code_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+// Recovery codes are formatted as two groups of five characters.
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 7)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	str := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)[:10]
+
+	return str[:5] + "-" + str[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// An MFA challenge is issued after a user with MFA enabled presents
+// valid credentials. It is exchanged together with a code for an
+// authentication token.
+type MFAChallenge struct {
+	UserID     uuid.UUID
+	DeviceName string
+	Attempts   int
+	*Token
+}
+
+func (c MFAChallenge) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Hash, validation.Required),
+		validation.Field(&c.Expiry, validation.Required),
+		validation.Field(&c.UserID, validation.Required))
+}
+
+func (m MFAChallengeModel) New(ctx context.Context, userID uuid.UUID, deviceName string) (*Token, error) {
+	t, err := generateToken(MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MFAChallenge{
+		UserID:     userID,
+		DeviceName: deviceName,
+		Token:      t,
+	}
+
+	err = m.Insert(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m MFAChallengeModel) Insert(ctx context.Context, c *MFAChallenge) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO mfa_challenge_ (hash_, expiry_, device_name_, user_id_)
+		VALUES($1, $2, $3, $4);`
+
+	args := []any{c.Hash, c.Expiry, c.DeviceName, c.UserID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.db.Exec(ctx, sql, args...)
+	return err
+}
+
+func (m MFAChallengeModel) Get(ctx context.Context, token string) (*MFAChallenge, error) {
+	c := MFAChallenge{Token: &Token{}}
+
+	sql := `
+		SELECT user_id_, device_name_, attempts_, expiry_
+		FROM mfa_challenge_
+		WHERE hash_ = $1;`
+
+	hash := generateHash(token)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, hash).Scan(
+		&c.UserID,
+		&c.DeviceName,
+		&c.Attempts,
+		&c.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(c.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	c.Hash = hash
+
+	return &c, nil
+}
+
+// Record a failed code for the challenge. The challenge is discarded
+// once MFAChallengeMaxAttempts is reached.
+func (m MFAChallengeModel) Fail(ctx context.Context, c *MFAChallenge) error {
+	sql := `
+		UPDATE mfa_challenge_
+		SET attempts_ = attempts_ + 1
+		WHERE hash_ = $1
+		RETURNING attempts_;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, c.Hash).Scan(&c.Attempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if c.Attempts >= MFAChallengeMaxAttempts {
+		return m.Delete(ctx, c)
+	}
+
+	return nil
+}
+
+func (m MFAChallengeModel) Delete(ctx context.Context, c *MFAChallenge) error {
+	sql := `
+		DELETE FROM mfa_challenge_
+		WHERE hash_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, c.Hash)
+	return err
+}
+
+func (m MFAChallengeModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM mfa_challenge_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micahco/api/internal/totp"
+)
+
+func TestTOTPVerifyReplay(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		secret, err := m.TOTP.New(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		now := totp.Counter(time.Now())
+		code := totp.Code(secret.Secret, now)
+
+		otp, err := m.TOTP.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.TOTP.Verify(ctx, otp, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if otp.LastCounter != int64(now) {
+			t.Errorf("got last counter %d; want %d", otp.LastCounter, now)
+		}
+
+		// The same code is rejected, even by a copy read before it was used
+		stale := *otp
+		stale.LastCounter = 0
+
+		err = m.TOTP.Verify(ctx, &stale, code)
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("replayed code: got error %v; want %v", err, ErrInvalidCode)
+		}
+
+		// So is a code from an earlier period still within the skew
+		err = m.TOTP.Verify(ctx, otp, totp.Code(secret.Secret, now-1))
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("earlier code: got error %v; want %v", err, ErrInvalidCode)
+		}
+
+		err = m.TOTP.Verify(ctx, otp, totp.Code(secret.Secret, now+1))
+		if err != nil {
+			t.Errorf("later code: %v", err)
+		}
+
+		otp, err = m.TOTP.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if otp.LastCounter != int64(now+1) {
+			t.Errorf("got stored last counter %d; want %d", otp.LastCounter, now+1)
+		}
+	})
+}
+
+func TestRecoveryCodeUse(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+		bob := newUser(t, m, "bob@example.com")
+
+		codes, err := m.RecoveryCode.New(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(codes) != RecoveryCodeCount {
+			t.Fatalf("got %d codes; want %d", len(codes), RecoveryCodeCount)
+		}
+
+		err = m.RecoveryCode.Use(ctx, bob.ID, codes[0])
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("other user: got error %v; want %v", err, ErrInvalidCode)
+		}
+
+		err = m.RecoveryCode.Use(ctx, alice.ID, codes[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.RecoveryCode.Use(ctx, alice.ID, codes[0])
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("used code: got error %v; want %v", err, ErrInvalidCode)
+		}
+
+		// Codes are accepted however they are typed
+		typed := " " + strings.ToLower(strings.ReplaceAll(codes[1], "-", "")) + " "
+
+		err = m.RecoveryCode.Use(ctx, alice.ID, typed)
+		if err != nil {
+			t.Errorf("typed code: %v", err)
+		}
+
+		// A new set replaces the old one
+		_, err = m.RecoveryCode.New(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.RecoveryCode.Use(ctx, alice.ID, codes[2])
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("replaced code: got error %v; want %v", err, ErrInvalidCode)
+		}
+	})
+}
@@ -1,42 +1,148 @@
 package data
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/micahco/api/internal/paseto"
 )
 
+// Upper bound on each model call, within the deadline of the caller's
+// context.
 const ctxTimeout = 3 * time.Second
 
 type Models struct {
-	User                UserModel
-	VerificationToken   VerificationTokenModel
-	AuthenticationToken AuthenticationTokenModel
+	User                UserRepository
+	VerificationToken   VerificationTokenRepository
+	AuthenticationToken AuthenticationTokenRepository
+	RefreshToken        RefreshTokenRepository
+	PersonalAccessToken PersonalAccessTokenRepository
+	TOTP                TOTPRepository
+	RecoveryCode        RecoveryCodeRepository
+	MFAChallenge        MFAChallengeRepository
+	WebAuthnCredential  WebAuthnCredentialRepository
+	WebAuthnSession     WebAuthnSessionRepository
+	LoginAttempt        LoginAttemptRepository
+	Cleanup             CleanupRepository
+	Job                 JobRepository
+
+	// Runs fn with models sharing a transaction
+	withTx func(ctx context.Context, fn func(tx Models) error) error
+}
+
+// Run fn with models that share a single transaction. The transaction
+// is committed when fn returns nil and rolled back otherwise. Calling
+// WithTx on transaction models nests the transaction.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	return m.withTx(ctx, fn)
+}
+
+// Queries shared by a pool and a transaction, so models run the same
+// either way. Begin on a transaction starts a savepoint.
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Dependencies of the Postgres models, shared by their transactions.
+type postgres struct {
+	pool     *pgxpool.Pool
+	keys     *paseto.KeyRing
+	policies VerificationPolicies
+	cache    *userCache
+}
+
+// Create models backed by the pool. When keys is not nil, authentication
+// tokens are signed with the key ring instead of being opaque. Scopes
+// missing from policies use DefaultVerificationPolicies.
+func New(pool *pgxpool.Pool, keys *paseto.KeyRing, policies VerificationPolicies) Models {
+	p := &postgres{pool, keys, policies, newUserCache()}
+
+	return p.models(pool, nil)
 }
 
-func New(pool *pgxpool.Pool) Models {
+// Models running queries on db. Users updated through them are added
+// to evicted when not nil. Cleanup always runs on the pool, since it
+// takes a session advisory lock.
+func (p *postgres) models(db dbtx, evicted *[]uuid.UUID) Models {
 	return Models{
-		User:                UserModel{pool},
-		VerificationToken:   VerificationTokenModel{pool},
-		AuthenticationToken: AuthenticationTokenModel{pool},
+		User:                UserModel{db, p.cache, evicted, p.policies},
+		VerificationToken:   VerificationTokenModel{db, p.policies},
+		AuthenticationToken: AuthenticationTokenModel{db, p.keys},
+		RefreshToken:        RefreshTokenModel{db},
+		PersonalAccessToken: PersonalAccessTokenModel{db},
+		TOTP:                TOTPModel{db},
+		RecoveryCode:        RecoveryCodeModel{db},
+		MFAChallenge:        MFAChallengeModel{db},
+		WebAuthnCredential:  WebAuthnCredentialModel{db},
+		WebAuthnSession:     WebAuthnSessionModel{db},
+		LoginAttempt:        LoginAttemptModel{db},
+		Cleanup:             CleanupModel{p.pool},
+		Job:                 JobModel{db},
+		withTx: func(ctx context.Context, fn func(tx Models) error) error {
+			return p.withTx(ctx, db, evicted, fn)
+		},
 	}
 }
 
+// Begin a transaction on db, or a savepoint when db is a transaction.
+func (p *postgres) withTx(ctx context.Context, db dbtx, evicted *[]uuid.UUID, fn func(tx Models) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Users updated in the transaction are evicted from the cache once
+	// it ends, so a read racing the commit cannot keep a stale user.
+	if evicted == nil {
+		ids := []uuid.UUID{}
+		evicted = &ids
+
+		defer func() {
+			for _, id := range ids {
+				p.cache.delete(id)
+			}
+		}()
+	}
+
+	err = fn(p.models(tx, evicted))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
 // Validation rules
 var (
-	PasswordLength = validation.Length(8, 72)
+	PasswordLength   = validation.Length(8, 72)
+	DeviceNameLength = validation.Length(0, 100)
 )
 
 // Sentinel models errors
 var (
-	ErrRecordNotFound     = errors.New("models: no matching record found")
-	ErrInvalidCredentials = errors.New("models: invalid credentials")
-	ErrDuplicateEmail     = errors.New("models: duplicate email")
-	ErrExpiredToken       = errors.New("models: expired token")
-	ErrEditConflict       = errors.New("models: edit conflict")
+	ErrRecordNotFound      = errors.New("models: no matching record found")
+	ErrInvalidCredentials  = errors.New("models: invalid credentials")
+	ErrDuplicateEmail      = errors.New("models: duplicate email")
+	ErrExpiredToken        = errors.New("models: expired token")
+	ErrEditConflict        = errors.New("models: edit conflict")
+	ErrReusedToken         = errors.New("models: reused token")
+	ErrInvalidToken        = errors.New("models: invalid token")
+	ErrStaleToken          = errors.New("models: stale token")
+	ErrInvalidCode         = errors.New("models: invalid code")
+	ErrMFAEnabled          = errors.New("models: mfa already enabled")
+	ErrDuplicateCredential = errors.New("models: duplicate credential")
+	ErrTooManyAttempts     = errors.New("models: too many attempts")
+	ErrCooldown            = errors.New("models: resent too soon")
 )
 
 func pgErrCode(err error) string {
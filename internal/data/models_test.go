@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errRollback = errors.New("rollback")
+
+func TestWithTxCommit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		err := m.WithTx(ctx, func(tx Models) error {
+			_, err := tx.User.New(ctx, "alice@example.com", "pa55word1234")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exists, err := m.User.ExistsWithEmail(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Error("user created in a committed transaction does not exist")
+		}
+	})
+}
+
+func TestWithTxRollback(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		err := m.WithTx(ctx, func(tx Models) error {
+			_, err := tx.User.New(ctx, "bob@example.com", "pa55word1234")
+			if err != nil {
+				return err
+			}
+
+			// Models that issue their own transaction join this one
+			_, err = tx.VerificationToken.New(ctx, ScopeRegistration, "carol@example.com", nil, &Mail{
+				Recipient: "carol@example.com",
+				Template:  "registration.tmpl",
+			})
+			if err != nil {
+				return err
+			}
+
+			err = tx.User.Delete(ctx, alice.ID)
+			if err != nil {
+				return err
+			}
+
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("got error %v; want %v", err, errRollback)
+		}
+
+		exists, err := m.User.ExistsWithEmail(ctx, "bob@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Error("user created in a rolled back transaction exists")
+		}
+
+		_, err = m.User.Get(ctx, alice.ID)
+		if err != nil {
+			t.Errorf("user deleted in a rolled back transaction: %v", err)
+		}
+
+		_, err = m.Job.Claim(ctx)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("mail enqueued in a rolled back transaction: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
+
+func TestWithTxNested(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		err := m.WithTx(ctx, func(tx Models) error {
+			_, err := tx.User.New(ctx, "alice@example.com", "pa55word1234")
+			if err != nil {
+				return err
+			}
+
+			err = tx.WithTx(ctx, func(tx Models) error {
+				_, err := tx.User.New(ctx, "bob@example.com", "pa55word1234")
+				if err != nil {
+					return err
+				}
+
+				return errRollback
+			})
+			if !errors.Is(err, errRollback) {
+				t.Errorf("nested: got error %v; want %v", err, errRollback)
+			}
+
+			// A failed statement in the nested transaction does not
+			// abort the outer one
+			_, err = tx.User.New(ctx, "alice@example.com", "pa55word1234")
+			if !errors.Is(err, ErrDuplicateEmail) {
+				t.Errorf("duplicate: got error %v; want %v", err, ErrDuplicateEmail)
+			}
+
+			return tx.WithTx(ctx, func(tx Models) error {
+				_, err := tx.User.New(ctx, "carol@example.com", "pa55word1234")
+				return err
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for email, want := range map[string]bool{
+			"alice@example.com": true,
+			"bob@example.com":   false,
+			"carol@example.com": true,
+		} {
+			exists, err := m.User.ExistsWithEmail(ctx, email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != want {
+				t.Errorf("%s: got exists %t; want %t", email, exists, want)
+			}
+		}
+	})
+}
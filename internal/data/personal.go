@@ -0,0 +1,257 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// Prefix of every personal access token plaintext
+	PersonalAccessTokenPrefix = "pat_"
+	// Longest expiry a user may choose
+	PersonalAccessTokenMaxTTL = time.Hour * 24 * 365
+)
+
+// Scopes grantable to personal access tokens
+const (
+	TokenScopeUserRead      = "user:read"
+	TokenScopeUserWrite     = "user:write"
+	TokenScopeSessionsRead  = "sessions:read"
+	TokenScopeSessionsWrite = "sessions:write"
+	TokenScopeTokensRead    = "tokens:read"
+	TokenScopeTokensWrite   = "tokens:write"
+)
+
+var TokenScopes = []any{
+	TokenScopeUserRead,
+	TokenScopeUserWrite,
+	TokenScopeSessionsRead,
+	TokenScopeSessionsWrite,
+	TokenScopeTokensRead,
+	TokenScopeTokensWrite,
+}
+
+type PersonalAccessTokenModel struct {
+	db dbtx
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	*Token
+}
+
+func (pat PersonalAccessToken) Validate() error {
+	return validation.ValidateStruct(&pat,
+		validation.Field(&pat.Hash, validation.Required),
+		validation.Field(&pat.Expiry, validation.Required),
+		validation.Field(&pat.UserID, validation.Required),
+		validation.Field(&pat.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&pat.Scopes, validation.Required, validation.Each(validation.In(TokenScopes...))))
+}
+
+// Reports whether the plaintext token is a personal access token.
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// Create and insert a new personal access token for the user. The
+// returned token holds the plaintext, which is never stored.
+func (m PersonalAccessTokenModel) New(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiry time.Time) (*PersonalAccessToken, error) {
+	t, err := generatePrefixedToken(PersonalAccessTokenPrefix, time.Until(expiry))
+	if err != nil {
+		return nil, err
+	}
+
+	pat := &PersonalAccessToken{
+		UserID: userID,
+		Name:   name,
+		Scopes: scopes,
+		Token:  t,
+	}
+
+	err = m.Insert(ctx, pat)
+	if err != nil {
+		return nil, err
+	}
+
+	return pat, nil
+}
+
+func (m PersonalAccessTokenModel) Insert(ctx context.Context, pat *PersonalAccessToken) error {
+	err := pat.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO personal_access_token_ (hash_, user_id_, name_, scopes_, expiry_)
+		VALUES($1, $2, $3, $4, $5)
+		RETURNING id_, created_at_;`
+
+	args := []any{pat.Hash, pat.UserID, pat.Name, pat.Scopes, pat.Expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.db.QueryRow(ctx, sql, args...).Scan(&pat.ID, &pat.CreatedAt)
+}
+
+// Get all unexpired personal access tokens for the user, without
+// their plaintext.
+func (m PersonalAccessTokenModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*PersonalAccessToken, error) {
+	sql := `
+		SELECT id_, user_id_, name_, scopes_, created_at_, last_used_at_, expiry_
+		FROM personal_access_token_
+		WHERE user_id_ = $1
+		AND expiry_ > NOW()
+		ORDER BY created_at_ DESC;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		pat := PersonalAccessToken{Token: &Token{}}
+
+		err := rows.Scan(
+			&pat.ID,
+			&pat.UserID,
+			&pat.Name,
+			&pat.Scopes,
+			&pat.CreatedAt,
+			&pat.LastUsedAt,
+			&pat.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &pat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete the personal access token with id belonging to the user.
+func (m PersonalAccessTokenModel) DeleteForUser(ctx context.Context, id, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM personal_access_token_
+		WHERE id_ = $1
+		AND user_id_ = $2;`
+
+	args := []any{id, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete every personal access token belonging to the user.
+func (m PersonalAccessTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM personal_access_token_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+// Get the user and granted scopes for a plaintext personal access
+// token, recording its use. Expired tokens are not touched.
+func (m PersonalAccessTokenModel) GetForToken(ctx context.Context, token string) (*User, []string, error) {
+	var u User
+	var scopes []string
+
+	sql := `
+		UPDATE personal_access_token_
+		SET last_used_at_ = NOW()
+		FROM user_
+		WHERE user_.id_ = personal_access_token_.user_id_
+		AND personal_access_token_.hash_ = $1
+		AND personal_access_token_.expiry_ > NOW()
+		RETURNING user_.id_, user_.created_at_, user_.email_, user_.password_hash_,
+		user_.locale_, user_.version_, personal_access_token_.scopes_;`
+
+	hash := generateHash(token)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, hash).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+		&scopes,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, nil, m.missingToken(ctx, hash)
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &u, scopes, nil
+}
+
+// Error for a token hash that matched no usable token: ErrExpiredToken
+// if the token exists but has expired, ErrRecordNotFound otherwise.
+func (m PersonalAccessTokenModel) missingToken(ctx context.Context, hash []byte) error {
+	var exists bool
+
+	sql := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM personal_access_token_
+			WHERE hash_ = $1
+		);`
+
+	err := m.db.QueryRow(ctx, sql, hash).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrExpiredToken
+	}
+
+	return ErrRecordNotFound
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Default expiry duration
+const RefreshTokenTTL = time.Hour * 24 * 30
+
+type RefreshTokenModel struct {
+	db dbtx
+}
+
+// A refresh token belongs to a family. Every rotation issues a new
+// token in the same family, so presenting an already used token
+// revokes the entire family.
+type RefreshToken struct {
+	UserID          uuid.UUID
+	FamilyID        uuid.UUID
+	AuthenticatedAt time.Time
+	*Token
+}
+
+func (rt RefreshToken) Validate() error {
+	return validation.ValidateStruct(&rt,
+		validation.Field(&rt.Hash, validation.Required),
+		validation.Field(&rt.Expiry, validation.Required),
+		validation.Field(&rt.UserID, validation.Required),
+		validation.Field(&rt.FamilyID, validation.Required),
+		validation.Field(&rt.AuthenticatedAt, validation.Required))
+}
+
+func (m RefreshTokenModel) New(ctx context.Context, userID, familyID uuid.UUID, authenticatedAt time.Time) (*Token, error) {
+	t, err := generateToken(RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &RefreshToken{userID, familyID, authenticatedAt, t}
+
+	err = m.Insert(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, err
+}
+
+func (m RefreshTokenModel) Insert(ctx context.Context, t *RefreshToken) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO refresh_token_ (hash_, expiry_, family_id_, authenticated_at_, user_id_)
+		VALUES($1, $2, $3, $4, $5);`
+
+	args := []any{t.Hash, t.Expiry, t.FamilyID, t.AuthenticatedAt, t.UserID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Mark the refresh token as used and return it. If the token was
+// already used, the whole family of refresh and authentication
+// tokens is revoked and ErrReusedToken is returned.
+func (m RefreshTokenModel) Use(ctx context.Context, token string) (*RefreshToken, error) {
+	rt := &RefreshToken{Token: &Token{}}
+	var used bool
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `
+		SELECT user_id_, family_id_, authenticated_at_, expiry_, used_
+		FROM refresh_token_
+		WHERE hash_ = $1
+		FOR UPDATE;`
+
+	hash := generateHash(token)
+
+	err = tx.QueryRow(ctx, sql, hash).Scan(
+		&rt.UserID,
+		&rt.FamilyID,
+		&rt.AuthenticatedAt,
+		&rt.Expiry,
+		&used,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if used {
+		err = purgeFamily(ctx, tx, rt.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrReusedToken
+	}
+
+	if time.Now().After(rt.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	sql = `
+		UPDATE refresh_token_
+		SET used_ = TRUE
+		WHERE hash_ = $1;`
+
+	_, err = tx.Exec(ctx, sql, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.Hash = hash
+
+	return rt, nil
+}
+
+func (m RefreshTokenModel) PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return purgeFamily(ctx, m.db, familyID)
+}
+
+func (m RefreshTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM refresh_token_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Delete every refresh and authentication token in the family.
+func purgeFamily(ctx context.Context, db execer, familyID uuid.UUID) error {
+	sql := `
+		DELETE FROM refresh_token_
+		WHERE family_id_ = $1;`
+
+	_, err := db.Exec(ctx, sql, familyID)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+		DELETE FROM authentication_token_
+		WHERE family_id_ = $1;`
+
+	_, err = db.Exec(ctx, sql, familyID)
+	return err
+}
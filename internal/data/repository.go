@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Repositories implemented by the Postgres, SQLite and in-memory
+// models. All must behave the same, down to the errors they return.
+
+type UserRepository interface {
+	New(ctx context.Context, email, password string) (*User, error)
+	Insert(ctx context.Context, user *User) error
+	GetForCredentials(ctx context.Context, email, password string) (*User, error)
+	Get(ctx context.Context, id uuid.UUID) (*User, error)
+	GetForEmail(ctx context.Context, email string) (*User, error)
+	GetForAuthenticationClaims(ctx context.Context, claims *AuthenticationClaims) (*User, error)
+	GetForAuthenticationToken(ctx context.Context, token string) (*User, *AuthenticationToken, error)
+	GetForVerificationToken(ctx context.Context, scope, token string) (*User, *VerificationToken, error)
+	ExistsWithEmail(ctx context.Context, email string) (bool, error)
+	Update(ctx context.Context, user *User) error
+	BumpVersion(ctx context.Context, user *User) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type VerificationTokenRepository interface {
+	New(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error)
+	Insert(ctx context.Context, vt *VerificationToken) error
+	Resend(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error)
+	PurgeWithEmail(ctx context.Context, email string) error
+	PurgeWithScope(ctx context.Context, scope, email string) error
+	PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+	PurgeWithScopeUserID(ctx context.Context, scope string, userID uuid.UUID) error
+	Verify(ctx context.Context, token, scope, email string, userID *uuid.UUID) error
+}
+
+type AuthenticationTokenRepository interface {
+	Signed() bool
+	New(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error)
+	Rotate(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error)
+	ParseSigned(token string) (*AuthenticationClaims, error)
+	Insert(ctx context.Context, t *AuthenticationToken) error
+	Exists(ctx context.Context, userID uuid.UUID) (bool, error)
+	Delete(ctx context.Context, token string) error
+	PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error
+	PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+	Verify(ctx context.Context, token string, userID uuid.UUID) error
+	GetAllForUser(ctx context.Context, userID uuid.UUID, token string) ([]*Session, error)
+	DeleteForUser(ctx context.Context, id, userID uuid.UUID) error
+	UpdateLastUsed(ctx context.Context, token string) error
+}
+
+type RefreshTokenRepository interface {
+	New(ctx context.Context, userID, familyID uuid.UUID, authenticatedAt time.Time) (*Token, error)
+	Insert(ctx context.Context, t *RefreshToken) error
+	Use(ctx context.Context, token string) (*RefreshToken, error)
+	PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error
+	PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+}
+
+type PersonalAccessTokenRepository interface {
+	New(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiry time.Time) (*PersonalAccessToken, error)
+	Insert(ctx context.Context, pat *PersonalAccessToken) error
+	GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*PersonalAccessToken, error)
+	DeleteForUser(ctx context.Context, id, userID uuid.UUID) error
+	PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+	GetForToken(ctx context.Context, token string) (*User, []string, error)
+}
+
+type TOTPRepository interface {
+	New(ctx context.Context, userID uuid.UUID) (*TOTP, error)
+	Get(ctx context.Context, userID uuid.UUID) (*TOTP, error)
+	Enabled(ctx context.Context, userID uuid.UUID) (bool, error)
+	Verify(ctx context.Context, t *TOTP, code string) error
+	Confirm(ctx context.Context, userID uuid.UUID) error
+	Delete(ctx context.Context, userID uuid.UUID) error
+}
+
+type RecoveryCodeRepository interface {
+	New(ctx context.Context, userID uuid.UUID) ([]string, error)
+	Use(ctx context.Context, userID uuid.UUID, code string) error
+	PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+}
+
+type MFAChallengeRepository interface {
+	New(ctx context.Context, userID uuid.UUID, deviceName string) (*Token, error)
+	Insert(ctx context.Context, c *MFAChallenge) error
+	Get(ctx context.Context, token string) (*MFAChallenge, error)
+	Fail(ctx context.Context, c *MFAChallenge) error
+	Delete(ctx context.Context, c *MFAChallenge) error
+	PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+}
+
+type WebAuthnCredentialRepository interface {
+	Insert(ctx context.Context, c *WebAuthnCredential) error
+	GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*WebAuthnCredential, error)
+	UpdateForLogin(ctx context.Context, id, data []byte) error
+	DeleteForUser(ctx context.Context, id []byte, userID uuid.UUID) error
+	PurgeWithUserID(ctx context.Context, userID uuid.UUID) error
+}
+
+type WebAuthnSessionRepository interface {
+	New(ctx context.Context, userID *uuid.UUID, data []byte) (*Token, error)
+	Insert(ctx context.Context, s *WebAuthnSession) error
+	Take(ctx context.Context, token string, userID *uuid.UUID) (*WebAuthnSession, error)
+}
+
+type LoginAttemptRepository interface {
+	Reserve(ctx context.Context, scope, email string) (*LoginAttempt, time.Duration, error)
+	Insert(ctx context.Context, a *LoginAttempt) error
+	Reset(ctx context.Context, scope, email string) error
+}
+
+type CleanupRepository interface {
+	DeleteExpired(ctx context.Context) (deleted map[string]int64, ok bool, err error)
+}
+
+type JobRepository interface {
+	Claim(ctx context.Context) (*Job, error)
+	Complete(ctx context.Context, job *Job) error
+	Fail(ctx context.Context, job *Job, jobErr error) error
+}
+
+// Compile time checks that the Postgres models implement the
+// repositories
+var (
+	_ UserRepository                = UserModel{}
+	_ VerificationTokenRepository   = VerificationTokenModel{}
+	_ AuthenticationTokenRepository = AuthenticationTokenModel{}
+	_ RefreshTokenRepository        = RefreshTokenModel{}
+	_ PersonalAccessTokenRepository = PersonalAccessTokenModel{}
+	_ TOTPRepository                = TOTPModel{}
+	_ RecoveryCodeRepository        = RecoveryCodeModel{}
+	_ MFAChallengeRepository        = MFAChallengeModel{}
+	_ WebAuthnCredentialRepository  = WebAuthnCredentialModel{}
+	_ WebAuthnSessionRepository     = WebAuthnSessionModel{}
+	_ LoginAttemptRepository        = LoginAttemptModel{}
+	_ CleanupRepository             = CleanupModel{}
+	_ JobRepository                 = JobModel{}
+)
+
+// Compile time checks that the memory models implement the
+// repositories
+var (
+	_ UserRepository                = memoryUserModel{}
+	_ VerificationTokenRepository   = memoryVerificationTokenModel{}
+	_ AuthenticationTokenRepository = memoryAuthenticationTokenModel{}
+	_ RefreshTokenRepository        = memoryRefreshTokenModel{}
+	_ PersonalAccessTokenRepository = memoryPersonalAccessTokenModel{}
+	_ TOTPRepository                = memoryTOTPModel{}
+	_ RecoveryCodeRepository        = memoryRecoveryCodeModel{}
+	_ MFAChallengeRepository        = memoryMFAChallengeModel{}
+	_ WebAuthnCredentialRepository  = memoryWebAuthnCredentialModel{}
+	_ WebAuthnSessionRepository     = memoryWebAuthnSessionModel{}
+	_ LoginAttemptRepository        = memoryLoginAttemptModel{}
+	_ CleanupRepository             = memoryCleanupModel{}
+	_ JobRepository                 = memoryJobModel{}
+)
+
+// Compile time checks that the SQLite models implement the
+// repositories
+var (
+	_ UserRepository                = sqliteUserModel{}
+	_ VerificationTokenRepository   = sqliteVerificationTokenModel{}
+	_ AuthenticationTokenRepository = sqliteAuthenticationTokenModel{}
+	_ RefreshTokenRepository        = sqliteRefreshTokenModel{}
+	_ PersonalAccessTokenRepository = sqlitePersonalAccessTokenModel{}
+	_ TOTPRepository                = sqliteTOTPModel{}
+	_ RecoveryCodeRepository        = sqliteRecoveryCodeModel{}
+	_ MFAChallengeRepository        = sqliteMFAChallengeModel{}
+	_ WebAuthnCredentialRepository  = sqliteWebAuthnCredentialModel{}
+	_ WebAuthnSessionRepository     = sqliteWebAuthnSessionModel{}
+	_ LoginAttemptRepository        = sqliteLoginAttemptModel{}
+	_ CleanupRepository             = sqliteCleanupModel{}
+	_ JobRepository                 = sqliteJobModel{}
+)
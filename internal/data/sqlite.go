@@ -0,0 +1,1230 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/api/internal/paseto"
+	moderncsqlite "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLite models run the Postgres queries translated for SQLite, for
+// single node deployments without a database server. SQLite allows a
+// single writer at a time, so where the Postgres models take row or
+// advisory locks these rely on the write lock of their transaction.
+//
+// Emails are compared with the NOCASE collation in place of citext,
+// which folds ASCII letters only. Addresses that differ in the case of
+// a non-ASCII letter are distinct here, but the same in the Postgres
+// and memory models.
+
+// Scheme of DSNs selecting the SQLite backend, as in sqlite:api.db
+const SQLiteScheme = "sqlite:"
+
+// Driver options the SQLite models rely on. Transactions take the
+// write lock when they begin, so two of them cannot deadlock upgrading
+// from a read, and times are stored in a format SQLite can compare.
+const sqliteOptions = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite&_txlock=immediate"
+
+// Reports whether dsn selects the SQLite backend.
+func IsSQLiteDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, SQLiteScheme)
+}
+
+// Open the SQLite database file named by a sqlite: DSN, such as
+// sqlite:api.db or sqlite:///var/lib/api/api.db. The file is created
+// if it does not exist. Query parameters are passed to the driver.
+func OpenSQLite(dsn string) (*sql.DB, error) {
+	if !IsSQLiteDSN(dsn) {
+		return nil, fmt.Errorf("dsn must start with %q", SQLiteScheme)
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(dsn, SQLiteScheme), "//")
+
+	path, query, _ := strings.Cut(name, "?")
+	if path == "" {
+		return nil, errors.New("dsn is missing the database path")
+	}
+
+	options := sqliteOptions
+	if query != "" {
+		options += "&" + query
+	}
+
+	db, err := sql.Open("sqlite", path+"?"+options)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Reports whether err violates a unique or primary key constraint.
+func sqliteUniqueViolation(err error) bool {
+	var sqliteErr *moderncsqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	code := sqliteErr.Code()
+
+	return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// SQLite stores times as text and compares them as strings, which
+// only orders them correctly when every time is written in UTC.
+func sqliteArgs(args []any) []any {
+	converted := make([]any, len(args))
+
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC()
+		case *time.Time:
+			if v != nil {
+				converted[i] = v.UTC()
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+
+	return converted
+}
+
+// Queries shared by a database and a transaction
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Handle on the database held by SQLite models. Queries run in tx
+// when it is not nil, where depth counts the open savepoints.
+type sqliteDB struct {
+	db    *sql.DB
+	tx    *sql.Tx
+	depth int
+}
+
+func (d sqliteDB) conn() sqliteQuerier {
+	if d.tx != nil {
+		return d.tx
+	}
+
+	return d.db
+}
+
+func (d sqliteDB) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.conn().ExecContext(ctx, query, sqliteArgs(args)...)
+}
+
+func (d sqliteDB) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return d.conn().QueryContext(ctx, query, sqliteArgs(args)...)
+}
+
+func (d sqliteDB) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return d.conn().QueryRowContext(ctx, query, sqliteArgs(args)...)
+}
+
+// Transaction begun by sqliteDB.begin. Rollback does nothing once the
+// transaction has ended, so it can be deferred.
+type sqliteTx struct {
+	sqliteDB
+	commit   func() error
+	rollback func() error
+	done     bool
+}
+
+func (t *sqliteTx) Commit() error {
+	t.done = true
+
+	return t.commit()
+}
+
+func (t *sqliteTx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	return t.rollback()
+}
+
+// Begin a transaction, or a savepoint when d is a transaction.
+func (d sqliteDB) begin(ctx context.Context) (*sqliteTx, error) {
+	if d.tx == nil {
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &sqliteTx{
+			sqliteDB: sqliteDB{d.db, tx, 0},
+			commit:   tx.Commit,
+			rollback: tx.Rollback,
+		}, nil
+	}
+
+	name := fmt.Sprintf("savepoint_%d", d.depth+1)
+
+	_, err := d.tx.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Roll back even if ctx was cancelled, the enclosing transaction
+	// may still commit
+	rollbackCtx := context.WithoutCancel(ctx)
+
+	return &sqliteTx{
+		sqliteDB: sqliteDB{d.db, d.tx, d.depth + 1},
+		commit: func() error {
+			_, err := d.tx.ExecContext(ctx, "RELEASE "+name)
+			return err
+		},
+		rollback: func() error {
+			_, err := d.tx.ExecContext(rollbackCtx, "ROLLBACK TO "+name)
+			if err != nil {
+				return err
+			}
+
+			_, err = d.tx.ExecContext(rollbackCtx, "RELEASE "+name)
+			return err
+		},
+	}, nil
+}
+
+// Dependencies of the SQLite models, shared by their transactions.
+type sqlite struct {
+	db       *sql.DB
+	keys     *paseto.KeyRing
+	policies VerificationPolicies
+}
+
+// Create models backed by a SQLite database opened with OpenSQLite.
+// keys and policies are used as in New. Users are not cached, since
+// reading them from SQLite is as cheap as the cache.
+func NewSQLite(db *sql.DB, keys *paseto.KeyRing, policies VerificationPolicies) Models {
+	s := &sqlite{db, keys, policies}
+
+	return s.models(sqliteDB{db: db})
+}
+
+func (s *sqlite) models(db sqliteDB) Models {
+	return Models{
+		User:                sqliteUserModel{db, s.policies},
+		VerificationToken:   sqliteVerificationTokenModel{db, s.policies},
+		AuthenticationToken: sqliteAuthenticationTokenModel{db, s.keys},
+		RefreshToken:        sqliteRefreshTokenModel{db},
+		PersonalAccessToken: sqlitePersonalAccessTokenModel{db},
+		TOTP:                sqliteTOTPModel{db},
+		RecoveryCode:        sqliteRecoveryCodeModel{db},
+		MFAChallenge:        sqliteMFAChallengeModel{db},
+		WebAuthnCredential:  sqliteWebAuthnCredentialModel{db},
+		WebAuthnSession:     sqliteWebAuthnSessionModel{db},
+		LoginAttempt:        sqliteLoginAttemptModel{db},
+		Cleanup:             sqliteCleanupModel{db},
+		Job:                 sqliteJobModel{db},
+		withTx: func(ctx context.Context, fn func(tx Models) error) error {
+			return s.withTx(ctx, db, fn)
+		},
+	}
+}
+
+// Begin a transaction on db, or a savepoint when db is a transaction.
+func (s *sqlite) withTx(ctx context.Context, db sqliteDB, fn func(tx Models) error) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(s.models(tx.sqliteDB))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type sqliteUserModel struct {
+	db       sqliteDB
+	policies VerificationPolicies
+}
+
+func (m sqliteUserModel) New(ctx context.Context, email, password string) (*User, error) {
+	user := &User{Email: email}
+
+	err := user.SetPasswordHash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (m sqliteUserModel) Insert(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO user_ (email_, password_hash_, locale_)
+		VALUES($1, $2, $3)
+		RETURNING id_, created_at_, version_;`
+
+	args := []any{user.Email, user.PasswordHash, user.Locale}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err = m.db.queryRow(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case sqliteUniqueViolation(err):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m sqliteUserModel) GetForCredentials(ctx context.Context, email, password string) (*User, error) {
+	var u User
+
+	query := `
+		SELECT id_, created_at_, email_, password_hash_, locale_, version_
+		FROM user_ WHERE email_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, email).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidCredentials
+		default:
+			return nil, err
+		}
+	}
+
+	match, err := argon2id.ComparePasswordAndHash(password, string(u.PasswordHash))
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &u, nil
+}
+
+func (m sqliteUserModel) Get(ctx context.Context, id uuid.UUID) (*User, error) {
+	var u User
+
+	query := `
+		SELECT id_, created_at_, email_, password_hash_, locale_, version_
+		FROM user_ WHERE id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, id).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &u, nil
+}
+
+func (m sqliteUserModel) GetForEmail(ctx context.Context, email string) (*User, error) {
+	var u User
+
+	query := `
+		SELECT id_, created_at_, email_, password_hash_, locale_, version_
+		FROM user_ WHERE email_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, email).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &u, nil
+}
+
+func (m sqliteUserModel) GetForAuthenticationClaims(ctx context.Context, claims *AuthenticationClaims) (*User, error) {
+	u, err := m.Get(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Version != claims.Version {
+		return nil, ErrStaleToken
+	}
+
+	return u, nil
+}
+
+func (m sqliteUserModel) GetForAuthenticationToken(ctx context.Context, token string) (*User, *AuthenticationToken, error) {
+	var u User
+	at := AuthenticationToken{Token: &Token{Plaintext: token}}
+
+	query := `
+		SELECT user_.id_, user_.created_at_, user_.email_, user_.password_hash_,
+		user_.locale_, user_.version_, authentication_token_.family_id_,
+		authentication_token_.authenticated_at_, authentication_token_.expiry_
+		FROM user_
+		INNER JOIN authentication_token_
+		ON user_.id_ = authentication_token_.user_id_
+		WHERE authentication_token_.hash_ = $1;`
+
+	hash := generateHash(token)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, hash).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+		&at.FamilyID,
+		&at.AuthenticatedAt,
+		&at.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if time.Now().After(at.Expiry) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	at.UserID = u.ID
+	at.Hash = hash
+
+	return &u, &at, nil
+}
+
+func (m sqliteUserModel) GetForVerificationToken(ctx context.Context, scope, token string) (*User, *VerificationToken, error) {
+	var u User
+	vt := VerificationToken{Scope: scope, Token: &Token{Plaintext: token}}
+
+	query := `
+		SELECT user_.id_, user_.created_at_, user_.email_, user_.password_hash_,
+		user_.locale_, user_.version_, verification_token_.email_,
+		verification_token_.expiry_
+		FROM user_
+		INNER JOIN verification_token_
+		ON user_.id_ = verification_token_.user_id_
+		WHERE verification_token_.scope_ = $1
+		AND verification_token_.hash_ = $2;`
+
+	hash := generateHash(token)
+	args := []any{scope, hash}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, args...).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+		&vt.Email,
+		&vt.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	err = m.db.checkVerificationAttempts(ctx, scope, vt.Email, m.policies.Get(scope).TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if time.Now().After(vt.Expiry) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	err = m.db.resetVerificationAttempts(ctx, scope, vt.Email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vt.UserID = &u.ID
+	vt.Hash = hash
+
+	return &u, &vt, nil
+}
+
+func (m sqliteUserModel) ExistsWithEmail(ctx context.Context, email string) (bool, error) {
+	var exists bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_
+			WHERE email_ = $1
+		);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, email).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (m sqliteUserModel) Update(ctx context.Context, user *User) error {
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE user_
+		SET email_ = $1, password_hash_ = $2, locale_ = $3,
+		version_ = version_ + CASE WHEN email_ <> $1 OR password_hash_ <> $2 THEN 1 ELSE 0 END
+		WHERE id_ = $4 AND version_ = $5
+		RETURNING version_`
+
+	args := []any{
+		user.Email,
+		user.PasswordHash,
+		user.Locale,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err = m.db.queryRow(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case sqliteUniqueViolation(err):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m sqliteUserModel) BumpVersion(ctx context.Context, user *User) error {
+	query := `
+		UPDATE user_
+		SET version_ = version_ + 1
+		WHERE id_ = $1 AND version_ = $2
+		RETURNING version_`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m sqliteUserModel) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `
+		DELETE FROM user_
+		WHERE id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+type sqliteVerificationTokenModel struct {
+	db       sqliteDB
+	policies VerificationPolicies
+}
+
+func (m sqliteVerificationTokenModel) New(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error) {
+	t, err := generateToken(m.policies.Get(scope).TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	vt := &VerificationToken{
+		Scope:  scope,
+		Email:  email,
+		UserID: userID,
+		Token:  t,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.issueVerificationToken(ctx, vt, mail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m sqliteVerificationTokenModel) Insert(ctx context.Context, vt *VerificationToken) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.db.insertVerificationToken(ctx, vt)
+}
+
+func (d sqliteDB) insertVerificationToken(ctx context.Context, vt *VerificationToken) error {
+	err := vt.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO verification_token_ (hash_, expiry_, scope_, email_, user_id_)
+		VALUES($1, $2, $3, $4, $5);`
+
+	args := []any{vt.Hash, vt.Expiry, vt.Scope, vt.Email, vt.UserID}
+
+	_, err = d.exec(ctx, query, args...)
+	return err
+}
+
+// Insert the token with a clean slate of attempts, and enqueue mail
+// carrying it if not nil.
+func (d sqliteDB) issueVerificationToken(ctx context.Context, vt *VerificationToken, mail *Mail) error {
+	err := d.insertVerificationToken(ctx, vt)
+	if err != nil {
+		return err
+	}
+
+	err = d.resetVerificationAttempts(ctx, vt.Scope, vt.Email)
+	if err != nil {
+		return err
+	}
+
+	if mail == nil {
+		return nil
+	}
+
+	job, err := newMailJob(mail, vt.Token)
+	if err != nil {
+		return err
+	}
+
+	return d.enqueueJob(ctx, job)
+}
+
+// Resends are serialized by the write lock of the transaction, where
+// the Postgres model takes an advisory lock.
+func (m sqliteVerificationTokenModel) Resend(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error) {
+	policy := m.policies.Get(scope)
+
+	t, err := generateToken(policy.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	vt := &VerificationToken{
+		Scope:  scope,
+		Email:  email,
+		UserID: userID,
+		Token:  t,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM verification_token_
+		WHERE scope_ = $1
+		AND email_ = $2`
+
+	args := []any{scope, email}
+
+	if userID != nil {
+		query += `
+		AND user_id_ = $3`
+		args = append(args, *userID)
+	}
+	query += `
+		RETURNING created_at_;`
+
+	rows, err := tx.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	createdAts := []time.Time{}
+	for rows.Next() {
+		var createdAt time.Time
+
+		err := rows.Scan(&createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		createdAts = append(createdAts, createdAt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, createdAt := range createdAts {
+		if time.Since(createdAt) < policy.Cooldown {
+			return nil, ErrCooldown
+		}
+	}
+
+	err = tx.issueVerificationToken(ctx, vt, mail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m sqliteVerificationTokenModel) PurgeWithEmail(ctx context.Context, email string) error {
+	query := `
+		DELETE FROM verification_token_
+		WHERE email_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, email)
+	return err
+}
+
+func (m sqliteVerificationTokenModel) PurgeWithScope(ctx context.Context, scope, email string) error {
+	query := `
+		DELETE FROM verification_token_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	args := []any{scope, email}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, args...)
+	return err
+}
+
+// Delete the user's verification tokens, keeping email revert tokens
+// as the Postgres model does.
+func (m sqliteVerificationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM verification_token_
+		WHERE user_id_ = $1
+		AND scope_ <> $2;`
+
+	args := []any{userID, ScopeEmailRevert}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, args...)
+	return err
+}
+
+func (m sqliteVerificationTokenModel) PurgeWithScopeUserID(ctx context.Context, scope string, userID uuid.UUID) error {
+	query := `
+		DELETE FROM verification_token_
+		WHERE scope_ = $1
+		AND user_id_ = $2;`
+
+	args := []any{scope, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, args...)
+	return err
+}
+
+func (m sqliteVerificationTokenModel) Verify(ctx context.Context, token, scope, email string, userID *uuid.UUID) error {
+	var expiry time.Time
+
+	query := `
+		SELECT expiry_
+		FROM verification_token_
+		WHERE hash_ = $1
+		AND scope_ = $2
+		AND email_ = $3`
+
+	hash := generateHash(token)
+	args := []any{hash, scope, email}
+
+	if userID != nil {
+		query += `
+		AND user_id_ = $4`
+		args = append(args, *userID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	window := m.policies.Get(scope).TTL
+
+	err := m.db.checkVerificationAttempts(ctx, scope, email, window)
+	if err != nil {
+		return err
+	}
+
+	err = m.db.queryRow(ctx, query, args...).Scan(&expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return m.db.failVerification(ctx, scope, email, window)
+		default:
+			return err
+		}
+	}
+
+	if time.Now().After(expiry) {
+		return ErrExpiredToken
+	}
+
+	return m.db.resetVerificationAttempts(ctx, scope, email)
+}
+
+func (d sqliteDB) checkVerificationAttempts(ctx context.Context, scope, email string, window time.Duration) error {
+	var exceeded bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM verification_attempt_
+			WHERE scope_ = $1
+			AND email_ = $2
+			AND failures_ >= $3
+			AND last_failure_at_ > $4
+		);`
+
+	args := []any{scope, email, VerificationMaxAttempts, time.Now().Add(-window)}
+
+	err := d.queryRow(ctx, query, args...).Scan(&exceeded)
+	if err != nil {
+		return err
+	}
+
+	if exceeded {
+		return ErrTooManyAttempts
+	}
+
+	return nil
+}
+
+func (d sqliteDB) failVerification(ctx context.Context, scope, email string, window time.Duration) error {
+	var failures int
+
+	query := `
+		INSERT INTO verification_attempt_ (scope_, email_, failures_, last_failure_at_)
+		VALUES($1, $2, 1, $4)
+		ON CONFLICT (scope_, email_) DO UPDATE
+		SET failures_ = CASE
+			WHEN verification_attempt_.last_failure_at_ > $3
+			THEN verification_attempt_.failures_ + 1
+			ELSE 1
+		END,
+		last_failure_at_ = $4
+		RETURNING failures_;`
+
+	now := time.Now()
+	args := []any{scope, email, now.Add(-window), now}
+
+	err := d.queryRow(ctx, query, args...).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	if failures < VerificationMaxAttempts {
+		return ErrRecordNotFound
+	}
+
+	query = `
+		DELETE FROM verification_token_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	_, err = d.exec(ctx, query, scope, email)
+	if err != nil {
+		return err
+	}
+
+	return ErrTooManyAttempts
+}
+
+func (d sqliteDB) resetVerificationAttempts(ctx context.Context, scope, email string) error {
+	query := `
+		DELETE FROM verification_attempt_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	_, err := d.exec(ctx, query, scope, email)
+	return err
+}
+
+type sqliteLoginAttemptModel struct {
+	db sqliteDB
+}
+
+// Reserve a login attempt as in LoginAttemptModel. Transactions take
+// the write lock immediately, which serializes reservations.
+func (m sqliteLoginAttemptModel) Reserve(ctx context.Context, scope, email string) (*LoginAttempt, time.Duration, error) {
+	a := LoginAttempt{Scope: scope, Email: email}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.begin(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT failures_, last_failure_at_, locked_until_
+		FROM login_attempt_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	err = tx.queryRow(ctx, query, scope, email).Scan(&a.Failures, &a.LastFailureAt, &a.LockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, err
+	}
+
+	if wait := a.reserve(time.Now()); wait > 0 {
+		return &a, wait, nil
+	}
+
+	err = sqliteLoginAttemptModel{tx.sqliteDB}.Insert(ctx, &a)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &a, 0, nil
+}
+
+func (m sqliteLoginAttemptModel) Insert(ctx context.Context, a *LoginAttempt) error {
+	query := `
+		INSERT INTO login_attempt_ (scope_, email_, failures_, last_failure_at_, locked_until_)
+		VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT (scope_, email_) DO UPDATE
+		SET failures_ = EXCLUDED.failures_,
+		last_failure_at_ = EXCLUDED.last_failure_at_,
+		locked_until_ = EXCLUDED.locked_until_;`
+
+	args := []any{a.Scope, a.Email, a.Failures, a.LastFailureAt, a.LockedUntil}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, args...)
+	return err
+}
+
+func (m sqliteLoginAttemptModel) Reset(ctx context.Context, scope, email string) error {
+	query := `
+		DELETE FROM login_attempt_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, scope, email)
+	return err
+}
+
+type sqliteJobModel struct {
+	db sqliteDB
+}
+
+func (d sqliteDB) enqueueJob(ctx context.Context, job *Job) error {
+	err := job.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO job_ (kind_, payload_, max_attempts_)
+		VALUES($1, $2, $3);`
+
+	args := []any{job.Kind, []byte(job.Payload), job.MaxAttempts}
+
+	_, err = d.exec(ctx, query, args...)
+	return err
+}
+
+// Claim the next job that is due, as in JobModel. The update runs as
+// one statement under the write lock, so no two workers claim the
+// same job.
+func (m sqliteJobModel) Claim(ctx context.Context) (*Job, error) {
+	var j Job
+	var payload []byte
+
+	query := `
+		UPDATE job_
+		SET status_ = $1, locked_until_ = NULL, last_error_ = $2,
+		payload_ = json_remove(CAST(payload_ AS TEXT), '$.data.token')
+		WHERE status_ = $3
+		AND locked_until_ < $4
+		AND attempts_ >= max_attempts_;`
+
+	now := time.Now()
+	args := []any{JobStatusDead, JobLostMessage, JobStatusRunning, now}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE job_
+		SET status_ = $1, attempts_ = attempts_ + 1, locked_until_ = $2
+		WHERE id_ = (
+			SELECT id_
+			FROM job_
+			WHERE (status_ = $3 AND run_at_ <= $4)
+			OR (status_ = $1 AND locked_until_ < $4 AND attempts_ < max_attempts_)
+			ORDER BY run_at_
+			LIMIT 1
+		)
+		RETURNING id_, kind_, payload_, attempts_, max_attempts_;`
+
+	args = []any{JobStatusRunning, now.Add(JobLease), JobStatusPending, now}
+
+	err = m.db.queryRow(ctx, query, args...).Scan(
+		&j.ID,
+		&j.Kind,
+		&payload,
+		&j.Attempts,
+		&j.MaxAttempts,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	j.Payload = json.RawMessage(payload)
+
+	return &j, nil
+}
+
+func (m sqliteJobModel) Complete(ctx context.Context, job *Job) error {
+	query := `
+		DELETE FROM job_
+		WHERE id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, job.ID)
+	return err
+}
+
+func (m sqliteJobModel) Fail(ctx context.Context, job *Job, jobErr error) error {
+	status := JobStatusPending
+	if job.Attempts >= job.MaxAttempts {
+		status = JobStatusDead
+	}
+
+	query := `
+		UPDATE job_
+		SET status_ = $1, run_at_ = $2, locked_until_ = NULL, last_error_ = $3,
+		payload_ = CASE WHEN $1 = $5 THEN json_remove(CAST(payload_ AS TEXT), '$.data.token') ELSE payload_ END
+		WHERE id_ = $4;`
+
+	args := []any{status, time.Now().Add(JobBackoff(job.Attempts)), jobErr.Error(), job.ID, JobStatusDead}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, args...)
+	return err
+}
+
+type sqliteCleanupModel struct {
+	db sqliteDB
+}
+
+// Delete expired rows in batches, under the same conditions as the
+// Postgres cleanup. There is no other process to share the work with,
+// so ok is always true.
+func (m sqliteCleanupModel) DeleteExpired(ctx context.Context) (map[string]int64, bool, error) {
+	deleted := make(map[string]int64, len(cleanupTables))
+
+	for _, t := range cleanupTables {
+		query := fmt.Sprintf(`
+			DELETE FROM %[1]s
+			WHERE (%[2]s) IN (
+				SELECT %[2]s
+				FROM %[1]s
+				WHERE %[3]s
+				LIMIT $1
+			);`, t.name, t.key, t.condition)
+
+		for {
+			batchCtx, cancel := context.WithTimeout(ctx, ctxTimeout)
+			result, err := m.db.exec(batchCtx, query, CleanupBatchSize, time.Now().Add(-t.retention))
+			cancel()
+			if err != nil {
+				return deleted, true, err
+			}
+
+			rows, err := result.RowsAffected()
+			if err != nil {
+				return deleted, true, err
+			}
+
+			deleted[t.name] += rows
+
+			if rows < CleanupBatchSize {
+				break
+			}
+		}
+	}
+
+	return deleted, true, nil
+}
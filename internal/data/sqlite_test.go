@@ -0,0 +1,30 @@
+package data
+
+import (
+	"context"
+	"testing"
+)
+
+// NOCASE folds ASCII letters only, unlike citext and the memory models.
+func TestSQLiteEmailCaseASCII(t *testing.T) {
+	m := openSQLite(t)
+	ctx := context.Background()
+
+	newUser(t, m, "élodie@example.com")
+
+	exists, err := m.User.ExistsWithEmail(ctx, "élodie@EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("email differing in ASCII case does not exist")
+	}
+
+	exists, err = m.User.ExistsWithEmail(ctx, "Élodie@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("email differing in non-ASCII case exists")
+	}
+}
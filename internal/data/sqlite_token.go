@@ -0,0 +1,1305 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/micahco/api/internal/paseto"
+	"github.com/micahco/api/internal/totp"
+)
+
+type sqliteAuthenticationTokenModel struct {
+	db   sqliteDB
+	keys *paseto.KeyRing
+}
+
+func (m sqliteAuthenticationTokenModel) Signed() bool {
+	return m.keys != nil
+}
+
+func (m sqliteAuthenticationTokenModel) New(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error) {
+	var t *Token
+	var err error
+
+	if m.Signed() {
+		t, err = generateSignedToken(m.keys, user, familyID, authenticatedAt)
+	} else {
+		t, err = generateToken(AuthenticationTokenTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at := &AuthenticationToken{user.ID, familyID, authenticatedAt, device, t}
+
+	err = m.Insert(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m sqliteAuthenticationTokenModel) Rotate(ctx context.Context, user *User, familyID uuid.UUID, authenticatedAt time.Time, device Device) (*Token, error) {
+	var t *Token
+	var err error
+
+	if m.Signed() {
+		t, err = generateSignedToken(m.keys, user, familyID, authenticatedAt)
+	} else {
+		t, err = generateToken(AuthenticationTokenTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at := &AuthenticationToken{user.ID, familyID, authenticatedAt, device, t}
+
+	err = at.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE authentication_token_
+		SET hash_ = $1, expiry_ = $2, authenticated_at_ = $3, last_used_at_ = $9,
+		ip_ = $4, user_agent_ = $5, device_name_ = COALESCE(NULLIF($6, ''), device_name_)
+		WHERE family_id_ = $7
+		AND user_id_ = $8;`
+
+	args := []any{
+		at.Hash,
+		at.Expiry,
+		at.AuthenticatedAt,
+		at.Device.IP,
+		at.Device.UserAgent,
+		at.Device.Name,
+		at.FamilyID,
+		at.UserID,
+		time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return t, nil
+}
+
+func (m sqliteAuthenticationTokenModel) ParseSigned(token string) (*AuthenticationClaims, error) {
+	return parseSignedToken(m.keys, token)
+}
+
+func (m sqliteAuthenticationTokenModel) Insert(ctx context.Context, t *AuthenticationToken) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO authentication_token_ (hash_, expiry_, user_id_, family_id_,
+		authenticated_at_, ip_, user_agent_, device_name_)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8);`
+
+	args := []any{
+		t.Hash,
+		t.Expiry,
+		t.UserID,
+		t.FamilyID,
+		t.AuthenticatedAt,
+		t.Device.IP,
+		t.Device.UserAgent,
+		t.Device.Name,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.db.exec(ctx, query, args...)
+	return err
+}
+
+func (m sqliteAuthenticationTokenModel) Exists(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var exists bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM authentication_token_
+			WHERE user_id_ = $1
+		);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (m sqliteAuthenticationTokenModel) Delete(ctx context.Context, token string) error {
+	query := `
+		DELETE FROM authentication_token_
+		WHERE hash_ = $1
+		RETURNING family_id_;`
+
+	return m.deleteSession(ctx, query, generateHash(token))
+}
+
+// Delete the authentication token matched by query, which returns its
+// family, along with the refresh tokens in the family. SQLite has no
+// data modifying CTEs, so this takes two statements in a transaction.
+func (m sqliteAuthenticationTokenModel) deleteSession(ctx context.Context, query string, args ...any) error {
+	var familyID uuid.UUID
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.queryRow(ctx, query, args...).Scan(&familyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		DELETE FROM refresh_token_
+		WHERE family_id_ = $1;`
+
+	_, err = tx.exec(ctx, query, familyID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m sqliteAuthenticationTokenModel) PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error {
+	query := `
+		DELETE FROM authentication_token_
+		WHERE family_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, familyID)
+	return err
+}
+
+func (m sqliteAuthenticationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM authentication_token_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+func (m sqliteAuthenticationTokenModel) Verify(ctx context.Context, token string, userID uuid.UUID) error {
+	var expiry time.Time
+
+	query := `
+		SELECT expiry_
+		FROM authentication_token_
+		WHERE hash_ = $1
+		AND user_id_ = $2;`
+
+	hash := generateHash(token)
+	args := []any{hash, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, args...).Scan(&expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if time.Now().After(expiry) {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
+
+func (m sqliteAuthenticationTokenModel) GetAllForUser(ctx context.Context, userID uuid.UUID, token string) ([]*Session, error) {
+	query := `
+		SELECT id_, created_at_, last_used_at_, expiry_, ip_, user_agent_,
+		device_name_, hash_ = $2
+		FROM authentication_token_
+		WHERE user_id_ = $1
+		AND (
+			expiry_ > $3
+			OR EXISTS (
+				SELECT 1
+				FROM refresh_token_
+				WHERE refresh_token_.family_id_ = authentication_token_.family_id_
+				AND refresh_token_.used_ = FALSE
+				AND refresh_token_.expiry_ > $3
+			)
+		)
+		ORDER BY last_used_at_ DESC;`
+
+	hash := generateHash(token)
+	args := []any{userID, hash, time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var s Session
+
+		err := rows.Scan(
+			&s.ID,
+			&s.CreatedAt,
+			&s.LastUsedAt,
+			&s.Expiry,
+			&s.IP,
+			&s.UserAgent,
+			&s.DeviceName,
+			&s.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (m sqliteAuthenticationTokenModel) DeleteForUser(ctx context.Context, id, userID uuid.UUID) error {
+	query := `
+		DELETE FROM authentication_token_
+		WHERE id_ = $1
+		AND user_id_ = $2
+		RETURNING family_id_;`
+
+	return m.deleteSession(ctx, query, id, userID)
+}
+
+func (m sqliteAuthenticationTokenModel) UpdateLastUsed(ctx context.Context, token string) error {
+	query := `
+		UPDATE authentication_token_
+		SET last_used_at_ = $3
+		WHERE hash_ = $1
+		AND last_used_at_ < $2;`
+
+	now := time.Now()
+	hash := generateHash(token)
+	args := []any{hash, now.Add(-lastUsedInterval), now}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, args...)
+	return err
+}
+
+type sqliteRefreshTokenModel struct {
+	db sqliteDB
+}
+
+func (m sqliteRefreshTokenModel) New(ctx context.Context, userID, familyID uuid.UUID, authenticatedAt time.Time) (*Token, error) {
+	t, err := generateToken(RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &RefreshToken{userID, familyID, authenticatedAt, t}
+
+	err = m.Insert(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m sqliteRefreshTokenModel) Insert(ctx context.Context, t *RefreshToken) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO refresh_token_ (hash_, expiry_, family_id_, authenticated_at_, user_id_)
+		VALUES($1, $2, $3, $4, $5);`
+
+	args := []any{t.Hash, t.Expiry, t.FamilyID, t.AuthenticatedAt, t.UserID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.db.exec(ctx, query, args...)
+	return err
+}
+
+// Mark the refresh token as used and return it, revoking the family
+// if it was already used. The transaction's write lock stands in for
+// the Postgres row lock.
+func (m sqliteRefreshTokenModel) Use(ctx context.Context, token string) (*RefreshToken, error) {
+	rt := &RefreshToken{Token: &Token{}}
+	var used bool
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT user_id_, family_id_, authenticated_at_, expiry_, used_
+		FROM refresh_token_
+		WHERE hash_ = $1;`
+
+	hash := generateHash(token)
+
+	err = tx.queryRow(ctx, query, hash).Scan(
+		&rt.UserID,
+		&rt.FamilyID,
+		&rt.AuthenticatedAt,
+		&rt.Expiry,
+		&used,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if used {
+		err = tx.purgeFamily(ctx, rt.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrReusedToken
+	}
+
+	if time.Now().After(rt.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	query = `
+		UPDATE refresh_token_
+		SET used_ = TRUE
+		WHERE hash_ = $1;`
+
+	_, err = tx.exec(ctx, query, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	rt.Hash = hash
+
+	return rt, nil
+}
+
+func (m sqliteRefreshTokenModel) PurgeWithFamilyID(ctx context.Context, familyID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.db.purgeFamily(ctx, familyID)
+}
+
+func (m sqliteRefreshTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM refresh_token_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+func (d sqliteDB) purgeFamily(ctx context.Context, familyID uuid.UUID) error {
+	query := `
+		DELETE FROM refresh_token_
+		WHERE family_id_ = $1;`
+
+	_, err := d.exec(ctx, query, familyID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM authentication_token_
+		WHERE family_id_ = $1;`
+
+	_, err = d.exec(ctx, query, familyID)
+	return err
+}
+
+type sqlitePersonalAccessTokenModel struct {
+	db sqliteDB
+}
+
+func (m sqlitePersonalAccessTokenModel) New(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiry time.Time) (*PersonalAccessToken, error) {
+	t, err := generatePrefixedToken(PersonalAccessTokenPrefix, time.Until(expiry))
+	if err != nil {
+		return nil, err
+	}
+
+	pat := &PersonalAccessToken{
+		UserID: userID,
+		Name:   name,
+		Scopes: scopes,
+		Token:  t,
+	}
+
+	err = m.Insert(ctx, pat)
+	if err != nil {
+		return nil, err
+	}
+
+	return pat, nil
+}
+
+// Scopes are stored as a JSON array
+func (m sqlitePersonalAccessTokenModel) Insert(ctx context.Context, pat *PersonalAccessToken) error {
+	err := pat.Validate()
+	if err != nil {
+		return err
+	}
+
+	scopes, err := json.Marshal(pat.Scopes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO personal_access_token_ (hash_, user_id_, name_, scopes_, expiry_)
+		VALUES($1, $2, $3, $4, $5)
+		RETURNING id_, created_at_;`
+
+	args := []any{pat.Hash, pat.UserID, pat.Name, string(scopes), pat.Expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return m.db.queryRow(ctx, query, args...).Scan(&pat.ID, &pat.CreatedAt)
+}
+
+func (m sqlitePersonalAccessTokenModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*PersonalAccessToken, error) {
+	query := `
+		SELECT id_, user_id_, name_, scopes_, created_at_, last_used_at_, expiry_
+		FROM personal_access_token_
+		WHERE user_id_ = $1
+		AND expiry_ > $2
+		ORDER BY created_at_ DESC;`
+
+	args := []any{userID, time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		pat := PersonalAccessToken{Token: &Token{}}
+		var scopes string
+
+		err := rows.Scan(
+			&pat.ID,
+			&pat.UserID,
+			&pat.Name,
+			&scopes,
+			&pat.CreatedAt,
+			&pat.LastUsedAt,
+			&pat.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(scopes), &pat.Scopes)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &pat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m sqlitePersonalAccessTokenModel) DeleteForUser(ctx context.Context, id, userID uuid.UUID) error {
+	query := `
+		DELETE FROM personal_access_token_
+		WHERE id_ = $1
+		AND user_id_ = $2;`
+
+	args := []any{id, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m sqlitePersonalAccessTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM personal_access_token_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+// Get the user and granted scopes for a plaintext personal access
+// token, recording its use. RETURNING cannot reach the joined user in
+// SQLite, so the user is read after the update.
+func (m sqlitePersonalAccessTokenModel) GetForToken(ctx context.Context, token string) (*User, []string, error) {
+	var userID uuid.UUID
+	var scopes []string
+	var encodedScopes string
+
+	query := `
+		UPDATE personal_access_token_
+		SET last_used_at_ = $2
+		WHERE hash_ = $1
+		AND expiry_ > $2
+		RETURNING user_id_, scopes_;`
+
+	hash := generateHash(token)
+	args := []any{hash, time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, args...).Scan(&userID, &encodedScopes)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, m.missingToken(ctx, hash)
+		default:
+			return nil, nil, err
+		}
+	}
+
+	err = json.Unmarshal([]byte(encodedScopes), &scopes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err := sqliteUserModel{db: m.db}.Get(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, scopes, nil
+}
+
+// Error for a token hash that matched no usable token: ErrExpiredToken
+// if the token exists but has expired, ErrRecordNotFound otherwise.
+func (m sqlitePersonalAccessTokenModel) missingToken(ctx context.Context, hash []byte) error {
+	var exists bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM personal_access_token_
+			WHERE hash_ = $1
+		);`
+
+	err := m.db.queryRow(ctx, query, hash).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrExpiredToken
+	}
+
+	return ErrRecordNotFound
+}
+
+type sqliteTOTPModel struct {
+	db sqliteDB
+}
+
+func (m sqliteTOTPModel) New(ctx context.Context, userID uuid.UUID) (*TOTP, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TOTP{
+		UserID: userID,
+		Secret: secret,
+	}
+
+	query := `
+		INSERT INTO totp_ (user_id_, secret_)
+		VALUES($1, $2)
+		ON CONFLICT (user_id_) DO UPDATE
+		SET secret_ = EXCLUDED.secret_, last_counter_ = 0
+		WHERE totp_.confirmed_ = FALSE;`
+
+	args := []any{t.UserID, t.Secret}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return nil, ErrMFAEnabled
+	}
+
+	return t, nil
+}
+
+func (m sqliteTOTPModel) Get(ctx context.Context, userID uuid.UUID) (*TOTP, error) {
+	t := TOTP{UserID: userID}
+
+	query := `
+		SELECT secret_, confirmed_, last_counter_
+		FROM totp_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, userID).Scan(&t.Secret, &t.Confirmed, &t.LastCounter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+func (m sqliteTOTPModel) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var exists bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM totp_
+			WHERE user_id_ = $1
+			AND confirmed_ = TRUE
+		);`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (m sqliteTOTPModel) Verify(ctx context.Context, t *TOTP, code string) error {
+	counter, ok := totp.Validate(t.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	query := `
+		UPDATE totp_
+		SET last_counter_ = $2
+		WHERE user_id_ = $1
+		AND last_counter_ < $2;`
+
+	args := []any{t.UserID, int64(counter)}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrInvalidCode
+	}
+
+	t.LastCounter = int64(counter)
+
+	return nil
+}
+
+func (m sqliteTOTPModel) Confirm(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE totp_
+		SET confirmed_ = TRUE
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+func (m sqliteTOTPModel) Delete(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM totp_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+type sqliteRecoveryCodeModel struct {
+	db sqliteDB
+}
+
+func (m sqliteRecoveryCodeModel) New(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM recovery_code_
+		WHERE user_id_ = $1;`
+
+	_, err = tx.exec(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO recovery_code_ (hash_, user_id_)
+		VALUES($1, $2);`
+
+	for _, code := range codes {
+		_, err = tx.exec(ctx, query, generateHash(normalizeRecoveryCode(code)), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (m sqliteRecoveryCodeModel) Use(ctx context.Context, userID uuid.UUID, code string) error {
+	query := `
+		DELETE FROM recovery_code_
+		WHERE user_id_ = $1
+		AND hash_ = $2;`
+
+	args := []any{userID, generateHash(normalizeRecoveryCode(code))}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (m sqliteRecoveryCodeModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM recovery_code_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+type sqliteMFAChallengeModel struct {
+	db sqliteDB
+}
+
+func (m sqliteMFAChallengeModel) New(ctx context.Context, userID uuid.UUID, deviceName string) (*Token, error) {
+	t, err := generateToken(MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MFAChallenge{
+		UserID:     userID,
+		DeviceName: deviceName,
+		Token:      t,
+	}
+
+	err = m.Insert(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m sqliteMFAChallengeModel) Insert(ctx context.Context, c *MFAChallenge) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO mfa_challenge_ (hash_, expiry_, device_name_, user_id_)
+		VALUES($1, $2, $3, $4);`
+
+	args := []any{c.Hash, c.Expiry, c.DeviceName, c.UserID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.db.exec(ctx, query, args...)
+	return err
+}
+
+func (m sqliteMFAChallengeModel) Get(ctx context.Context, token string) (*MFAChallenge, error) {
+	c := MFAChallenge{Token: &Token{}}
+
+	query := `
+		SELECT user_id_, device_name_, attempts_, expiry_
+		FROM mfa_challenge_
+		WHERE hash_ = $1;`
+
+	hash := generateHash(token)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, hash).Scan(
+		&c.UserID,
+		&c.DeviceName,
+		&c.Attempts,
+		&c.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(c.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	c.Hash = hash
+
+	return &c, nil
+}
+
+func (m sqliteMFAChallengeModel) Fail(ctx context.Context, c *MFAChallenge) error {
+	query := `
+		UPDATE mfa_challenge_
+		SET attempts_ = attempts_ + 1
+		WHERE hash_ = $1
+		RETURNING attempts_;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, c.Hash).Scan(&c.Attempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if c.Attempts >= MFAChallengeMaxAttempts {
+		return m.Delete(ctx, c)
+	}
+
+	return nil
+}
+
+func (m sqliteMFAChallengeModel) Delete(ctx context.Context, c *MFAChallenge) error {
+	query := `
+		DELETE FROM mfa_challenge_
+		WHERE hash_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, c.Hash)
+	return err
+}
+
+func (m sqliteMFAChallengeModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM mfa_challenge_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+type sqliteWebAuthnCredentialModel struct {
+	db sqliteDB
+}
+
+func (m sqliteWebAuthnCredentialModel) Insert(ctx context.Context, c *WebAuthnCredential) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO webauthn_credential_ (id_, user_id_, name_, data_)
+		VALUES($1, $2, $3, $4)
+		RETURNING created_at_;`
+
+	args := []any{c.ID, c.UserID, c.Name, c.Data}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err = m.db.queryRow(ctx, query, args...).Scan(&c.CreatedAt)
+	if err != nil {
+		switch {
+		case sqliteUniqueViolation(err):
+			return ErrDuplicateCredential
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m sqliteWebAuthnCredentialModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*WebAuthnCredential, error) {
+	query := `
+		SELECT id_, user_id_, name_, data_, created_at_, last_used_at_
+		FROM webauthn_credential_
+		WHERE user_id_ = $1
+		ORDER BY created_at_;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []*WebAuthnCredential{}
+	for rows.Next() {
+		var c WebAuthnCredential
+
+		err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.Name,
+			&c.Data,
+			&c.CreatedAt,
+			&c.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func (m sqliteWebAuthnCredentialModel) UpdateForLogin(ctx context.Context, id, data []byte) error {
+	query := `
+		UPDATE webauthn_credential_
+		SET data_ = $2, last_used_at_ = $3
+		WHERE id_ = $1;`
+
+	args := []any{id, data, time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m sqliteWebAuthnCredentialModel) DeleteForUser(ctx context.Context, id []byte, userID uuid.UUID) error {
+	query := `
+		DELETE FROM webauthn_credential_
+		WHERE id_ = $1
+		AND user_id_ = $2;`
+
+	args := []any{id, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m sqliteWebAuthnCredentialModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		DELETE FROM webauthn_credential_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.exec(ctx, query, userID)
+	return err
+}
+
+type sqliteWebAuthnSessionModel struct {
+	db sqliteDB
+}
+
+func (m sqliteWebAuthnSessionModel) New(ctx context.Context, userID *uuid.UUID, data []byte) (*Token, error) {
+	t, err := generateToken(WebAuthnSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &WebAuthnSession{
+		UserID: userID,
+		Data:   data,
+		Token:  t,
+	}
+
+	err = m.Insert(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m sqliteWebAuthnSessionModel) Insert(ctx context.Context, s *WebAuthnSession) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO webauthn_session_ (hash_, expiry_, data_, user_id_)
+		VALUES($1, $2, $3, $4);`
+
+	args := []any{s.Hash, s.Expiry, s.Data, s.UserID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.db.exec(ctx, query, args...)
+	return err
+}
+
+// Get and delete the session for the plaintext token. IS compares
+// user ids as IS NOT DISTINCT FROM does in Postgres.
+func (m sqliteWebAuthnSessionModel) Take(ctx context.Context, token string, userID *uuid.UUID) (*WebAuthnSession, error) {
+	s := WebAuthnSession{Token: &Token{}}
+
+	query := `
+		DELETE FROM webauthn_session_
+		WHERE hash_ = $1
+		AND user_id_ IS $2
+		RETURNING user_id_, data_, expiry_;`
+
+	hash := generateHash(token)
+	args := []any{hash, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.queryRow(ctx, query, args...).Scan(&s.UserID, &s.Data, &s.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(s.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	s.Hash = hash
+
+	return &s, nil
+}
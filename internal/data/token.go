@@ -10,19 +10,25 @@ import (
 const TokenSize = 16
 
 type Token struct {
-	Plaintext string    `json:"token"`
+	Plaintext string    `json:"token,omitempty"`
 	Hash      []byte    `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 }
 
 func generateToken(ttl time.Duration) (*Token, error) {
+	return generatePrefixedToken("", ttl)
+}
+
+// Generate a token whose plaintext starts with prefix, so the kind
+// of token can be recognised before it is looked up.
+func generatePrefixedToken(prefix string, ttl time.Duration) (*Token, error) {
 	randomBytes := make([]byte, TokenSize)
 	_, err := rand.Read(randomBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	str := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	str := prefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
 	t := &Token{
 		Plaintext: str,
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+func TestPersonalAccessTokenGetForToken(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		pat, err := m.PersonalAccessToken.New(ctx, alice.ID, "ci", []string{TokenScopeUserRead}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user, scopes, err := m.PersonalAccessToken.GetForToken(ctx, pat.Plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != alice.ID || len(scopes) != 1 || scopes[0] != TokenScopeUserRead {
+			t.Errorf("got user %s with scopes %v", user.ID, scopes)
+		}
+
+		expired, err := m.PersonalAccessToken.New(ctx, alice.ID, "old", []string{TokenScopeUserRead}, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = m.PersonalAccessToken.GetForToken(ctx, expired.Plaintext)
+		if !errors.Is(err, ErrExpiredToken) {
+			t.Errorf("expired: got error %v; want %v", err, ErrExpiredToken)
+		}
+
+		_, _, err = m.PersonalAccessToken.GetForToken(ctx, "unknown")
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("unknown: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		familyID, err := uuid.NewV4()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := m.RefreshToken.New(ctx, alice.ID, familyID, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rt, err := m.RefreshToken.Use(ctx, token.Plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.UserID != alice.ID || rt.FamilyID != familyID {
+			t.Errorf("got user %s in family %s", rt.UserID, rt.FamilyID)
+		}
+
+		_, err = m.RefreshToken.Use(ctx, token.Plaintext)
+		if !errors.Is(err, ErrReusedToken) {
+			t.Errorf("reused: got error %v; want %v", err, ErrReusedToken)
+		}
+
+		_, err = m.RefreshToken.Use(ctx, "unknown")
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("unknown: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
@@ -11,11 +11,13 @@ import (
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type UserModel struct {
-	pool *pgxpool.Pool
+	db       dbtx
+	cache    *userCache
+	evicted  *[]uuid.UUID
+	policies VerificationPolicies
 }
 
 type User struct {
@@ -23,6 +25,7 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	Email        string    `json:"email"`
 	PasswordHash []byte    `json:"-"`
+	Locale       string    `json:"locale"`
 	Version      int       `json:"-"`
 }
 
@@ -49,7 +52,23 @@ func (u *User) SetPasswordHash(password string) error {
 	return nil
 }
 
-func (m UserModel) New(email, password string) (*User, error) {
+// Replace the password with a random one nobody knows, so the user
+// must reset their password before logging in again.
+func (u *User) SetRandomPassword() error {
+	t, err := generateToken(0)
+	if err != nil {
+		return err
+	}
+
+	return u.SetPasswordHash(t.Plaintext)
+}
+
+// Reports whether password matches the user's password hash.
+func (u *User) MatchesPassword(password string) (bool, error) {
+	return argon2id.ComparePasswordAndHash(password, string(u.PasswordHash))
+}
+
+func (m UserModel) New(ctx context.Context, email, password string) (*User, error) {
 	user := &User{Email: email}
 
 	err := user.SetPasswordHash(password)
@@ -57,7 +76,7 @@ func (m UserModel) New(email, password string) (*User, error) {
 		return nil, err
 	}
 
-	err = m.Insert(user)
+	err = m.Insert(ctx, user)
 	if err != nil {
 		return nil, err
 	}
@@ -65,23 +84,23 @@ func (m UserModel) New(email, password string) (*User, error) {
 	return user, nil
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	err := user.Validate()
 	if err != nil {
 		return err
 	}
 
 	sql := `
-		INSERT INTO user_ (email_, password_hash_)
-		VALUES($1, $2)
+		INSERT INTO user_ (email_, password_hash_, locale_)
+		VALUES($1, $2, $3)
 		RETURNING id_, created_at_, version_;`
 
-	args := []any{user.Email, user.PasswordHash}
+	args := []any{user.Email, user.PasswordHash, user.Locale}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err = m.pool.QueryRow(ctx, sql, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err = m.db.QueryRow(ctx, sql, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case pgErrCode(err) == pgerrcode.UniqueViolation:
@@ -94,21 +113,22 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForCredentials(email, password string) (*User, error) {
+func (m UserModel) GetForCredentials(ctx context.Context, email, password string) (*User, error) {
 	var u User
 
 	sql := `
-		SELECT id_, created_at_, email_, password_hash_, version_
+		SELECT id_, created_at_, email_, password_hash_, locale_, version_
 		FROM user_ WHERE email_ = $1;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, email).Scan(
+	err := m.db.QueryRow(ctx, sql, email).Scan(
 		&u.ID,
 		&u.CreatedAt,
 		&u.Email,
 		&u.PasswordHash,
+		&u.Locale,
 		&u.Version,
 	)
 	if err != nil {
@@ -131,13 +151,99 @@ func (m UserModel) GetForCredentials(email, password string) (*User, error) {
 	return &u, nil
 }
 
-func (m UserModel) GetForAuthenticationToken(token string) (*User, error) {
+func (m UserModel) Get(ctx context.Context, id uuid.UUID) (*User, error) {
+	var u User
+
+	sql := `
+		SELECT id_, created_at_, email_, password_hash_, locale_, version_
+		FROM user_ WHERE id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, id).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &u, nil
+}
+
+func (m UserModel) GetForEmail(ctx context.Context, email string) (*User, error) {
+	var u User
+
+	sql := `
+		SELECT id_, created_at_, email_, password_hash_, locale_, version_
+		FROM user_ WHERE email_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, email).Scan(
+		&u.ID,
+		&u.CreatedAt,
+		&u.Email,
+		&u.PasswordHash,
+		&u.Locale,
+		&u.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &u, nil
+}
+
+// Get the user for verified signed token claims. The user is served
+// from cache while its version matches the claims, otherwise it is
+// read from the database. Claims for another version of the user
+// return ErrStaleToken, and the token must be checked against its
+// recorded session instead.
+func (m UserModel) GetForAuthenticationClaims(ctx context.Context, claims *AuthenticationClaims) (*User, error) {
+	if u, ok := m.cache.get(claims.UserID); ok && u.Version == claims.Version {
+		return u, nil
+	}
+
+	u, err := m.Get(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache.set(u)
+
+	if u.Version != claims.Version {
+		return nil, ErrStaleToken
+	}
+
+	return u, nil
+}
+
+// Get the user and authentication token for a plaintext opaque token.
+func (m UserModel) GetForAuthenticationToken(ctx context.Context, token string) (*User, *AuthenticationToken, error) {
 	var u User
-	var expiry time.Time
+	at := AuthenticationToken{Token: &Token{Plaintext: token}}
 
 	sql := `
 		SELECT user_.id_, user_.created_at_, user_.email_, user_.password_hash_, 
-		user_.version_, authentication_token_.expiry_
+		user_.locale_, user_.version_, authentication_token_.family_id_,
+		authentication_token_.authenticated_at_, authentication_token_.expiry_
 		FROM user_
 		INNER JOIN authentication_token_
 		ON user_.id_ = authentication_token_.user_id_
@@ -145,40 +251,53 @@ func (m UserModel) GetForAuthenticationToken(token string) (*User, error) {
 
 	hash := generateHash(token)
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, hash).Scan(
+	err := m.db.QueryRow(ctx, sql, hash).Scan(
 		&u.ID,
 		&u.CreatedAt,
 		&u.Email,
 		&u.PasswordHash,
+		&u.Locale,
 		&u.Version,
-		&expiry,
+		&at.FamilyID,
+		&at.AuthenticatedAt,
+		&at.Expiry,
 	)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return nil, nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	if time.Now().After(expiry) {
-		return nil, ErrExpiredToken
+	if time.Now().After(at.Expiry) {
+		return nil, nil, ErrExpiredToken
 	}
 
-	return &u, nil
+	at.UserID = u.ID
+	at.Hash = hash
+
+	return &u, &at, nil
 }
 
-func (m UserModel) GetForVerificationToken(scope, token string) (*User, error) {
+// Get the user and verification token for a plaintext token of scope.
+// The token is looked up by its hash, so clients need not send the
+// email it was mailed to. Failures without a matching token cannot be
+// counted against an email, and are left to the token's size and the
+// rate limiter. A matching token is still rejected while its email has
+// used up its attempts.
+func (m UserModel) GetForVerificationToken(ctx context.Context, scope, token string) (*User, *VerificationToken, error) {
 	var u User
-	var expiry time.Time
+	vt := VerificationToken{Scope: scope, Token: &Token{Plaintext: token}}
 
 	sql := `
 		SELECT user_.id_, user_.created_at_, user_.email_, user_.password_hash_, 
-		user_.version_, verification_token_.expiry_
+		user_.locale_, user_.version_, verification_token_.email_,
+		verification_token_.expiry_
 		FROM user_
 		INNER JOIN verification_token_
 		ON user_.id_ = verification_token_.user_id_
@@ -188,34 +307,49 @@ func (m UserModel) GetForVerificationToken(scope, token string) (*User, error) {
 	hash := generateHash(token)
 	args := []any{scope, hash}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, args...).Scan(
+	err := m.db.QueryRow(ctx, sql, args...).Scan(
 		&u.ID,
 		&u.CreatedAt,
 		&u.Email,
 		&u.PasswordHash,
+		&u.Locale,
 		&u.Version,
-		&expiry,
+		&vt.Email,
+		&vt.Expiry,
 	)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return nil, nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	if time.Now().After(expiry) {
-		return nil, ErrExpiredToken
+	err = checkVerificationAttempts(ctx, m.db, scope, vt.Email, m.policies.Get(scope).TTL)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return &u, nil
+	if time.Now().After(vt.Expiry) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	err = resetVerificationAttempts(ctx, m.db, scope, vt.Email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vt.UserID = &u.ID
+	vt.Hash = hash
+
+	return &u, &vt, nil
 }
 
-func (m UserModel) ExistsWithEmail(email string) (bool, error) {
+func (m UserModel) ExistsWithEmail(ctx context.Context, email string) (bool, error) {
 	var exists bool
 
 	sql := `
@@ -225,10 +359,10 @@ func (m UserModel) ExistsWithEmail(email string) (bool, error) {
 			WHERE email_ = $1
 		);`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, email).Scan(&exists)
+	err := m.db.QueryRow(ctx, sql, email).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -236,7 +370,10 @@ func (m UserModel) ExistsWithEmail(email string) (bool, error) {
 	return exists, nil
 }
 
-func (m UserModel) Update(user *User) error {
+// Update the user, unless it changed since it was read. The version is
+// only bumped when the email or password changes, so signed tokens
+// issued before are checked against their sessions again.
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	err := user.Validate()
 	if err != nil {
 		return err
@@ -244,21 +381,23 @@ func (m UserModel) Update(user *User) error {
 
 	sql := `
 		UPDATE user_ 
-        SET email_ = $1, password_hash_ = $2, version_ = version_ + 1
-        WHERE id_ = $3 AND version_ = $4
+        SET email_ = $1, password_hash_ = $2, locale_ = $3,
+        version_ = version_ + CASE WHEN email_ <> $1 OR password_hash_ <> $2 THEN 1 ELSE 0 END
+        WHERE id_ = $4 AND version_ = $5
         RETURNING version_`
 
 	args := []any{
 		user.Email,
 		user.PasswordHash,
+		user.Locale,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err = m.pool.QueryRow(ctx, sql, args...).Scan(&user.Version)
+	err = m.db.QueryRow(ctx, sql, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -270,5 +409,66 @@ func (m UserModel) Update(user *User) error {
 		}
 	}
 
+	m.evict(user.ID)
+
 	return nil
 }
+
+// Bump the user's version without changing it, so signed tokens issued
+// before are checked against their sessions again.
+func (m UserModel) BumpVersion(ctx context.Context, user *User) error {
+	sql := `
+		UPDATE user_
+		SET version_ = version_ + 1
+		WHERE id_ = $1 AND version_ = $2
+		RETURNING version_`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	m.evict(user.ID)
+
+	return nil
+}
+
+func (m UserModel) Delete(ctx context.Context, id uuid.UUID) error {
+	sql := `
+		DELETE FROM user_
+		WHERE id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, id)
+	if err != nil {
+		return err
+	}
+
+	m.evict(id)
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Remove the user from the cache. Inside a transaction the user is
+// evicted again when the transaction ends.
+func (m UserModel) evict(id uuid.UUID) {
+	m.cache.delete(id)
+
+	if m.evicted != nil {
+		*m.evicted = append(*m.evicted, id)
+	}
+}
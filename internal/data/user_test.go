@@ -0,0 +1,328 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUserDuplicateEmail(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		newUser(t, m, "alice@example.com")
+
+		_, err := m.User.New(ctx, "alice@example.com", "pa55word1234")
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("same email: got error %v; want %v", err, ErrDuplicateEmail)
+		}
+
+		_, err = m.User.New(ctx, "Alice@Example.COM", "pa55word1234")
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("email in another case: got error %v; want %v", err, ErrDuplicateEmail)
+		}
+
+		bob := newUser(t, m, "bob@example.com")
+		bob.Email = "ALICE@example.com"
+
+		err = m.User.Update(ctx, bob)
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("update: got error %v; want %v", err, ErrDuplicateEmail)
+		}
+	})
+}
+
+func TestUserGetForEmail(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		user, err := m.User.GetForEmail(ctx, "ALICE@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != alice.ID {
+			t.Errorf("got user %s; want %s", user.ID, alice.ID)
+		}
+
+		_, err = m.User.GetForEmail(ctx, "bob@example.com")
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		exists, err := m.User.ExistsWithEmail(ctx, "Alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Error("email in another case does not exist")
+		}
+	})
+}
+
+func TestUserCredentials(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		user, err := m.User.GetForCredentials(ctx, "alice@example.com", "pa55word1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != alice.ID {
+			t.Errorf("got user %s; want %s", user.ID, alice.ID)
+		}
+
+		_, err = m.User.GetForCredentials(ctx, "alice@example.com", "wrong password")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("wrong password: got error %v; want %v", err, ErrInvalidCredentials)
+		}
+
+		_, err = m.User.GetForCredentials(ctx, "bob@example.com", "pa55word1234")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("unknown email: got error %v; want %v", err, ErrInvalidCredentials)
+		}
+	})
+}
+
+func TestUserGetForVerificationToken(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		token, err := m.VerificationToken.New(ctx, ScopePasswordReset, "alice@example.com", &alice.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = m.User.GetForVerificationToken(ctx, ScopeAccountDeletion, token.Plaintext)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("other scope: got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		_, _, err = m.User.GetForVerificationToken(ctx, ScopePasswordReset, "unknown")
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("unknown: got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		user, vt, err := m.User.GetForVerificationToken(ctx, ScopePasswordReset, token.Plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != alice.ID || vt.Email != "alice@example.com" || *vt.UserID != alice.ID {
+			t.Errorf("got user %s and token for %s", user.ID, vt.Email)
+		}
+
+		// Tokens without a user cannot be used to change one
+		orphan, err := m.VerificationToken.New(ctx, ScopePasswordReset, "bob@example.com", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = m.User.GetForVerificationToken(ctx, ScopePasswordReset, orphan.Plaintext)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("without user: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
+
+func TestUserGetForVerificationTokenAttempts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		expired, err := generateToken(-time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.VerificationToken.Insert(ctx, &VerificationToken{
+			Scope:  ScopePasswordReset,
+			Email:  "alice@example.com",
+			UserID: &alice.ID,
+			Token:  expired,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = m.User.GetForVerificationToken(ctx, ScopePasswordReset, expired.Plaintext)
+		if !errors.Is(err, ErrExpiredToken) {
+			t.Errorf("expired: got error %v; want %v", err, ErrExpiredToken)
+		}
+
+		// Using up the attempts for the email deletes its tokens
+		token, err := m.VerificationToken.New(ctx, ScopePasswordReset, "alice@example.com", &alice.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for range VerificationMaxAttempts {
+			m.VerificationToken.Verify(ctx, "wrong", ScopePasswordReset, "alice@example.com", &alice.ID)
+		}
+
+		_, _, err = m.User.GetForVerificationToken(ctx, ScopePasswordReset, token.Plaintext)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("used up: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
+
+func TestUserEditConflict(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		first, err := m.User.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := m.User.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first.Locale = "fr"
+		err = first.SetPasswordHash("n3w pa55word")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.User.Update(ctx, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Version != second.Version+1 {
+			t.Errorf("got version %d; want %d", first.Version, second.Version+1)
+		}
+
+		second.Locale = "es"
+		err = m.User.Update(ctx, second)
+		if !errors.Is(err, ErrEditConflict) {
+			t.Errorf("stale update: got error %v; want %v", err, ErrEditConflict)
+		}
+
+		user, err := m.User.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Locale != "fr" || user.Version != first.Version {
+			t.Errorf("got locale %q at version %d; want %q at version %d", user.Locale, user.Version, "fr", first.Version)
+		}
+	})
+}
+
+func TestUserVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		user := newUser(t, m, "alice@example.com")
+		version := user.Version
+
+		// Preferences leave signed tokens alone
+		user.Locale = "fr"
+		err := m.User.Update(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Version != version {
+			t.Errorf("locale: got version %d; want %d", user.Version, version)
+		}
+
+		user.Email = "ALICE@example.com"
+		err = m.User.Update(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Version != version {
+			t.Errorf("email in another case: got version %d; want %d", user.Version, version)
+		}
+
+		user.Email = "alice@example.org"
+		err = m.User.Update(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version++
+		if user.Version != version {
+			t.Errorf("email: got version %d; want %d", user.Version, version)
+		}
+
+		err = user.SetPasswordHash("n3w pa55word")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.User.Update(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version++
+		if user.Version != version {
+			t.Errorf("password: got version %d; want %d", user.Version, version)
+		}
+
+		err = m.User.BumpVersion(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version++
+		if user.Version != version {
+			t.Errorf("bumped: got version %d; want %d", user.Version, version)
+		}
+
+		stored, err := m.User.Get(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Version != version || stored.Locale != "fr" || stored.Email != "alice@example.org" {
+			t.Errorf("got %s in %q at version %d", stored.Email, stored.Locale, stored.Version)
+		}
+
+		stale := *stored
+		stale.Version--
+		err = m.User.BumpVersion(ctx, &stale)
+		if !errors.Is(err, ErrEditConflict) {
+			t.Errorf("stale bump: got error %v; want %v", err, ErrEditConflict)
+		}
+	})
+}
+
+func TestUserDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+
+		_, err := m.PersonalAccessToken.New(ctx, alice.ID, "ci", []string{TokenScopeUserRead}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.User.Delete(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = m.User.Get(ctx, alice.ID)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		pats, err := m.PersonalAccessToken.GetAllForUser(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pats) != 0 {
+			t.Errorf("got %d personal access tokens of a deleted user", len(pats))
+		}
+
+		err = m.User.Delete(ctx, alice.ID)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("deleted twice: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
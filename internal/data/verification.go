@@ -9,19 +9,59 @@ import (
 	"github.com/go-ozzo/ozzo-validation/is"
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
 	VerificationTokenTTL = time.Hour * 36
+	VerificationCooldown = time.Minute * 5
+	EmailRevertTokenTTL  = time.Hour * 24 * 7
 	ScopeRegistration    = "registration"
 	ScopeAccountDeletion = "account-deletion"
 	ScopeEmailChange     = "email-change"
 	ScopePasswordReset   = "password-reset"
+	ScopeAccountUnlock   = "account-unlock"
+	ScopeEmailRevert     = "email-revert"
 )
 
+// Failed verifications allowed per scope and email before the
+// outstanding tokens are invalidated
+const VerificationMaxAttempts = 5
+
+// Lifetime of a scope's tokens, and how long after a token is sent
+// before it can be resent.
+type VerificationPolicy struct {
+	TTL      time.Duration
+	Cooldown time.Duration
+}
+
+// Verification policies by scope
+type VerificationPolicies map[string]VerificationPolicy
+
+var DefaultVerificationPolicies = VerificationPolicies{
+	ScopeRegistration:    {VerificationTokenTTL, VerificationCooldown},
+	ScopeAccountDeletion: {VerificationTokenTTL, VerificationCooldown},
+	ScopeEmailChange:     {VerificationTokenTTL, VerificationCooldown},
+	ScopePasswordReset:   {VerificationTokenTTL, VerificationCooldown},
+	ScopeAccountUnlock:   {VerificationTokenTTL, VerificationCooldown},
+	ScopeEmailRevert:     {EmailRevertTokenTTL, VerificationCooldown},
+}
+
+// Policy for scope, falling back to the default policy for scopes
+// that are not configured.
+func (p VerificationPolicies) Get(scope string) VerificationPolicy {
+	if policy, ok := p[scope]; ok {
+		return policy
+	}
+	if policy, ok := DefaultVerificationPolicies[scope]; ok {
+		return policy
+	}
+
+	return VerificationPolicy{VerificationTokenTTL, VerificationCooldown}
+}
+
 type VerificationTokenModel struct {
-	pool *pgxpool.Pool
+	db       dbtx
+	policies VerificationPolicies
 }
 
 type VerificationToken struct {
@@ -39,10 +79,11 @@ func (vt VerificationToken) Validate() error {
 }
 
 // Create and insert new verification for email. Generates a randomly
-// generated token and stores a hash of it in the database. Returns
-// the plaintext token.
-func (m VerificationTokenModel) New(scope, email string, userID *uuid.UUID) (*Token, error) {
-	t, err := generateToken(VerificationTokenTTL)
+// generated token and stores a hash of it in the database. If mail
+// is not nil, it is enqueued with the token in the same transaction.
+// Returns the plaintext token.
+func (m VerificationTokenModel) New(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error) {
+	t, err := generateToken(m.policies.Get(scope).TTL)
 	if err != nil {
 		return nil, err
 	}
@@ -54,89 +95,221 @@ func (m VerificationTokenModel) New(scope, email string, userID *uuid.UUID) (*To
 		Token:  t,
 	}
 
-	err = m.Insert(vt)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	tx, err := m.db.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	return t, err
+	err = issueVerificationToken(ctx, tx, vt, mail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
 }
 
-func (m VerificationTokenModel) Insert(vt *VerificationToken) error {
+func (m VerificationTokenModel) Insert(ctx context.Context, vt *VerificationToken) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	return insertVerificationToken(ctx, m.db, vt)
+}
+
+func insertVerificationToken(ctx context.Context, db execer, vt *VerificationToken) error {
 	err := vt.Validate()
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
-	defer cancel()
-
 	sql := `
 		INSERT INTO verification_token_ (hash_, expiry_, scope_, email_, user_id_)
 		VALUES($1, $2, $3, $4, $5);`
 
 	args := []any{vt.Hash, vt.Expiry, vt.Scope, vt.Email, vt.UserID}
 
-	_, err = m.pool.Exec(ctx, sql, args...)
+	_, err = db.Exec(ctx, sql, args...)
 	return err
 }
 
-func (m VerificationTokenModel) Exists(scope, email string, userID *uuid.UUID) (bool, error) {
-	var exists bool
+// Insert the token with a clean slate of attempts, and enqueue mail
+// carrying it if not nil.
+func issueVerificationToken(ctx context.Context, db execer, vt *VerificationToken, mail *Mail) error {
+	err := insertVerificationToken(ctx, db, vt)
+	if err != nil {
+		return err
+	}
+
+	err = resetVerificationAttempts(ctx, db, vt.Scope, vt.Email)
+	if err != nil {
+		return err
+	}
+
+	if mail == nil {
+		return nil
+	}
+
+	job, err := newMailJob(mail, vt.Token)
+	if err != nil {
+		return err
+	}
+
+	return enqueueJob(ctx, db, job)
+}
+
+// Send a new token for scope and email, replacing any outstanding
+// tokens. Returns ErrCooldown if the previous token was created within
+// the scope's cooldown, in which case the previous token stays valid.
+// If mail is not nil, it is enqueued with the token.
+func (m VerificationTokenModel) Resend(ctx context.Context, scope, email string, userID *uuid.UUID, mail *Mail) (*Token, error) {
+	policy := m.policies.Get(scope)
+
+	t, err := generateToken(policy.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	vt := &VerificationToken{
+		Scope:  scope,
+		Email:  email,
+		UserID: userID,
+		Token:  t,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
 
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize resends for the scope and email
 	sql := `
-		SELECT EXISTS (
-			SELECT 1
-			FROM verification_token_
-			WHERE scope_ = $1
-			AND email_ = $2`
+		SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2));`
+
+	_, err = tx.Exec(ctx, sql, scope, email)
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `
+		DELETE FROM verification_token_
+		WHERE scope_ = $1
+		AND email_ = $2`
 
 	args := []any{scope, email}
 
 	if userID != nil {
 		sql += `
-			AND user_id_ = $3`
+		AND user_id_ = $3`
 		args = append(args, *userID)
 	}
 	sql += `
-		);`
+		RETURNING created_at_;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
-	defer cancel()
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
 
-	err := m.pool.QueryRow(ctx, sql, args...).Scan(&exists)
+	createdAts, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	for _, createdAt := range createdAts {
+		if time.Since(createdAt) < policy.Cooldown {
+			return nil, ErrCooldown
+		}
+	}
+
+	err = issueVerificationToken(ctx, tx, vt, mail)
+	if err != nil {
+		return nil, err
 	}
 
-	return exists, nil
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
 }
 
-func (m VerificationTokenModel) PurgeWithEmail(email string) error {
+func (m VerificationTokenModel) PurgeWithEmail(ctx context.Context, email string) error {
 	sql := `
 		DELETE FROM verification_token_
 		WHERE email_ = $1;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	_, err := m.pool.Exec(ctx, sql, email)
+	_, err := m.db.Exec(ctx, sql, email)
 	return err
 }
 
-func (m VerificationTokenModel) PurgeWithUserID(userID uuid.UUID) error {
+func (m VerificationTokenModel) PurgeWithScope(ctx context.Context, scope, email string) error {
 	sql := `
 		DELETE FROM verification_token_
-		WHERE user_id_ = $1;`
+		WHERE scope_ = $1
+		AND email_ = $2;`
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	args := []any{scope, email}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	_, err := m.pool.Exec(ctx, sql, userID)
+	_, err := m.db.Exec(ctx, sql, args...)
 	return err
 }
 
-func (m VerificationTokenModel) Verify(token, scope, email string, userID *uuid.UUID) error {
+// Delete the user's verification tokens. Email revert tokens are
+// kept, so a previous owner can still undo an email change after the
+// account's email or password was changed again.
+func (m VerificationTokenModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM verification_token_
+		WHERE user_id_ = $1
+		AND scope_ <> $2;`
+
+	args := []any{userID, ScopeEmailRevert}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, args...)
+	return err
+}
+
+func (m VerificationTokenModel) PurgeWithScopeUserID(ctx context.Context, scope string, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM verification_token_
+		WHERE scope_ = $1
+		AND user_id_ = $2;`
+
+	args := []any{scope, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Verify the token for scope and email. Every mismatch counts as a
+// failed attempt, and after VerificationMaxAttempts the outstanding
+// tokens are deleted and ErrTooManyAttempts is returned.
+func (m VerificationTokenModel) Verify(ctx context.Context, token, scope, email string, userID *uuid.UUID) error {
 	var expiry time.Time
 
 	sql := `
@@ -155,14 +328,21 @@ func (m VerificationTokenModel) Verify(token, scope, email string, userID *uuid.
 		args = append(args, *userID)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
 	defer cancel()
 
-	err := m.pool.QueryRow(ctx, sql, args...).Scan(&expiry)
+	window := m.policies.Get(scope).TTL
+
+	err := checkVerificationAttempts(ctx, m.db, scope, email, window)
+	if err != nil {
+		return err
+	}
+
+	err = m.db.QueryRow(ctx, sql, args...).Scan(&expiry)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
-			return ErrRecordNotFound
+			return failVerification(ctx, m.db, scope, email, window)
 		default:
 			return err
 		}
@@ -172,5 +352,86 @@ func (m VerificationTokenModel) Verify(token, scope, email string, userID *uuid.
 		return ErrExpiredToken
 	}
 
+	return resetVerificationAttempts(ctx, m.db, scope, email)
+}
+
+// Return ErrTooManyAttempts if the scope and email have used up their
+// attempts within window, the lifetime of the scope's tokens.
+func checkVerificationAttempts(ctx context.Context, db dbtx, scope, email string, window time.Duration) error {
+	var exceeded bool
+
+	sql := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM verification_attempt_
+			WHERE scope_ = $1
+			AND email_ = $2
+			AND failures_ >= $3
+			AND last_failure_at_ > $4
+		);`
+
+	args := []any{scope, email, VerificationMaxAttempts, time.Now().Add(-window)}
+
+	err := db.QueryRow(ctx, sql, args...).Scan(&exceeded)
+	if err != nil {
+		return err
+	}
+
+	if exceeded {
+		return ErrTooManyAttempts
+	}
+
 	return nil
 }
+
+// Record a failed verification. Returns ErrRecordNotFound, or
+// ErrTooManyAttempts once the outstanding tokens have been deleted.
+// Failures older than window are forgotten.
+func failVerification(ctx context.Context, db dbtx, scope, email string, window time.Duration) error {
+	var failures int
+
+	sql := `
+		INSERT INTO verification_attempt_ (scope_, email_, failures_, last_failure_at_)
+		VALUES($1, $2, 1, NOW())
+		ON CONFLICT (scope_, email_) DO UPDATE
+		SET failures_ = CASE
+			WHEN verification_attempt_.last_failure_at_ > $3
+			THEN verification_attempt_.failures_ + 1
+			ELSE 1
+		END,
+		last_failure_at_ = NOW()
+		RETURNING failures_;`
+
+	args := []any{scope, email, time.Now().Add(-window)}
+
+	err := db.QueryRow(ctx, sql, args...).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	if failures < VerificationMaxAttempts {
+		return ErrRecordNotFound
+	}
+
+	sql = `
+		DELETE FROM verification_token_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	_, err = db.Exec(ctx, sql, scope, email)
+	if err != nil {
+		return err
+	}
+
+	return ErrTooManyAttempts
+}
+
+func resetVerificationAttempts(ctx context.Context, db execer, scope, email string) error {
+	sql := `
+		DELETE FROM verification_attempt_
+		WHERE scope_ = $1
+		AND email_ = $2;`
+
+	_, err := db.Exec(ctx, sql, scope, email)
+	return err
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerificationTokenVerify(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		token, err := m.VerificationToken.New(ctx, ScopeRegistration, "alice@example.com", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopeRegistration, "ALICE@example.com", nil)
+		if err != nil {
+			t.Errorf("email in another case: %v", err)
+		}
+
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopePasswordReset, "alice@example.com", nil)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("other scope: got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopeRegistration, "bob@example.com", nil)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("other email: got error %v; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
+
+func TestVerificationTokenUserID(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		alice := newUser(t, m, "alice@example.com")
+		bob := newUser(t, m, "bob@example.com")
+
+		token, err := m.VerificationToken.New(ctx, ScopeEmailChange, "new@example.com", &alice.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopeEmailChange, "new@example.com", &bob.ID)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("other user: got error %v; want %v", err, ErrRecordNotFound)
+		}
+
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopeEmailChange, "new@example.com", &alice.ID)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestVerificationTokenExpiry(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		token, err := generateToken(-time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.VerificationToken.Insert(ctx, &VerificationToken{
+			Scope: ScopeRegistration,
+			Email: "alice@example.com",
+			Token: token,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopeRegistration, "alice@example.com", nil)
+		if !errors.Is(err, ErrExpiredToken) {
+			t.Errorf("got error %v; want %v", err, ErrExpiredToken)
+		}
+	})
+}
+
+func TestVerificationAttemptLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		token, err := m.VerificationToken.New(ctx, ScopePasswordReset, "alice@example.com", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 1; i < VerificationMaxAttempts; i++ {
+			err = m.VerificationToken.Verify(ctx, "wrong", ScopePasswordReset, "alice@example.com", nil)
+			if !errors.Is(err, ErrRecordNotFound) {
+				t.Fatalf("attempt %d: got error %v; want %v", i, err, ErrRecordNotFound)
+			}
+		}
+
+		err = m.VerificationToken.Verify(ctx, "wrong", ScopePasswordReset, "alice@example.com", nil)
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Fatalf("last attempt: got error %v; want %v", err, ErrTooManyAttempts)
+		}
+
+		// The outstanding token was invalidated
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopePasswordReset, "alice@example.com", nil)
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("right token: got error %v; want %v", err, ErrTooManyAttempts)
+		}
+
+		// Attempts are counted per scope and email
+		other, err := m.VerificationToken.New(ctx, ScopeRegistration, "alice@example.com", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.VerificationToken.Verify(ctx, other.Plaintext, ScopeRegistration, "alice@example.com", nil)
+		if err != nil {
+			t.Errorf("other scope: %v", err)
+		}
+
+		// A new token starts over
+		token, err = m.VerificationToken.New(ctx, ScopePasswordReset, "alice@example.com", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.VerificationToken.Verify(ctx, token.Plaintext, ScopePasswordReset, "alice@example.com", nil)
+		if err != nil {
+			t.Errorf("new token: %v", err)
+		}
+	})
+}
+
+func TestVerificationTokenResendCooldown(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, m Models) {
+		ctx := context.Background()
+
+		first, err := m.VerificationToken.Resend(ctx, ScopeRegistration, "alice@example.com", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = m.VerificationToken.Resend(ctx, ScopeRegistration, "alice@example.com", nil, nil)
+		if !errors.Is(err, ErrCooldown) {
+			t.Errorf("got error %v; want %v", err, ErrCooldown)
+		}
+
+		// The first token stays valid
+		err = m.VerificationToken.Verify(ctx, first.Plaintext, ScopeRegistration, "alice@example.com", nil)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+)
+
+// Default expiry duration of a registration or login ceremony
+const WebAuthnSessionTTL = time.Minute * 5
+
+type WebAuthnCredentialModel struct {
+	db dbtx
+}
+
+// A registered WebAuthn public key credential. Data holds the
+// serialized credential, including its public key and sign count.
+type WebAuthnCredential struct {
+	ID         []byte     `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	Name       string     `json:"name"`
+	Data       []byte     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func (c WebAuthnCredential) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.ID, validation.Required),
+		validation.Field(&c.UserID, validation.Required),
+		validation.Field(&c.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&c.Data, validation.Required))
+}
+
+func (m WebAuthnCredentialModel) Insert(ctx context.Context, c *WebAuthnCredential) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO webauthn_credential_ (id_, user_id_, name_, data_)
+		VALUES($1, $2, $3, $4)
+		RETURNING created_at_;`
+
+	args := []any{c.ID, c.UserID, c.Name, c.Data}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err = m.db.QueryRow(ctx, sql, args...).Scan(&c.CreatedAt)
+	if err != nil {
+		switch {
+		case pgErrCode(err) == pgerrcode.UniqueViolation:
+			return ErrDuplicateCredential
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m WebAuthnCredentialModel) GetAllForUser(ctx context.Context, userID uuid.UUID) ([]*WebAuthnCredential, error) {
+	sql := `
+		SELECT id_, user_id_, name_, data_, created_at_, last_used_at_
+		FROM webauthn_credential_
+		WHERE user_id_ = $1
+		ORDER BY created_at_;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	rows, err := m.db.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []*WebAuthnCredential{}
+	for rows.Next() {
+		var c WebAuthnCredential
+
+		err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.Name,
+			&c.Data,
+			&c.CreatedAt,
+			&c.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+// Store the credential data after a successful login, such as an
+// updated sign count.
+func (m WebAuthnCredentialModel) UpdateForLogin(ctx context.Context, id, data []byte) error {
+	sql := `
+		UPDATE webauthn_credential_
+		SET data_ = $2, last_used_at_ = NOW()
+		WHERE id_ = $1;`
+
+	args := []any{id, data}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m WebAuthnCredentialModel) DeleteForUser(ctx context.Context, id []byte, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM webauthn_credential_
+		WHERE id_ = $1
+		AND user_id_ = $2;`
+
+	args := []any{id, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m WebAuthnCredentialModel) PurgeWithUserID(ctx context.Context, userID uuid.UUID) error {
+	sql := `
+		DELETE FROM webauthn_credential_
+		WHERE user_id_ = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err := m.db.Exec(ctx, sql, userID)
+	return err
+}
+
+type WebAuthnSessionModel struct {
+	db dbtx
+}
+
+// State kept between the begin and finish steps of a registration or
+// login ceremony. UserID is nil for discoverable logins.
+type WebAuthnSession struct {
+	UserID *uuid.UUID
+	Data   []byte
+	*Token
+}
+
+func (s WebAuthnSession) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Hash, validation.Required),
+		validation.Field(&s.Expiry, validation.Required),
+		validation.Field(&s.Data, validation.Required))
+}
+
+func (m WebAuthnSessionModel) New(ctx context.Context, userID *uuid.UUID, data []byte) (*Token, error) {
+	t, err := generateToken(WebAuthnSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &WebAuthnSession{
+		UserID: userID,
+		Data:   data,
+		Token:  t,
+	}
+
+	err = m.Insert(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (m WebAuthnSessionModel) Insert(ctx context.Context, s *WebAuthnSession) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+
+	sql := `
+		INSERT INTO webauthn_session_ (hash_, expiry_, data_, user_id_)
+		VALUES($1, $2, $3, $4);`
+
+	args := []any{s.Hash, s.Expiry, s.Data, s.UserID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	_, err = m.db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Get and delete the session for the plaintext token, so that each
+// ceremony can only be finished once.
+func (m WebAuthnSessionModel) Take(ctx context.Context, token string, userID *uuid.UUID) (*WebAuthnSession, error) {
+	s := WebAuthnSession{Token: &Token{}}
+
+	sql := `
+		DELETE FROM webauthn_session_
+		WHERE hash_ = $1
+		AND user_id_ IS NOT DISTINCT FROM $2
+		RETURNING user_id_, data_, expiry_;`
+
+	hash := generateHash(token)
+	args := []any{hash, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	err := m.db.QueryRow(ctx, sql, args...).Scan(&s.UserID, &s.Data, &s.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(s.Expiry) {
+		return nil, ErrExpiredToken
+	}
+
+	s.Hash = hash
+
+	return &s, nil
+}
@@ -0,0 +1,111 @@
+package i18n
+
+// Translations of API messages, keyed by the English message
+var catalog = map[string]map[string]string{
+	"fr": {
+		// Errors
+		"invalid credentials":                                  "identifiants invalides",
+		"invalid or expired authentication token":              "jeton d'authentification invalide ou expiré",
+		"invalid or expired refresh token":                     "jeton de rafraîchissement invalide ou expiré",
+		"you must be authenticated to access this resource":    "vous devez être authentifié pour accéder à cette ressource",
+		"rate limit exceeded":                                  "limite de requêtes dépassée",
+		"too many failed login attempts, try again later":      "trop de tentatives de connexion échouées, réessayez plus tard",
+		"too many failed attempts, please request a new token": "trop de tentatives échouées, veuillez demander un nouveau jeton",
+		"your token does not grant access to this resource":    "votre jeton ne donne pas accès à cette ressource",
+		"invalid or expired mfa challenge token":               "jeton de défi à deux facteurs invalide ou expiré",
+		"invalid two-factor authentication code":               "code d'authentification à deux facteurs invalide",
+		"two-factor authentication is already enabled":         "l'authentification à deux facteurs est déjà activée",
+		"invalid or expired webauthn session token":            "jeton de session webauthn invalide ou expiré",
+		"invalid webauthn credential":                          "identifiant webauthn invalide",
+		"credential is already registered":                     "cet identifiant est déjà enregistré",
+		"you must reauthenticate to perform this action":       "vous devez vous authentifier à nouveau pour effectuer cette action",
+		"email address is already in use":                      "cette adresse e-mail est déjà utilisée",
+		"Expired token":                                        "Jeton expiré",
+		"Expired token. Please signup again.":                  "Jeton expiré. Veuillez vous inscrire à nouveau.",
+
+		// Validation
+		"cannot be blank":                      "ne peut pas être vide",
+		"must be a valid email address":        "doit être une adresse e-mail valide",
+		"must be a valid value":                "doit être une valeur valide",
+		"must be in the future":                "doit être dans le futur",
+		"must be within a year":                "doit être dans moins d'un an",
+		"the length must be between 1 and 100": "la longueur doit être comprise entre 1 et 100",
+		"the length must be between 8 and 72":  "la longueur doit être comprise entre 8 et 72",
+		"the length must be exactly 6":         "la longueur doit être exactement de 6",
+		"the length must be no more than 100":  "la longueur ne doit pas dépasser 100",
+
+		// Status texts
+		"Unauthorized":          "Non autorisé",
+		"Forbidden":             "Interdit",
+		"Not Found":             "Introuvable",
+		"Method Not Allowed":    "Méthode non autorisée",
+		"Too Many Requests":     "Trop de requêtes",
+		"Internal Server Error": "Erreur interne du serveur",
+
+		// Messages
+		"A verification email has been sent. Please check your inbox.":                         "Un e-mail de vérification a été envoyé. Veuillez consulter votre boîte de réception.",
+		"you have been logged out":                                                             "vous avez été déconnecté",
+		"you have been logged out of all sessions":                                             "vous avez été déconnecté de toutes les sessions",
+		"your password was successfully reset":                                                 "votre mot de passe a été réinitialisé",
+		"your account was successfully unlocked":                                               "votre compte a été débloqué",
+		"your email was restored. A token to reset your password has been sent to your inbox.": "votre adresse e-mail a été restaurée. Un jeton pour réinitialiser votre mot de passe a été envoyé dans votre boîte de réception.",
+		"your account was successfully deleted":                                                "votre compte a été supprimé",
+		"session successfully revoked":                                                         "session révoquée",
+		"personal access token successfully revoked":                                           "jeton d'accès personnel révoqué",
+		"passkey successfully removed":                                                         "clé d'accès supprimée",
+		"two-factor authentication was disabled":                                               "l'authentification à deux facteurs a été désactivée",
+	},
+	"es": {
+		// Errors
+		"invalid credentials":                                  "credenciales inválidas",
+		"invalid or expired authentication token":              "token de autenticación inválido o caducado",
+		"invalid or expired refresh token":                     "token de actualización inválido o caducado",
+		"you must be authenticated to access this resource":    "debe autenticarse para acceder a este recurso",
+		"rate limit exceeded":                                  "límite de solicitudes excedido",
+		"too many failed login attempts, try again later":      "demasiados intentos de inicio de sesión fallidos, inténtelo más tarde",
+		"too many failed attempts, please request a new token": "demasiados intentos fallidos, solicite un nuevo token",
+		"your token does not grant access to this resource":    "su token no da acceso a este recurso",
+		"invalid or expired mfa challenge token":               "token de desafío de dos factores inválido o caducado",
+		"invalid two-factor authentication code":               "código de autenticación de dos factores inválido",
+		"two-factor authentication is already enabled":         "la autenticación de dos factores ya está activada",
+		"invalid or expired webauthn session token":            "token de sesión webauthn inválido o caducado",
+		"invalid webauthn credential":                          "credencial webauthn inválida",
+		"credential is already registered":                     "la credencial ya está registrada",
+		"you must reauthenticate to perform this action":       "debe autenticarse de nuevo para realizar esta acción",
+		"email address is already in use":                      "la dirección de correo ya está en uso",
+		"Expired token":                                        "Token caducado",
+		"Expired token. Please signup again.":                  "Token caducado. Regístrese de nuevo.",
+
+		// Validation
+		"cannot be blank":                      "no puede estar vacío",
+		"must be a valid email address":        "debe ser una dirección de correo válida",
+		"must be a valid value":                "debe ser un valor válido",
+		"must be in the future":                "debe estar en el futuro",
+		"must be within a year":                "debe estar dentro de un año",
+		"the length must be between 1 and 100": "la longitud debe estar entre 1 y 100",
+		"the length must be between 8 and 72":  "la longitud debe estar entre 8 y 72",
+		"the length must be exactly 6":         "la longitud debe ser exactamente 6",
+		"the length must be no more than 100":  "la longitud no debe superar 100",
+
+		// Status texts
+		"Unauthorized":          "No autorizado",
+		"Forbidden":             "Prohibido",
+		"Not Found":             "No encontrado",
+		"Method Not Allowed":    "Método no permitido",
+		"Too Many Requests":     "Demasiadas solicitudes",
+		"Internal Server Error": "Error interno del servidor",
+
+		// Messages
+		"A verification email has been sent. Please check your inbox.":                         "Se ha enviado un correo de verificación. Revise su bandeja de entrada.",
+		"you have been logged out":                                                             "ha cerrado la sesión",
+		"you have been logged out of all sessions":                                             "ha cerrado todas las sesiones",
+		"your password was successfully reset":                                                 "su contraseña se ha restablecido",
+		"your account was successfully unlocked":                                               "su cuenta se ha desbloqueado",
+		"your email was restored. A token to reset your password has been sent to your inbox.": "su correo se ha restaurado. Se ha enviado un token para restablecer su contraseña a su bandeja de entrada.",
+		"your account was successfully deleted":                                                "su cuenta se ha eliminado",
+		"session successfully revoked":                                                         "sesión revocada",
+		"personal access token successfully revoked":                                           "token de acceso personal revocado",
+		"passkey successfully removed":                                                         "llave de acceso eliminada",
+		"two-factor authentication was disabled":                                               "la autenticación de dos factores se ha desactivado",
+	},
+}
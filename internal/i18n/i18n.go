@@ -0,0 +1,51 @@
+// Package i18n picks a locale for a request and translates the
+// messages returned by the API.
+package i18n
+
+import (
+	"slices"
+
+	"golang.org/x/text/language"
+)
+
+// Locale used when no supported locale matches
+const DefaultLocale = "en"
+
+// Supported locales, the default first
+var Locales = []string{DefaultLocale, "fr", "es"}
+
+var matcher = language.NewMatcher([]language.Tag{
+	language.English,
+	language.French,
+	language.Spanish,
+})
+
+// Reports whether locale is one of Locales.
+func Supported(locale string) bool {
+	return slices.Contains(Locales, locale)
+}
+
+// Best supported locale for an Accept-Language header value.
+func Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+
+	_, i, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+
+	return Locales[i]
+}
+
+// Translate an English message to locale. Messages missing from the
+// catalog are returned unchanged.
+func Translate(locale, message string) string {
+	if translated, ok := catalog[locale][message]; ok {
+		return translated
+	}
+
+	return message
+}
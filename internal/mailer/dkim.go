@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// Headers covered by DKIM signatures
+var dkimHeaderKeys = []string{
+	"From",
+	"To",
+	"Subject",
+	"Date",
+	"Mime-Version",
+	"Content-Type",
+}
+
+// A DKIM signing key, published in DNS at selector._domainkey.domain
+type DKIMKey struct {
+	Domain   string
+	Selector string
+	Signer   crypto.Signer
+}
+
+// Parse a PEM encoded RSA or Ed25519 private key for signing mail
+// for domain with selector.
+func ParseDKIMKey(domain, selector string, b []byte) (*DKIMKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM data found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("dkim: unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &DKIMKey{domain, selector, k}, nil
+	case ed25519.PrivateKey:
+		return &DKIMKey{domain, selector, k}, nil
+	default:
+		return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+}
+
+// Add a DKIM-Signature header for each key to the raw message. While
+// keys are rotated, signing with both the old and new key lets
+// receivers verify the message whichever is published.
+func signDKIM(raw []byte, keys []*DKIMKey) ([]byte, error) {
+	for _, k := range keys {
+		opts := &dkim.SignOptions{
+			Domain:                 k.Domain,
+			Selector:               k.Selector,
+			Signer:                 k.Signer,
+			HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+			BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+			HeaderKeys:             dkimHeaderKeys,
+		}
+
+		var signed bytes.Buffer
+		err := dkim.Sign(&signed, bytes.NewReader(raw), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = signed.Bytes()
+	}
+
+	return raw, nil
+}
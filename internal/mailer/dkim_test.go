@@ -0,0 +1,244 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/mail"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/micahco/api/ui"
+)
+
+const dkimDomain = "example.com"
+
+func newRSAKeyPEM(t *testing.T) ([]byte, *rsa.PublicKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	return b, &key.PublicKey
+}
+
+func newEd25519KeyPEM(t *testing.T) ([]byte, ed25519.PublicKey) {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), pub
+}
+
+// DNS TXT record publishing a public key
+func dkimRecord(t *testing.T, pub any) string {
+	t.Helper()
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k)
+	default:
+		t.Fatalf("unsupported public key %T", pub)
+		return ""
+	}
+}
+
+// Resolve DKIM records from records, keyed by selector
+func lookupStub(records map[string]string) func(string) ([]string, error) {
+	return func(domain string) ([]string, error) {
+		for selector, record := range records {
+			if domain == selector+"._domainkey."+dkimDomain {
+				return []string{record}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("no TXT record for %s", domain)
+	}
+}
+
+func TestSendDKIMRotation(t *testing.T) {
+	rsaPEM, rsaPub := newRSAKeyPEM(t)
+	edPEM, edPub := newEd25519KeyPEM(t)
+
+	oldKey, err := ParseDKIMKey(dkimDomain, "old", rsaPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ParseDKIMKey(dkimDomain, "new", edPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := NewMemoryTransport(10)
+	sender := &mail.Address{Name: "API", Address: "noreply@" + dkimDomain}
+
+	m, err := New(transport, sender, "API", ui.Files, "mail", oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("alice@example.org", "", "registration.tmpl", map[string]any{"token": "X"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages; want 1", len(messages))
+	}
+	raw := messages[0].Raw
+
+	tests := []struct {
+		name    string
+		records map[string]string
+		valid   int
+	}{
+		{"both published", map[string]string{"old": dkimRecord(t, rsaPub), "new": dkimRecord(t, edPub)}, 2},
+		{"old retired", map[string]string{"new": dkimRecord(t, edPub)}, 1},
+		{"new unpublished", map[string]string{"old": dkimRecord(t, rsaPub)}, 1},
+		{"keys swapped", map[string]string{"old": dkimRecord(t, edPub), "new": dkimRecord(t, rsaPub)}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{
+				LookupTXT: lookupStub(tt.records),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(verifications) != 2 {
+				t.Fatalf("got %d signatures; want 2", len(verifications))
+			}
+
+			valid := 0
+			for _, v := range verifications {
+				if v.Err == nil {
+					valid++
+				}
+				if v.Domain != dkimDomain {
+					t.Errorf("got domain %q; want %q", v.Domain, dkimDomain)
+				}
+			}
+			if valid != tt.valid {
+				t.Errorf("got %d valid signatures; want %d", valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestSendDKIMTampered(t *testing.T) {
+	edPEM, edPub := newEd25519KeyPEM(t)
+
+	key, err := ParseDKIMKey(dkimDomain, "s1", edPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := NewMemoryTransport(10)
+	sender := &mail.Address{Address: "noreply@" + dkimDomain}
+
+	m, err := New(transport, sender, "API", ui.Files, "mail", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("alice@example.org", "", "registration.tmpl", map[string]any{"token": "X"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := transport.Messages()[0].Raw
+	raw = bytes.Replace(raw, []byte("Token: X"), []byte("Token: Y"), 1)
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{
+		LookupTXT: lookupStub(map[string]string{"s1": dkimRecord(t, edPub)}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(verifications) != 1 || verifications[0].Err == nil {
+		t.Error("tampered body passed verification")
+	}
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecSEC1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edPub := newEd25519KeyPEM(t)
+	pubDER, err := x509.MarshalPKIXPublicKey(edPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no PEM data", []byte("not a key")},
+		{"EC block", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecSEC1})},
+		{"public key block", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})},
+		{"ECDSA key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecDER})},
+		{"malformed key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("garbage")})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDKIMKey(dkimDomain, "s1", tt.data); err == nil {
+				t.Error("parsed an unsupported key")
+			}
+		})
+	}
+
+	rsaPEM, _ := newRSAKeyPEM(t)
+	key, err := ParseDKIMKey(dkimDomain, "s1", rsaPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Domain != dkimDomain || key.Selector != "s1" {
+		t.Errorf("got %s._domainkey.%s; want s1._domainkey.%s", key.Selector, key.Domain, dkimDomain)
+	}
+}
@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A message captured by an Inbox, with the data it was rendered from
+type InboxMessage struct {
+	ID       int            `json:"id"`
+	To       string         `json:"to"`
+	Locale   string         `json:"locale"`
+	Template string         `json:"template"`
+	Subject  string         `json:"subject"`
+	Text     string         `json:"text"`
+	HTML     string         `json:"html,omitempty"`
+	Data     map[string]any `json:"data"`
+	SentAt   time.Time      `json:"sent_at"`
+}
+
+// Keeps the most recent messages sent by a Mailer, for reading mail
+// in development without a mail server.
+type Inbox struct {
+	mu       sync.Mutex
+	size     int
+	nextID   int
+	messages []InboxMessage
+}
+
+// Create an inbox that keeps the last size messages.
+func NewInbox(size int) *Inbox {
+	return &Inbox{size: size, nextID: 1}
+}
+
+func (i *Inbox) add(msg InboxMessage) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	msg.ID = i.nextID
+	i.nextID++
+
+	i.messages = append(i.messages, msg)
+	if len(i.messages) > i.size {
+		i.messages = slices.Delete(i.messages, 0, len(i.messages)-i.size)
+	}
+}
+
+// Messages sent to recipient, or every message if recipient is empty,
+// newest first.
+func (i *Inbox) Messages(recipient string) []InboxMessage {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	messages := []InboxMessage{}
+	for _, msg := range slices.Backward(i.messages) {
+		if recipient == "" || strings.EqualFold(msg.To, recipient) {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages
+}
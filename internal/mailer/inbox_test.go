@@ -0,0 +1,81 @@
+package mailer
+
+import (
+	"errors"
+	"io"
+	"net/mail"
+	"testing"
+
+	"github.com/micahco/api/ui"
+)
+
+// Fails the first n deliveries
+type flakyTransport struct {
+	*MemoryTransport
+	failures int
+}
+
+func (t *flakyTransport) Send(from string, to []string, msg io.WriterTo) error {
+	if t.failures > 0 {
+		t.failures--
+		return errors.New("connection refused")
+	}
+
+	return t.MemoryTransport.Send(from, to, msg)
+}
+
+func TestInboxCapturesDeliveredMail(t *testing.T) {
+	transport := &flakyTransport{NewMemoryTransport(10), 2}
+	sender := &mail.Address{Address: "noreply@example.com"}
+
+	m, err := New(transport, sender, "API", ui.Files, "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inbox := NewInbox(10)
+	m.CaptureTo(inbox)
+
+	data := map[string]any{"token": "X"}
+
+	// Retried like a mail job until the transport accepts it
+	for range 2 {
+		if err := m.Send("alice@example.org", "fr", "registration.tmpl", data); err == nil {
+			t.Fatal("send succeeded through a failing transport")
+		}
+	}
+	if got := inbox.Messages(""); len(got) != 0 {
+		t.Fatalf("captured %d undelivered messages", len(got))
+	}
+
+	err = m.Send("alice@example.org", "fr", "registration.tmpl", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := inbox.Messages("ALICE@example.org")
+	if len(got) != 1 {
+		t.Fatalf("captured %d messages; want 1", len(got))
+	}
+	if got[0].Locale != "fr" || got[0].Template != "registration.tmpl" || got[0].Data["token"] != "X" {
+		t.Errorf("got %+v", got[0])
+	}
+	if n := len(transport.Messages()); n != 1 {
+		t.Errorf("delivered %d messages; want 1", n)
+	}
+}
+
+func TestInboxSize(t *testing.T) {
+	inbox := NewInbox(2)
+	for _, to := range []string{"a@example.org", "b@example.org", "c@example.org"} {
+		inbox.add(InboxMessage{To: to})
+	}
+
+	got := inbox.Messages("")
+	if len(got) != 2 || got[0].To != "c@example.org" || got[1].To != "b@example.org" {
+		t.Errorf("got %+v; want the last two messages, newest first", got)
+	}
+	if got[0].ID != 3 {
+		t.Errorf("got ID %d; want 3", got[0].ID)
+	}
+}
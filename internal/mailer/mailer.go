@@ -4,81 +4,219 @@ import (
 	"bytes"
 	"embed"
 	"fmt"
+	htmltemplate "html/template"
 	"io/fs"
 	"net/mail"
-	"path/filepath"
+	"path"
 	"text/template"
+	"time"
 
 	"gopkg.in/gomail.v2"
 )
 
 type Mailer struct {
-	dialer        *gomail.Dialer
-	sender        *mail.Address
-	templateCache map[string]*template.Template
+	transport Transport
+	sender    *mail.Address
+	dkim      []*DKIMKey
+	inbox     *Inbox
+	// Templates by locale and name. The default locale's are under ""
+	templateCache map[string]map[string]*mailTemplate
 }
 
-// Create new mailer with SMTP credentials and embedded fs using glob pattern
-func New(host string, port int, username string, password string, sender *mail.Address, fsys embed.FS, globPattern string) (*Mailer, error) {
-	cache := map[string]*template.Template{}
+// A mail template's plain text version, and its HTML version if it
+// defines an htmlBody block
+type mailTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+// Create new mailer delivering through transport, with templates from
+// dir in embedded fs. Templates in dir are the default locale's, and
+// each subdirectory other than layout holds a locale's translations,
+// such as dir/fr/registration.tmpl. Every template is wrapped in the
+// textLayout and htmlLayout blocks of dir/layout/base.tmpl, or of the
+// locale's own layout when it has one. Layouts can use the product
+// name with {{product}}. Messages are signed with each DKIM key.
+func New(transport Transport, sender *mail.Address, product string, fsys embed.FS, dir string, dkimKeys ...*DKIMKey) (*Mailer, error) {
+	funcs := map[string]any{
+		"product": func() string { return product },
+	}
+
+	defaultLayout := path.Join(dir, "layout", "base.tmpl")
 
-	// Get list of filenames in embed using pattern
-	filenames, err := fs.Glob(fsys, globPattern)
+	templates, err := parseTemplates(fsys, dir, defaultLayout, funcs)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create template for each file and add to cache
-	for _, fname := range filenames {
-		name := filepath.Base(fname)
+	cache := map[string]map[string]*mailTemplate{"": templates}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "layout" {
+			continue
+		}
 
-		t, err := template.New(name).ParseFS(fsys, fname)
+		locale := e.Name()
+		localeDir := path.Join(dir, locale)
+
+		layout := path.Join(localeDir, "layout", "base.tmpl")
+		if _, err := fs.Stat(fsys, layout); err != nil {
+			layout = defaultLayout
+		}
+
+		templates, err := parseTemplates(fsys, localeDir, layout, funcs)
 		if err != nil {
 			return nil, err
 		}
 
-		cache[name] = t
+		cache[locale] = templates
 	}
 
 	m := &Mailer{
-		dialer:        gomail.NewDialer(host, port, username, password),
+		transport:     transport,
 		sender:        sender,
+		dkim:          dkimKeys,
 		templateCache: cache,
 	}
 
-	// Ping the SMTP server to verify authentication
-	s, err := m.dialer.Dial()
+	return m, nil
+}
+
+// Capture every message in inbox once the transport accepts it, so
+// mail that is retried after a failed delivery is captured once.
+func (m *Mailer) CaptureTo(inbox *Inbox) {
+	m.inbox = inbox
+}
+
+// Parse each template in dir, wrapped in layout.
+func parseTemplates(fsys embed.FS, dir, layout string, funcs map[string]any) (map[string]*mailTemplate, error) {
+	cache := map[string]*mailTemplate{}
+
+	// Get list of filenames in embed
+	filenames, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
 	if err != nil {
 		return nil, err
 	}
-	defer s.Close()
 
-	return m, nil
+	// Create template for each file and add to cache
+	for _, fname := range filenames {
+		name := path.Base(fname)
+
+		text, err := template.New(name).Funcs(funcs).ParseFS(fsys, layout, fname)
+		if err != nil {
+			return nil, err
+		}
+
+		mt := &mailTemplate{text: text}
+
+		if text.Lookup("htmlBody") != nil {
+			mt.html, err = htmltemplate.New(name).Funcs(funcs).ParseFS(fsys, layout, fname)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cache[name] = mt
+	}
+
+	return cache, nil
+}
+
+// Look up a template in locale, falling back to the default locale.
+func (m *Mailer) lookup(locale, tmpl string) (*mailTemplate, bool) {
+	if t, ok := m.templateCache[locale][tmpl]; ok {
+		return t, true
+	}
+
+	t, ok := m.templateCache[""][tmpl]
+	return t, ok
 }
 
-func (m *Mailer) Send(recepient, tmpl string, data map[string]any) error {
-	t, ok := m.templateCache[tmpl]
+// Send the template in the recipient's locale. Templates that have
+// not been translated are sent in the default locale.
+func (m *Mailer) Send(recepient, locale, tmpl string, data map[string]any) error {
+	t, ok := m.lookup(locale, tmpl)
 	if !ok {
 		return fmt.Errorf("template %s does not exist", tmpl)
 	}
 
 	subject := new(bytes.Buffer)
-	err := t.ExecuteTemplate(subject, "subject", data)
+	err := t.text.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
 		return err
 	}
 
 	body := new(bytes.Buffer)
-	err = t.ExecuteTemplate(body, "body", data)
+	err = t.text.ExecuteTemplate(body, "textLayout", data)
 	if err != nil {
 		return err
 	}
 
+	var html string
+	if t.html != nil {
+		htmlBody := new(bytes.Buffer)
+		err = t.html.ExecuteTemplate(htmlBody, "htmlLayout", data)
+		if err != nil {
+			return err
+		}
+
+		html = htmlBody.String()
+	}
+
 	msg := gomail.NewMessage()
 	msg.SetHeader("To", recepient)
 	msg.SetHeader("From", m.sender.String())
 	msg.SetHeader("Subject", subject.String())
 	msg.SetBody("text/plain", body.String())
 
-	return m.dialer.DialAndSend(msg)
+	// Sent as multipart/alternative, with the plain text as fallback
+	if html != "" {
+		msg.AddAlternative("text/html", html)
+	}
+
+	err = m.deliver(recepient, msg)
+	if err != nil {
+		return err
+	}
+
+	if m.inbox != nil {
+		m.inbox.add(InboxMessage{
+			To:       recepient,
+			Locale:   locale,
+			Template: tmpl,
+			Subject:  subject.String(),
+			Text:     body.String(),
+			HTML:     html,
+			Data:     data,
+			SentAt:   time.Now(),
+		})
+	}
+
+	return nil
+}
+
+// Sign the message with each DKIM key and hand it to the transport.
+func (m *Mailer) deliver(recepient string, msg *gomail.Message) error {
+	if len(m.dkim) == 0 {
+		return m.transport.Send(m.sender.Address, []string{recepient}, msg)
+	}
+
+	// Sign the message as it will be delivered, whatever the transport
+	raw := new(bytes.Buffer)
+	_, err := msg.WriteTo(raw)
+	if err != nil {
+		return err
+	}
+
+	signed, err := signDKIM(raw.Bytes(), m.dkim)
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Send(m.sender.Address, []string{recepient}, bytes.NewReader(signed))
 }
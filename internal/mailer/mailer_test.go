@@ -0,0 +1,224 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/micahco/api/ui"
+)
+
+//go:embed testdata/mail
+var testFiles embed.FS
+
+var testSender = &mail.Address{Name: "Acme", Address: "noreply@example.com"}
+
+// Parse the only message recorded by transport.
+func readMessage(t *testing.T, transport *MemoryTransport) *mail.Message {
+	t.Helper()
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("recorded %d messages; want 1", len(messages))
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(messages[0].Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+// Read the parts of a multipart message by content type.
+func readParts(t *testing.T, msg *mail.Message) (string, map[string]string) {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := map[string]string{}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := io.ReadAll(msg.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[mediaType] = string(body)
+
+		return mediaType, parts
+	}
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		partType, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[partType] = string(body)
+	}
+
+	return mediaType, parts
+}
+
+func TestSendLocales(t *testing.T) {
+	tests := []struct {
+		name    string
+		locale  string
+		subject string
+		text    string
+	}{
+		{"default", "", "Welcome to API Service", "Use the following token"},
+		{"fr", "fr", "Bienvenue sur Acme", "Utilisez le jeton suivant"},
+		{"es", "es", "Bienvenido a Acme", "Use el siguiente token"},
+		// Untranslated locales fall back to the default
+		{"untranslated", "de", "Welcome to API Service", "Use the following token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewMemoryTransport(10)
+
+			m, err := New(transport, testSender, "Acme", ui.Files, "mail")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = m.Send("alice@example.org", tt.locale, "registration.tmpl", map[string]any{"token": "<X>"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg := readMessage(t, transport)
+
+			subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != tt.subject {
+				t.Errorf("got subject %q; want %q", subject, tt.subject)
+			}
+			if to := msg.Header.Get("To"); to != "alice@example.org" {
+				t.Errorf("got To %q; want alice@example.org", to)
+			}
+			from, err := msg.Header.AddressList("From")
+			if err != nil || len(from) != 1 || from[0].String() != testSender.String() {
+				t.Errorf("got From %v; want %s", from, testSender)
+			}
+
+			mediaType, parts := readParts(t, msg)
+			if mediaType != "multipart/alternative" {
+				t.Fatalf("got %s; want multipart/alternative", mediaType)
+			}
+
+			text := parts["text/plain"]
+			if !strings.Contains(text, tt.text) || !strings.Contains(text, "<X>") {
+				t.Errorf("got text %q; want the body with the raw token", text)
+			}
+			if !strings.Contains(text, "Acme") {
+				t.Errorf("got text %q; want the layout footer", text)
+			}
+
+			html := parts["text/html"]
+			if !strings.HasPrefix(html, "<!DOCTYPE html>") || !strings.Contains(html, "&lt;X&gt;") {
+				t.Errorf("got html %q; want the layout with the escaped token", html)
+			}
+			if strings.Contains(html, "<X>") {
+				t.Error("token is not escaped in html")
+			}
+		})
+	}
+}
+
+func TestSendEveryTemplate(t *testing.T) {
+	names, err := fs.Glob(ui.Files, "mail/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, locale := range []string{"", "fr", "es"} {
+		for _, name := range names {
+			transport := NewMemoryTransport(10)
+
+			m, err := New(transport, testSender, "Acme", ui.Files, "mail")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmpl := path.Base(name)
+
+			err = m.Send("alice@example.org", locale, tmpl, map[string]any{"token": "X", "email": "bob@example.org"})
+			if err != nil {
+				t.Errorf("%s in %q: %v", tmpl, locale, err)
+				continue
+			}
+
+			mediaType, parts := readParts(t, readMessage(t, transport))
+			if mediaType != "multipart/alternative" || parts["text/plain"] == "" || parts["text/html"] == "" {
+				t.Errorf("%s in %q: got %s with parts %v", tmpl, locale, mediaType, parts)
+			}
+		}
+	}
+}
+
+func TestSendPlainText(t *testing.T) {
+	transport := NewMemoryTransport(10)
+
+	m, err := New(transport, testSender, "Acme", testFiles, "testdata/mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("alice@example.org", "", "plain.tmpl", map[string]any{"token": "X"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Templates without an htmlBody block are sent as plain text only
+	mediaType, parts := readParts(t, readMessage(t, transport))
+	if mediaType != "text/plain" {
+		t.Fatalf("got %s; want text/plain", mediaType)
+	}
+	if text := parts["text/plain"]; !strings.Contains(text, "Token: X") || !strings.Contains(text, "Acme") {
+		t.Errorf("got text %q; want the body in the layout", text)
+	}
+}
+
+func TestSendUnknownTemplate(t *testing.T) {
+	transport := NewMemoryTransport(10)
+
+	m, err := New(transport, testSender, "Acme", ui.Files, "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("alice@example.org", "", "missing.tmpl", nil)
+	if err == nil {
+		t.Error("sent a template that does not exist")
+	}
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("recorded %d messages; want none", n)
+	}
+}
@@ -0,0 +1,151 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Transport delivers a composed message from the sender to the
+// recipients.
+type Transport interface {
+	Send(from string, to []string, msg io.WriterTo) error
+}
+
+// Delivers messages to an SMTP server. A connection is dialed for
+// every message, so the server does not need to be reachable until
+// mail is sent.
+type SMTPTransport struct {
+	dialer *gomail.Dialer
+}
+
+func NewSMTPTransport(host string, port int, username string, password string) *SMTPTransport {
+	return &SMTPTransport{gomail.NewDialer(host, port, username, password)}
+}
+
+// Dial the SMTP server to verify it is reachable and accepts the
+// credentials.
+func (t *SMTPTransport) Ping() error {
+	s, err := t.dialer.Dial()
+	if err != nil {
+		return err
+	}
+
+	return s.Close()
+}
+
+func (t *SMTPTransport) Send(from string, to []string, msg io.WriterTo) error {
+	s, err := t.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.Send(from, to, msg)
+}
+
+// Saves every message as an .eml file in a directory.
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTransport{dir}, nil
+}
+
+func (t *FileTransport) Send(from string, to []string, msg io.WriterTo) error {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	f, err := os.Create(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// A message recorded by MemoryTransport
+type Message struct {
+	From   string
+	To     []string
+	SentAt time.Time
+	Raw    []byte
+}
+
+// Records the most recent messages in memory instead of delivering
+// them.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	size     int
+	messages []Message
+}
+
+// Create a transport that keeps the last size messages.
+func NewMemoryTransport(size int) *MemoryTransport {
+	return &MemoryTransport{size: size}
+}
+
+func (t *MemoryTransport) Send(from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	_, err := msg.WriteTo(&buf)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, Message{
+		From:   from,
+		To:     slices.Clone(to),
+		SentAt: time.Now(),
+		Raw:    buf.Bytes(),
+	})
+	if len(t.messages) > t.size {
+		t.messages = slices.Delete(t.messages, 0, len(t.messages)-t.size)
+	}
+
+	return nil
+}
+
+// Messages recorded so far, oldest first.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.messages)
+}
+
+// Forget the recorded messages.
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
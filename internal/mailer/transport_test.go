@@ -0,0 +1,262 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/micahco/api/ui"
+)
+
+// A message received by smtpServer
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// Minimal SMTP server accepting every message without authentication.
+type smtpServer struct {
+	ln       net.Listener
+	messages chan smtpMessage
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpServer{ln: ln, messages: make(chan smtpMessage, 10)}
+	go s.serve()
+
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			msg = smtpMessage{from: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.messages <- msg
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) transport(t *testing.T) *SMTPTransport {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(s.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSMTPTransport(host, p, "", "")
+}
+
+func TestSMTPTransport(t *testing.T) {
+	server := newSMTPServer(t)
+	transport := server.transport(t)
+
+	err := transport.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(transport, testSender, "Acme", ui.Files, "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("alice@example.org", "", "registration.tmpl", map[string]any{"token": "X"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-server.messages
+	if got.from != testSender.Address || len(got.to) != 1 || got.to[0] != "alice@example.org" {
+		t.Errorf("got envelope from %s to %v", got.from, got.to)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to := msg.Header.Get("To"); to != "alice@example.org" {
+		t.Errorf("got To %q; want alice@example.org", to)
+	}
+}
+
+func TestSMTPTransportUnreachable(t *testing.T) {
+	server := newSMTPServer(t)
+	transport := server.transport(t)
+	server.ln.Close()
+
+	// Creating the transport does not dial, only sending does
+	m, err := New(transport, testSender, "Acme", ui.Files, "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transport.Ping(); err == nil {
+		t.Error("pinged a closed server")
+	}
+
+	err = m.Send("alice@example.org", "", "registration.tmpl", map[string]any{"token": "X"})
+	if err == nil {
+		t.Error("sent through a closed server")
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	transport, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(transport, testSender, "Acme", ui.Files, "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		err = m.Send("alice@example.org", "fr", "registration.tmpl", map[string]any{"token": "X"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("wrote %d files; want 2", len(files))
+	}
+
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := mail.ReadMessage(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			t.Fatal(err)
+		}
+
+		mediaType, parts := readParts(t, msg)
+		f.Close()
+
+		if to := msg.Header.Get("To"); to != "alice@example.org" {
+			t.Errorf("%s: got To %q; want alice@example.org", name, to)
+		}
+		if mediaType != "multipart/alternative" || !strings.Contains(parts["text/plain"], "Jeton : X") {
+			t.Errorf("%s: got %s with parts %v", name, mediaType, parts)
+		}
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	transport := NewMemoryTransport(10)
+
+	to := []string{"alice@example.org"}
+
+	err := transport.Send("noreply@example.com", to, bytes.NewBufferString("Subject: Hi\r\n\r\nHello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recorded messages do not share the caller's slices
+	to[0] = "bob@example.org"
+
+	got := transport.Messages()
+	if len(got) != 1 {
+		t.Fatalf("recorded %d messages; want 1", len(got))
+	}
+	if got[0].From != "noreply@example.com" || got[0].To[0] != "alice@example.org" {
+		t.Errorf("got from %s to %v", got[0].From, got[0].To)
+	}
+	if string(got[0].Raw) != "Subject: Hi\r\n\r\nHello" {
+		t.Errorf("got raw %q", got[0].Raw)
+	}
+
+	transport.Reset()
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("recorded %d messages after reset; want none", n)
+	}
+}
+
+func TestMemoryTransportSize(t *testing.T) {
+	transport := NewMemoryTransport(2)
+	for _, to := range []string{"a@example.org", "b@example.org", "c@example.org"} {
+		err := transport.Send("noreply@example.com", []string{to}, bytes.NewBufferString("Hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := transport.Messages()
+	if len(got) != 2 || got[0].To[0] != "b@example.org" || got[1].To[0] != "c@example.org" {
+		t.Errorf("got %+v; want the last two messages, oldest first", got)
+	}
+}
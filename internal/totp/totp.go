@@ -0,0 +1,105 @@
+// Package totp implements time-based one-time passwords (RFC 6238)
+// compatible with common authenticator apps: HMAC-SHA1, six digits
+// and a thirty second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	SecretSize = 20
+	Digits     = 6
+	Period     = 30 * time.Second
+	// Number of periods either side of now accepted to allow for
+	// clock drift
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// Encode the secret as base32 for manual entry into an authenticator.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Key URI for provisioning authenticator apps, usually shown as a QR code.
+func URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", EncodeSecret(secret))
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// Counter for the period containing t.
+func Counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(Period.Seconds())
+}
+
+// Code for the counter (RFC 4226).
+func Code(secret []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range Digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, bin%mod)
+}
+
+// Validate the code at time t. Returns the matching counter, which
+// callers should record to reject replays of the same code.
+func Validate(secret []byte, code string, t time.Time) (uint64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Counter(t)
+
+	for i := -Skew; i <= Skew; i++ {
+		counter := now + uint64(i)
+
+		if subtle.ConstantTimeCompare([]byte(Code(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
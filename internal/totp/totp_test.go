@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// SHA-1 test vectors from RFC 6238 Appendix B. The RFC lists eight
+// digit codes, the six digit codes are their last six digits.
+const vectorSecret = "12345678901234567890"
+
+var vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeVectors(t *testing.T) {
+	for _, v := range vectors {
+		want := v.code[len(v.code)-Digits:]
+
+		got := Code([]byte(vectorSecret), Counter(time.Unix(v.unix, 0)))
+		if got != want {
+			t.Errorf("%d: got code %s; want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestValidateVectors(t *testing.T) {
+	for _, v := range vectors {
+		now := time.Unix(v.unix, 0)
+
+		counter, ok := Validate([]byte(vectorSecret), v.code[len(v.code)-Digits:], now)
+		if !ok {
+			t.Errorf("%d: code rejected", v.unix)
+			continue
+		}
+		if counter != Counter(now) {
+			t.Errorf("%d: got counter %d; want %d", v.unix, counter, Counter(now))
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret := []byte(vectorSecret)
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		name   string
+		offset int
+		ok     bool
+	}{
+		{"two periods behind", -2, false},
+		{"one period behind", -1, true},
+		{"current period", 0, true},
+		{"one period ahead", 1, true},
+		{"two periods ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := uint64(int64(Counter(now)) + int64(tt.offset))
+
+			counter, ok := Validate(secret, Code(secret, want), now)
+			if ok != tt.ok {
+				t.Fatalf("got ok %t; want %t", ok, tt.ok)
+			}
+			if ok && counter != want {
+				t.Errorf("got counter %d; want %d", counter, want)
+			}
+		})
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	secret := []byte(vectorSecret)
+	now := time.Unix(59, 0)
+
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := Validate(secret, code, now); ok {
+			t.Errorf("%q: code accepted", code)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("API", "alice@example.com", []byte(vectorSecret))
+
+	want := "otpauth://totp/API:alice@example.com?"
+	if !strings.HasPrefix(uri, want) {
+		t.Errorf("got %s; want prefix %s", uri, want)
+	}
+	if !strings.Contains(uri, "secret="+EncodeSecret([]byte(vectorSecret))) {
+		t.Errorf("got %s; want the encoded secret", uri)
+	}
+}
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE authentication_token_
+    ADD COLUMN id_ uuid NOT NULL UNIQUE DEFAULT gen_random_uuid(),
+    ADD COLUMN created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN last_used_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN ip_ TEXT NOT NULL DEFAULT '',
+    ADD COLUMN user_agent_ TEXT NOT NULL DEFAULT '',
+    ADD COLUMN device_name_ TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE authentication_token_
+    DROP COLUMN IF EXISTS id_,
+    DROP COLUMN IF EXISTS created_at_,
+    DROP COLUMN IF EXISTS last_used_at_,
+    DROP COLUMN IF EXISTS ip_,
+    DROP COLUMN IF EXISTS user_agent_,
+    DROP COLUMN IF EXISTS device_name_;
+-- +goose StatementEnd
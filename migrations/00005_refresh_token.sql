@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE authentication_token_
+    ADD COLUMN family_id_ uuid NOT NULL DEFAULT gen_random_uuid();
+
+CREATE INDEX IF NOT EXISTS authentication_token_family_id_idx_
+    ON authentication_token_ (family_id_);
+
+CREATE TABLE IF NOT EXISTS refresh_token_ (
+    hash_ BYTEA PRIMARY KEY,
+    expiry_ TIMESTAMPTZ NOT NULL,
+    family_id_ uuid NOT NULL,
+    used_ BOOLEAN NOT NULL DEFAULT FALSE,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_family_id_idx_
+    ON refresh_token_ (family_id_);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS refresh_token_;
+
+DROP INDEX IF EXISTS authentication_token_family_id_idx_;
+
+ALTER TABLE authentication_token_
+    DROP COLUMN IF EXISTS family_id_;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS personal_access_token_ (
+    id_ uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+    hash_ BYTEA UNIQUE NOT NULL,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    name_ TEXT NOT NULL,
+    scopes_ TEXT[] NOT NULL,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at_ TIMESTAMPTZ,
+    expiry_ TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS personal_access_token_user_id_idx_
+    ON personal_access_token_ (user_id_);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS personal_access_token_;
+-- +goose StatementEnd
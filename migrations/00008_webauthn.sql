@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS webauthn_credential_ (
+    id_ BYTEA PRIMARY KEY,
+    user_id_ uuid NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    name_ TEXT NOT NULL,
+    data_ JSONB NOT NULL,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at_ TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credential_user_id_idx_
+    ON webauthn_credential_ (user_id_);
+
+CREATE TABLE IF NOT EXISTS webauthn_session_ (
+    hash_ BYTEA PRIMARY KEY,
+    expiry_ TIMESTAMPTZ NOT NULL,
+    data_ JSONB NOT NULL,
+    user_id_ uuid REFERENCES user_ ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webauthn_session_;
+DROP TABLE IF EXISTS webauthn_credential_;
+-- +goose StatementEnd
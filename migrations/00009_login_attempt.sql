@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS login_attempt_ (
+    email_ CITEXT PRIMARY KEY,
+    failures_ INTEGER NOT NULL DEFAULT 0,
+    last_failure_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until_ TIMESTAMPTZ
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_attempt_;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS verification_attempt_ (
+    scope_ TEXT NOT NULL,
+    email_ CITEXT NOT NULL,
+    failures_ INTEGER NOT NULL DEFAULT 0,
+    last_failure_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (scope_, email_)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS verification_attempt_;
+-- +goose StatementEnd
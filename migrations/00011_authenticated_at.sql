@@ -0,0 +1,30 @@
+-- Existing tokens must not count as a recent login, so they are
+-- backfilled with when the session started, or the epoch for refresh
+-- tokens, before NOW() becomes the default.
+
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE authentication_token_
+    ADD COLUMN authenticated_at_ TIMESTAMPTZ NOT NULL DEFAULT 'epoch';
+
+UPDATE authentication_token_
+    SET authenticated_at_ = created_at_;
+
+ALTER TABLE authentication_token_
+    ALTER COLUMN authenticated_at_ SET DEFAULT NOW();
+
+ALTER TABLE refresh_token_
+    ADD COLUMN authenticated_at_ TIMESTAMPTZ NOT NULL DEFAULT 'epoch';
+
+ALTER TABLE refresh_token_
+    ALTER COLUMN authenticated_at_ SET DEFAULT NOW();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE refresh_token_
+    DROP COLUMN IF EXISTS authenticated_at_;
+
+ALTER TABLE authentication_token_
+    DROP COLUMN IF EXISTS authenticated_at_;
+-- +goose StatementEnd
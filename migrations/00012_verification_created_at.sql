@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE verification_token_
+    ADD COLUMN created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE verification_token_
+    DROP COLUMN IF EXISTS created_at_;
+-- +goose StatementEnd
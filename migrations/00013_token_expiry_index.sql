@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS verification_token_expiry_idx_ ON verification_token_ (expiry_);
+CREATE INDEX IF NOT EXISTS authentication_token_expiry_idx_ ON authentication_token_ (expiry_);
+CREATE INDEX IF NOT EXISTS refresh_token_expiry_idx_ ON refresh_token_ (expiry_);
+CREATE INDEX IF NOT EXISTS mfa_challenge_expiry_idx_ ON mfa_challenge_ (expiry_);
+CREATE INDEX IF NOT EXISTS webauthn_session_expiry_idx_ ON webauthn_session_ (expiry_);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS webauthn_session_expiry_idx_;
+DROP INDEX IF EXISTS mfa_challenge_expiry_idx_;
+DROP INDEX IF EXISTS refresh_token_expiry_idx_;
+DROP INDEX IF EXISTS authentication_token_expiry_idx_;
+DROP INDEX IF EXISTS verification_token_expiry_idx_;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS job_ (
+    id_ uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+    created_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    kind_ TEXT NOT NULL,
+    payload_ JSONB NOT NULL,
+    status_ TEXT NOT NULL DEFAULT 'pending',
+    attempts_ INTEGER NOT NULL DEFAULT 0,
+    max_attempts_ INTEGER NOT NULL,
+    run_at_ TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until_ TIMESTAMPTZ,
+    last_error_ TEXT
+);
+
+CREATE INDEX IF NOT EXISTS job_run_at_idx_ ON job_ (run_at_)
+    WHERE status_ IN ('pending', 'running');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS job_;
+-- +goose StatementEnd
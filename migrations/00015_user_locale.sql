@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE user_
+    ADD COLUMN locale_ TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user_
+    DROP COLUMN IF EXISTS locale_;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- Failures are counted per scope, so checking the password of a logged
+-- in user does not lock the email out of logging in.
+
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE login_attempt_
+    ADD COLUMN scope_ TEXT NOT NULL DEFAULT 'login';
+
+ALTER TABLE login_attempt_
+    ALTER COLUMN scope_ DROP DEFAULT;
+
+ALTER TABLE login_attempt_
+    DROP CONSTRAINT login_attempt__pkey;
+
+ALTER TABLE login_attempt_
+    ADD PRIMARY KEY (scope_, email_);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM login_attempt_
+    WHERE scope_ <> 'login';
+
+ALTER TABLE login_attempt_
+    DROP CONSTRAINT login_attempt__pkey;
+
+ALTER TABLE login_attempt_
+    DROP COLUMN scope_;
+
+ALTER TABLE login_attempt_
+    ADD PRIMARY KEY (email_);
+-- +goose StatementEnd
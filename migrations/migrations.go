@@ -3,27 +3,48 @@ package migrations
 import (
 	"database/sql"
 	"embed"
-	"fmt"
+	"io/fs"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
-//go:embed *.sql
+// PostgreSQL migrations at the root, and their SQLite mirror in the
+// sqlite directory.
+//
+//go:embed *.sql sqlite/*.sql
 var Files embed.FS
 
-func Up(db *sql.DB) error {
-	if err := goose.Up(db, "."); err != nil {
-		return fmt.Errorf("failed to apply migrations: %v", err)
+// Goose dialects with a migration set in Files
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite3"
+)
+
+// Dialects in the order their migration sets are listed
+var Dialects = []string{DialectPostgres, DialectSQLite}
+
+// Directory of Files holding the migrations for dialect.
+func Dir(dialect string) string {
+	if dialect == DialectSQLite {
+		return "sqlite"
 	}
 
-	return nil
+	return "."
+}
+
+// Migrations for dialect, with the files at the root.
+func FS(dialect string) (fs.FS, error) {
+	return fs.Sub(Files, Dir(dialect))
 }
 
-func Reset(db *sql.DB) error {
-	if err := goose.Reset(db, "."); err != nil {
-		return fmt.Errorf("failed to reset migrations: %v", err)
+// Create a goose provider running the embedded migrations for dialect
+// on db.
+func NewProvider(db *sql.DB, dialect string, opts ...goose.ProviderOption) (*goose.Provider, error) {
+	fsys, err := FS(dialect)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return goose.NewProvider(goose.Dialect(dialect), db, fsys, opts...)
 }
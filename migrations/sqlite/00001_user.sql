@@ -0,0 +1,25 @@
+-- SQLite mirror of the PostgreSQL migrations. There is no citext or
+-- uuid type: emails are TEXT compared with NOCASE, and ids are TEXT
+-- defaulting to a random version 4 UUID. Times are TIMESTAMP text in
+-- UTC, so they compare as strings.
+--
+-- NOCASE only folds ASCII letters, where citext folds every letter the
+-- database locale knows. Emails that differ in the case of a non-ASCII
+-- letter, as in ÉLODIE@example.com and élodie@example.com, are the same
+-- address in PostgreSQL but two in SQLite.
+
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_ (
+    id_ TEXT DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))) PRIMARY KEY,
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    email_ TEXT COLLATE NOCASE UNIQUE NOT NULL,
+    password_hash_ BLOB NOT NULL,
+    version_ INTEGER NOT NULL DEFAULT 1
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS verification_token_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    scope_ TEXT NOT NULL,
+    email_ TEXT COLLATE NOCASE NOT NULL,
+    user_id_ TEXT REFERENCES user_(id_) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS verification_token_;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS authentication_token_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS authentication_token_;
+-- +goose StatementEnd
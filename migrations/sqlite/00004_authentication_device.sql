@@ -0,0 +1,40 @@
+-- SQLite cannot add columns with a non-constant default, so the table
+-- is rebuilt with them.
+
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE authentication_token_new_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    id_ TEXT NOT NULL UNIQUE DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_used_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    ip_ TEXT NOT NULL DEFAULT '',
+    user_agent_ TEXT NOT NULL DEFAULT '',
+    device_name_ TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO authentication_token_new_ (hash_, expiry_, user_id_)
+    SELECT hash_, expiry_, user_id_ FROM authentication_token_;
+
+DROP TABLE authentication_token_;
+
+ALTER TABLE authentication_token_new_ RENAME TO authentication_token_;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE authentication_token_new_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE
+);
+
+INSERT INTO authentication_token_new_ (hash_, expiry_, user_id_)
+    SELECT hash_, expiry_, user_id_ FROM authentication_token_;
+
+DROP TABLE authentication_token_;
+
+ALTER TABLE authentication_token_new_ RENAME TO authentication_token_;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- Scopes are stored as a JSON array in place of TEXT[].
+
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS personal_access_token_ (
+    id_ TEXT DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))) PRIMARY KEY,
+    hash_ BLOB UNIQUE NOT NULL,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    name_ TEXT NOT NULL,
+    scopes_ TEXT NOT NULL,
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_used_at_ TIMESTAMP,
+    expiry_ TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS personal_access_token_user_id_idx_
+    ON personal_access_token_ (user_id_);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS personal_access_token_;
+-- +goose StatementEnd
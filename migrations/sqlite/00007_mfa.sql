@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS totp_ (
+    user_id_ TEXT PRIMARY KEY REFERENCES user_ ON DELETE CASCADE,
+    secret_ BLOB NOT NULL,
+    confirmed_ BOOLEAN NOT NULL DEFAULT FALSE,
+    last_counter_ BIGINT NOT NULL DEFAULT 0,
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS recovery_code_ (
+    hash_ BLOB PRIMARY KEY,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenge_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    attempts_ INTEGER NOT NULL DEFAULT 0,
+    device_name_ TEXT NOT NULL DEFAULT '',
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS mfa_challenge_;
+DROP TABLE IF EXISTS recovery_code_;
+DROP TABLE IF EXISTS totp_;
+-- +goose StatementEnd
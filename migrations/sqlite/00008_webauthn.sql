@@ -0,0 +1,30 @@
+-- Credential and session data are JSON documents stored as BLOB in
+-- place of JSONB.
+
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS webauthn_credential_ (
+    id_ BLOB PRIMARY KEY,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    name_ TEXT NOT NULL,
+    data_ BLOB NOT NULL,
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_used_at_ TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credential_user_id_idx_
+    ON webauthn_credential_ (user_id_);
+
+CREATE TABLE IF NOT EXISTS webauthn_session_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    data_ BLOB NOT NULL,
+    user_id_ TEXT REFERENCES user_ ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webauthn_session_;
+DROP TABLE IF EXISTS webauthn_credential_;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS login_attempt_ (
+    email_ TEXT COLLATE NOCASE PRIMARY KEY,
+    failures_ INTEGER NOT NULL DEFAULT 0,
+    last_failure_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    locked_until_ TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_attempt_;
+-- +goose StatementEnd
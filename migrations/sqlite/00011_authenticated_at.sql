@@ -0,0 +1,64 @@
+-- SQLite cannot add columns with a non-constant default, so both
+-- tables are rebuilt with them. Existing tokens must not count as a
+-- recent login, so they are copied with when the session started, or
+-- the epoch for refresh tokens.
+
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE authentication_token_new_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    id_ TEXT NOT NULL UNIQUE DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_used_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    ip_ TEXT NOT NULL DEFAULT '',
+    user_agent_ TEXT NOT NULL DEFAULT '',
+    device_name_ TEXT NOT NULL DEFAULT '',
+    family_id_ TEXT NOT NULL DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    authenticated_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+INSERT INTO authentication_token_new_ (hash_, expiry_, user_id_, id_,
+    created_at_, last_used_at_, ip_, user_agent_, device_name_, family_id_,
+    authenticated_at_)
+    SELECT hash_, expiry_, user_id_, id_, created_at_, last_used_at_,
+    ip_, user_agent_, device_name_, family_id_, created_at_ FROM authentication_token_;
+
+DROP TABLE authentication_token_;
+
+ALTER TABLE authentication_token_new_ RENAME TO authentication_token_;
+
+CREATE INDEX IF NOT EXISTS authentication_token_family_id_idx_
+    ON authentication_token_ (family_id_);
+
+CREATE TABLE refresh_token_new_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    family_id_ TEXT NOT NULL,
+    used_ BOOLEAN NOT NULL DEFAULT FALSE,
+    user_id_ TEXT NOT NULL REFERENCES user_ ON DELETE CASCADE,
+    authenticated_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+INSERT INTO refresh_token_new_ (hash_, expiry_, family_id_, used_, user_id_,
+    authenticated_at_)
+    SELECT hash_, expiry_, family_id_, used_, user_id_,
+    '1970-01-01 00:00:00.000+00:00' FROM refresh_token_;
+
+DROP TABLE refresh_token_;
+
+ALTER TABLE refresh_token_new_ RENAME TO refresh_token_;
+
+CREATE INDEX IF NOT EXISTS refresh_token_family_id_idx_
+    ON refresh_token_ (family_id_);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE refresh_token_
+    DROP COLUMN authenticated_at_;
+
+ALTER TABLE authentication_token_
+    DROP COLUMN authenticated_at_;
+-- +goose StatementEnd
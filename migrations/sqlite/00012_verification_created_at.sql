@@ -0,0 +1,27 @@
+-- SQLite cannot add columns with a non-constant default, so the table
+-- is rebuilt with it.
+
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE verification_token_new_ (
+    hash_ BLOB PRIMARY KEY,
+    expiry_ TIMESTAMP NOT NULL,
+    scope_ TEXT NOT NULL,
+    email_ TEXT COLLATE NOCASE NOT NULL,
+    user_id_ TEXT REFERENCES user_(id_) ON DELETE CASCADE,
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+INSERT INTO verification_token_new_ (hash_, expiry_, scope_, email_, user_id_)
+    SELECT hash_, expiry_, scope_, email_, user_id_ FROM verification_token_;
+
+DROP TABLE verification_token_;
+
+ALTER TABLE verification_token_new_ RENAME TO verification_token_;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE verification_token_
+    DROP COLUMN created_at_;
+-- +goose StatementEnd
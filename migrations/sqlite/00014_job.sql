@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS job_ (
+    id_ TEXT DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + (random() & 3), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))) PRIMARY KEY,
+    created_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    kind_ TEXT NOT NULL,
+    payload_ BLOB NOT NULL,
+    status_ TEXT NOT NULL DEFAULT 'pending',
+    attempts_ INTEGER NOT NULL DEFAULT 0,
+    max_attempts_ INTEGER NOT NULL,
+    run_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    locked_until_ TIMESTAMP,
+    last_error_ TEXT
+);
+
+CREATE INDEX IF NOT EXISTS job_run_at_idx_ ON job_ (run_at_)
+    WHERE status_ IN ('pending', 'running');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS job_;
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- SQLite cannot change a primary key, so the table is rebuilt with
+-- the scope in it.
+
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE login_attempt_new_ (
+    scope_ TEXT NOT NULL,
+    email_ TEXT COLLATE NOCASE NOT NULL,
+    failures_ INTEGER NOT NULL DEFAULT 0,
+    last_failure_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    locked_until_ TIMESTAMP,
+    PRIMARY KEY (scope_, email_)
+);
+
+INSERT INTO login_attempt_new_ (scope_, email_, failures_, last_failure_at_, locked_until_)
+    SELECT 'login', email_, failures_, last_failure_at_, locked_until_ FROM login_attempt_;
+
+DROP TABLE login_attempt_;
+
+ALTER TABLE login_attempt_new_ RENAME TO login_attempt_;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE login_attempt_old_ (
+    email_ TEXT COLLATE NOCASE PRIMARY KEY,
+    failures_ INTEGER NOT NULL DEFAULT 0,
+    last_failure_at_ TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    locked_until_ TIMESTAMP
+);
+
+INSERT INTO login_attempt_old_ (email_, failures_, last_failure_at_, locked_until_)
+    SELECT email_, failures_, last_failure_at_, locked_until_ FROM login_attempt_
+    WHERE scope_ = 'login';
+
+DROP TABLE login_attempt_;
+
+ALTER TABLE login_attempt_old_ RENAME TO login_attempt_;
+-- +goose StatementEnd